@@ -1,15 +1,26 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
+	// time zones of search queries are available in minimal images
+	_ "time/tzdata"
 
 	"github.com/aph138/dekamond/internal/app"
 	"github.com/aph138/dekamond/internal/cache"
 	"github.com/aph138/dekamond/internal/db"
+	"github.com/aph138/dekamond/internal/entity"
 	"github.com/aph138/dekamond/pkg/authentication"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/redis/go-redis/v9"
@@ -19,13 +30,41 @@ import (
 type Config struct {
 	Port          int    `envconfig:"APP_PORT" default:"9000"`
 	DBAddress     string `envconfig:"DB_ADDRESS" required:"true"`
-	DBName        string `envconfig:"DB_NAME" required:"true"`
+	DBName        string `envconfig:"DB_NAME"`
 	DBUsername    string `envconfig:"DB_USERNAME"`
 	DBPassword    string `envconfig:"DB_PASSWORD"`
-	RedisAddress  string `envconfig:"REDIS_ADDRESS" require:"true"`
+	RedisAddress  string `envconfig:"REDIS_ADDRESS"` // in-memory cache is used if it's empty
 	RedisUsername string `envconfig:"REDIS_USERNAME"`
 	RedisPassword string `envconfig:"REDIS_PASSWORD"`
 	RedisDatabase int    `envconfig:"REDIS_PASSWORD" default:"0"`
+	// comma separated list of client_id:client_secret pairs which are allowed to call /introspect
+	IntrospectionClients map[string]string `envconfig:"INTROSPECTION_CLIENTS"`
+	// JWEMode is either empty (disabled), dir or RSA-OAEP-256
+	JWEMode string `envconfig:"JWE_MODE"`
+	// base64 encoded 32 bytes key for dir mode
+	JWEKey string `envconfig:"JWE_KEY"`
+	// path to a PEM encoded RSA private key for RSA-OAEP-256 mode
+	JWERSAKeyFile string `envconfig:"JWE_RSA_KEY_FILE"`
+	// audiences which can be requested at /check
+	TokenAudiences []string `envconfig:"TOKEN_AUDIENCES"`
+	// the user with this phone number is made admin on startup if there is no active admin, it's created if it doesn't exist
+	BootstrapAdminPhone string `envconfig:"BOOTSTRAP_ADMIN_PHONE"`
+	// require an OTP code from the old number when changing phone number
+	PhoneChangeConfirmOld bool `envconfig:"PHONE_CHANGE_CONFIRM_OLD" default:"true"`
+	// old phone numbers can't be registered by anyone else for this period
+	PhoneHoldPeriod time.Duration `envconfig:"PHONE_HOLD_PERIOD" default:"720h"`
+	// login events are removed after this period
+	LoginEventRetention time.Duration `envconfig:"LOGIN_EVENT_RETENTION" default:"2160h"`
+	// mongodb migrations are applied on startup, otherwise they should be applied by running the binary with the migrate argument
+	AutoMigrate bool `envconfig:"AUTO_MIGRATE" default:"true"`
+	// user statistics are cached for this period, zero disables caching
+	StatsCacheTTL time.Duration `envconfig:"STATS_CACHE_TTL" default:"5m"`
+	// OpenID Connect provider mode is enabled when issuer is set
+	OIDCIssuer   string `envconfig:"OIDC_ISSUER"`
+	OIDCLoginURL string `envconfig:"OIDC_LOGIN_URL"`
+	// path to a PEM encoded RSA private key for signing ID tokens,
+	// a new key is generated on every start if it's empty
+	OIDCSigningKeyFile string `envconfig:"OIDC_SIGNING_KEY_FILE"`
 }
 
 func main() {
@@ -34,33 +73,263 @@ func main() {
 		log.Fatal("err when processing env variables", err.Error())
 	}
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(cfg, logger); err != nil {
+			logger.Error(fmt.Sprintf("err when migrating database: %s", err.Error()))
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := importUsers(cfg, logger, os.Args[2:]); err != nil {
+			logger.Error(fmt.Sprintf("err when importing users: %s", err.Error()))
+			os.Exit(1)
+		}
+		return
+	}
 	jwtKey, err := authentication.GenerateKey(8)
 	if err != nil {
 		logger.Error(fmt.Sprintf("err when generating key for jwt: %s", err.Error()))
 		os.Exit(1)
 	}
-	jwt, err := authentication.NewJWT(jwtKey)
+	jwtOpts, err := jweOptions(cfg)
+	if err != nil {
+		logger.Error(fmt.Sprintf("err when loading JWE config: %s", err.Error()))
+		os.Exit(1)
+	}
+	jwt, err := authentication.NewJWT(jwtKey, jwtOpts...)
 	if err != nil {
 		logger.Error(fmt.Sprintf("err when creating JWT instance: %s", err.Error()))
 		os.Exit(1)
 	}
+	database, err := openDatabase(cfg)
+	if err != nil {
+		logger.Error(fmt.Sprintf("err when creating database instance: %s", err.Error()))
+		os.Exit(1)
+	}
+	if m, ok := database.(migrator); ok && !cfg.AutoMigrate {
+		pending, err := m.PendingMigrations(context.Background())
+		if err != nil {
+			logger.Error(fmt.Sprintf("err when checking migrations: %s", err.Error()))
+			os.Exit(1)
+		}
+		if len(pending) > 0 {
+			logger.Warn(fmt.Sprintf("database has pending migrations %v, run the binary with the migrate argument to apply them", pending))
+		}
+	}
+	if len(cfg.BootstrapAdminPhone) > 0 {
+		if err := bootstrapAdmin(database, cfg.BootstrapAdminPhone, logger); err != nil {
+			logger.Error(fmt.Sprintf("err when bootstrapping admin: %s", err.Error()))
+			os.Exit(1)
+		}
+	}
+	myCache, err := openCache(cfg, logger)
+	if err != nil {
+		logger.Error(fmt.Sprintf("err when creating cache instance %s", err.Error()))
+		os.Exit(1)
+	}
+	appOpts := []app.ApplicationOption{
+		app.WithIntrospectionClients(cfg.IntrospectionClients),
+		app.WithTokenAudiences(cfg.TokenAudiences...),
+		app.WithPhoneChange(cfg.PhoneChangeConfirmOld, cfg.PhoneHoldPeriod),
+		app.WithLoginEventRetention(cfg.LoginEventRetention),
+		app.WithStatsCacheTTL(cfg.StatsCacheTTL),
+	}
+	if len(cfg.OIDCIssuer) > 0 {
+		signer, err := idTokenSigner(cfg, logger)
+		if err != nil {
+			logger.Error(fmt.Sprintf("err when loading OIDC signing key: %s", err.Error()))
+			os.Exit(1)
+		}
+		appOpts = append(appOpts, app.WithOpenIDProvider(app.OpenIDConfig{
+			Issuer:   cfg.OIDCIssuer,
+			LoginURL: cfg.OIDCLoginURL,
+			Signer:   signer,
+		}))
+	}
+	myApp := app.NewApplication(logger, jwt, myCache, database, appOpts...)
+	myApp.Run(cfg.Port)
+}
+
+// openDatabase connects to PostgreSQL if DB_ADDRESS is a postgres:// URL,
+// opens an SQLite file if it's a sqlite:// URL, otherwise connects to mongodb
+func openDatabase(cfg Config) (db.Database, error) {
+	if strings.HasPrefix(cfg.DBAddress, "sqlite://") {
+		return db.NewSQLite(cfg.DBAddress, time.Second*10)
+	}
+	if strings.HasPrefix(cfg.DBAddress, "postgres://") || strings.HasPrefix(cfg.DBAddress, "postgresql://") {
+		return db.NewPostgres(cfg.DBAddress, cfg.DBUsername, cfg.DBPassword, time.Second*10)
+	}
+	if len(cfg.DBName) == 0 {
+		return nil, errors.New("DB_NAME is required for mongodb")
+	}
 	dbAuthOpt := options.Client().
 		SetAuth(options.Credential{Username: cfg.DBUsername, Password: cfg.DBPassword})
-	db, err := db.NewMongo(cfg.DBAddress, cfg.DBName, time.Second*10, dbAuthOpt)
+	return db.NewMongo(cfg.DBAddress, cfg.DBName, time.Second*10, dbAuthOpt, db.WithAutoMigrate(cfg.AutoMigrate))
+}
+
+// migrator is implemented by databases which can be migrated separately from opening them
+type migrator interface {
+	Migrate(context.Context) ([]string, error)
+	PendingMigrations(context.Context) ([]string, error)
+}
+
+// migrate applies the pending migrations of the database.
+// SQL databases are always migrated when they are opened.
+func migrate(cfg Config, logger *slog.Logger) error {
+	cfg.AutoMigrate = false
+	database, err := openDatabase(cfg)
 	if err != nil {
-		logger.Error(fmt.Sprintf("err when creating MyMongo instance: %s", err.Error()))
-		os.Exit(1)
+		return err
+	}
+	defer database.Close(context.Background())
+	m, ok := database.(migrator)
+	if !ok {
+		logger.Info("database is up to date")
+		return nil
+	}
+	applied, err := m.Migrate(context.Background())
+	for _, name := range applied {
+		logger.Info(fmt.Sprintf("migration %s is applied", name))
+	}
+	if err != nil {
+		return err
 	}
-	myRedis, err := cache.NewRedis(&redis.Options{
+	if len(applied) == 0 {
+		logger.Info("database is up to date")
+	}
+	return nil
+}
+
+// importUsers creates the users of a CSV or NDJSON file, which is the only argument.
+// The format is found by the file extension and the report of every row is written to stdout as JSON.
+func importUsers(cfg Config, logger *slog.Logger, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: import <file.csv|file.ndjson>")
+	}
+	var format string
+	switch strings.ToLower(filepath.Ext(args[0])) {
+	case ".csv":
+		format = app.FormatCSV
+	case ".ndjson", ".jsonl":
+		format = app.FormatNDJSON
+	default:
+		return fmt.Errorf("unsupported file extension of %s, it must be .csv or .ndjson", args[0])
+	}
+	file, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("err when opening file: %w", err)
+	}
+	defer file.Close()
+	database, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer database.Close(context.Background())
+	// held phone numbers are in the cache
+	myCache, err := openCache(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer myCache.Close(context.Background())
+	// the importer doesn't issue tokens
+	myApp := app.NewApplication(logger, nil, myCache, database)
+	report, err := myApp.ImportUsers(format, file)
+	if report != nil {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return fmt.Errorf("err when writing report: %w", err)
+		}
+	}
+	return err
+}
+
+// openCache connects to redis, or creates an in-memory cache if REDIS_ADDRESS is empty
+func openCache(cfg Config, logger *slog.Logger) (cache.Cache, error) {
+	if len(cfg.RedisAddress) == 0 {
+		logger.Warn("REDIS_ADDRESS is not set, using in-memory cache which is lost on restart and can't be shared between instances")
+		return cache.NewMemory(), nil
+	}
+	return cache.NewRedis(&redis.Options{
 		Addr:     cfg.RedisAddress,
 		DB:       cfg.RedisDatabase,
 		Username: cfg.RedisUsername,
 		Password: cfg.RedisPassword,
 	})
+}
+
+// bootstrapAdmin makes the user with the phone number admin if there is no active admin yet,
+// so the variable can't be used to take over an admin role later
+func bootstrapAdmin(database db.Database, phone string, logger *slog.Logger) error {
+	if !app.ValidPhone(phone) {
+		return fmt.Errorf("invalid BOOTSTRAP_ADMIN_PHONE %q", phone)
+	}
+	admins, err := database.SearchUser(
+		db.SearchUserByFilter(&db.FilterCondition{Field: "role", Op: db.FilterEq, Value: entity.RoleAdmin}),
+		db.SearchUserByStatus(entity.StatusActive),
+		db.SearchUserByPagination(1, 1),
+	)
 	if err != nil {
-		logger.Error(fmt.Sprintf("err when creating MyRedis instance %s", err.Error()))
-		os.Exit(1)
+		return err
 	}
-	myApp := app.NewApplication(logger, jwt, myRedis, db)
-	myApp.Run(cfg.Port)
+	if len(admins.Users) > 0 {
+		logger.Info("an admin already exists, BOOTSTRAP_ADMIN_PHONE is ignored")
+		return nil
+	}
+	admin, err := database.GrantRoleByPhone(phone, entity.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	logger.Info(fmt.Sprintf("user %s has admin role", admin.ID.Hex()))
+	return nil
+}
+
+// jweOptions returns the JWT options for token encryption based on config
+func jweOptions(cfg Config) ([]authentication.JWTOption, error) {
+	switch cfg.JWEMode {
+	case "":
+		return nil, nil
+	case authentication.EncryptionDirect:
+		key, err := base64.StdEncoding.DecodeString(cfg.JWEKey)
+		if err != nil {
+			return nil, fmt.Errorf("err when decoding JWE_KEY: %w", err)
+		}
+		return []authentication.JWTOption{authentication.WithDirectEncryption(key)}, nil
+	case authentication.EncryptionRSAOAEP:
+		data, err := os.ReadFile(cfg.JWERSAKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("err when reading JWE_RSA_KEY_FILE: %w", err)
+		}
+		key, err := authentication.ParseRSAPrivateKey(data)
+		if err != nil {
+			return nil, err
+		}
+		return []authentication.JWTOption{authentication.WithRSAEncryption(key)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported JWE_MODE %q", cfg.JWEMode)
+	}
+}
+
+// idTokenSigner loads the ID token signing key or generates a temporary one
+func idTokenSigner(cfg Config, logger *slog.Logger) (*authentication.IDTokenSigner, error) {
+	var key *rsa.PrivateKey
+	if len(cfg.OIDCSigningKeyFile) > 0 {
+		data, err := os.ReadFile(cfg.OIDCSigningKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("err when reading OIDC_SIGNING_KEY_FILE: %w", err)
+		}
+		key, err = authentication.ParseRSAPrivateKey(data)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		logger.Warn("OIDC_SIGNING_KEY_FILE is not set, ID tokens will be invalid after restart")
+		var err error
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, fmt.Errorf("err when generating rsa key: %w", err)
+		}
+	}
+	return authentication.NewIDTokenSigner(key)
 }
@@ -15,9 +15,127 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/.well-known/jwks.json": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oidc"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    }
+                }
+            }
+        },
+        "/.well-known/openid-configuration": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oidc"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.DiscoveryDocument"
+                        }
+                    }
+                }
+            }
+        },
+        "/authorize": {
+            "get": {
+                "description": "Starts the authorization code flow with PKCE. The request is kept for 10 minutes and the user is redirected to the login page with auth_request query parameter. The login page must send auth_request along with the OTP code to /check. If no login page is configured, the auth_request ID is returned as JSON.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oidc"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "must be code",
+                        "name": "response_type",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "registered client ID",
+                        "name": "client_id",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "one of the registered redirect URIs",
+                        "name": "redirect_uri",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "example": "openid phone",
+                        "description": "space separated scopes, must contain openid",
+                        "name": "scope",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "opaque value which is returned to the client",
+                        "name": "state",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "value which is put in the ID token",
+                        "name": "nonce",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "PKCE code challenge",
+                        "name": "code_challenge",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "must be S256",
+                        "name": "code_challenge_method",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.AuthorizeResponse"
+                        }
+                    },
+                    "302": {
+                        "description": "redirect to the login page or to the client with an error"
+                    },
+                    "400": {
+                        "description": "invalid client or redirect URI",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
         "/check": {
             "post": {
-                "description": "Accepts a phone number and an OTP code and return JWT token if they are valid",
+                "description": "Accepts a phone number and an OTP code and return JWT token if they are valid.\nIf auth_request is set, the JSON of CheckAuthorizeResponse is returned instead, which holds the client redirect URI with an authorization code.",
                 "consumes": [
                     "application/json"
                 ],
@@ -40,139 +158,2046 @@ const docTemplate = `{
                 ],
                 "responses": {
                     "200": {
-                        "description": "JWT containing user ID",
+                        "description": "JWT containing user ID, encrypted as JWE if encryption is enabled",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid phone number or unknown audience",
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "403": {
+                        "description": "the account is not active, error is one of account_suspended, account_banned and account_deleted",
+                        "schema": {
+                            "$ref": "#/definitions/app.OAuthError"
+                        }
                     }
                 }
             }
         },
-        "/login": {
+        "/clients": {
             "post": {
-                "description": "Accepts a phone number and create an OTP code if the phone number is valid and no OTP code is currently valid that number.",
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Creates a client. The secret is returned only in this response. Only admins are allowed.",
                 "consumes": [
                     "application/json"
                 ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oidc"
+                ],
                 "parameters": [
                     {
-                        "description": "valid phone number as string",
+                        "description": "client details",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/app.LoginRequest"
+                            "$ref": "#/definitions/app.ClientRequest"
                         }
                     }
                 ],
                 "responses": {
                     "201": {
-                        "description": "No Content"
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/app.ClientResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid request",
+                        "schema": {
+                            "type": "string"
+                        }
                     }
                 }
             }
         },
-        "/search": {
-            "get": {
-                "description": "Retrieve users",
+        "/introspect": {
+            "post": {
+                "description": "Returns the state of a token as defined by RFC 7662. Revoked tokens, tokens of signed out sessions and tokens of users which aren't active are inactive. Callers must authenticate with client credentials, either by basic auth or client_id and client_secret form fields.",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "user"
+                    "oauth"
                 ],
                 "parameters": [
                     {
                         "type": "string",
-                        "example": "09012345678",
-                        "description": "A valid phone number for searching a specific user.",
-                        "name": "phone",
-                        "in": "query"
+                        "description": "the token to introspect",
+                        "name": "token",
+                        "in": "formData",
+                        "required": true
                     },
                     {
                         "type": "string",
-                        "example": "2024-01-01,2025-10-12",
-                        "description": "A date range to search for users who registered within that period in YYYY-MM-DD format, separated by a comma.",
-                        "name": "register",
-                        "in": "query"
-                    },
-                    {
-                        "type": "integer",
-                        "description": "The page number of the results. Default is 1. Negative numbers and zero are treated as 1.",
-                        "name": "page",
-                        "in": "query"
-                    },
-                    {
-                        "type": "integer",
-                        "description": "The number of items per page. Default is 10. Negative numbers and zero are treated as 1.",
-                        "name": "limit",
-                        "in": "query"
+                        "description": "ignored, only access tokens are supported",
+                        "name": "token_type_hint",
+                        "in": "formData"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/app.SearchResponse"
+                            "$ref": "#/definitions/app.IntrospectionResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/app.OAuthError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/app.OAuthError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/app.OAuthError"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "app.CheckRequest": {
-            "type": "object",
-            "properties": {
-                "code": {
-                    "type": "string",
-                    "example": "123456"
-                },
-                "phone": {
-                    "type": "string",
-                    "example": "09012345678"
-                }
-            }
         },
-        "app.LoginRequest": {
-            "type": "object",
-            "properties": {
-                "phone": {
-                    "type": "string",
-                    "example": "09012345678"
+        "/login": {
+            "post": {
+                "description": "Accepts a phone number and create an OTP code if the phone number is valid and no OTP code is currently valid that number.",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "login"
+                ],
+                "parameters": [
+                    {
+                        "description": "valid phone number as string",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/app.LoginRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "No Content"
+                    },
+                    "409": {
+                        "description": "the phone number is an old number of another user which is still on hold",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
                 }
             }
         },
-        "app.SearchResponse": {
-            "type": "object",
-            "properties": {
-                "code": {
-                    "type": "integer"
-                },
-                "result": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/entity.User"
+        "/logout": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Revokes the token which is sent in Authorization header and removes its session",
+                "tags": [
+                    "login"
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "401": {
+                        "description": "unauthorized access",
+                        "schema": {
+                            "type": "string"
+                        }
                     }
                 }
             }
         },
-        "entity.User": {
-            "type": "object",
-            "properties": {
-                "id": {
-                    "type": "string"
-                },
-                "last_login": {
-                    "type": "string"
-                },
-                "phone": {
-                    "type": "string"
-                },
-                "register_at": {
-                    "type": "string"
+        "/me": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the user which owns the token",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "me"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.UserResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized access",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
                 }
-            }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Deletes the account in two steps. Without a code, an OTP code is sent to the phone number of the account.\nWith the code, the user, its sessions, its login history and its OTP state are removed and every token of the user is rejected afterwards.",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "me"
+                ],
+                "parameters": [
+                    {
+                        "description": "the OTP code",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/app.DeleteAccountRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "OTP code is sent"
+                    },
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "401": {
+                        "description": "invalid code",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "rate limit exceeded",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Changes the profile fields which are sent. Send an empty string to remove a field.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "me"
+                ],
+                "parameters": [
+                    {
+                        "description": "profile fields",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/app.UpdateProfileRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.UserResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid field",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized access",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/me/export": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a JSON archive of everything which is stored about the user.\nAudit records of admin actions on the account aren't included, they belong to the admins who did them.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "me"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.ExportResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized access",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/me/phone": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Sends an OTP code to the new phone number, and to the old one if confirming with the old number is enabled. The change is applied by /me/phone/verify within 10 minutes.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "me"
+                ],
+                "parameters": [
+                    {
+                        "description": "the new phone number",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/app.ChangePhoneRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/app.ChangePhoneResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid phone number",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "phone number is not available",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "a valid code still exists",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/me/phone/verify": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Verifies the OTP codes and changes the phone number. All sessions are signed out afterwards and the old number can't be registered by anyone else during the hold period.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "me"
+                ],
+                "parameters": [
+                    {
+                        "description": "OTP codes",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/app.VerifyPhoneChangeRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.UserResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "no pending phone change",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "invalid code",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "phone number is not available",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "rate limit exceeded",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/me/sessions": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the devices which the user is logged in with",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "me"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.SessionsResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized access",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/me/sessions/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Removes a session of the user, tokens of that session are rejected afterwards",
+                "tags": [
+                    "me"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "session ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "401": {
+                        "description": "unauthorized access",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "session not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/search": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Retrieve users. Only support and admins are allowed. Link headers (RFC 8288) point to the next and previous pages.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "09012345678",
+                        "description": "A valid phone number for searching a specific user.",
+                        "name": "phone",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "0912345**67",
+                        "description": "The leading digits of phone numbers, at least 7 digits. Unknown digits can be masked by *, then the whole number must match. Only admins are allowed, since other roles get masked phone numbers.",
+                        "name": "phone_prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "2024-01-01,2025-10-12",
+                        "description": "A range to search for users who registered within that period, two dates in YYYY-MM-DD format or RFC 3339 datetimes separated by a comma. Either side can be empty for an open range. A date as the end includes the whole day.",
+                        "name": "register",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "2025-01-01T00:00:00+03:30,",
+                        "description": "A range of the last login time in the same format as register. Users who have never logged in don't match.",
+                        "name": "last_login",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "Asia/Tehran",
+                        "description": "The IANA time zone of dates without time in register and last_login. Default is UTC.",
+                        "name": "tz",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "-last_login",
+                        "description": "One of register_at and last_login, with a leading - for descending order. Default is -register_at.",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Account status, one of active, suspended, banned and deleted.",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "status:active AND register_at\u003e=2025-01-01 AND role:admin",
+                        "description": "A filter expression which is joined with the other filters. Conditions are a field, an operator and a value, e.g. role:admin. The fields are phone, register_at, last_login, status, role, display_name, email and locale. Every field is compared by : and times by \u003e, \u003e=, \u003c and \u003c= as well. Times are dates in the tz or RFC 3339 datetimes and phone numbers can be masked by *. Values with spaces are put in double quotes. Conditions are joined by AND and OR, negated by NOT and grouped by parentheses. Errors point to the position in the expression. Only admins are allowed.",
+                        "name": "q",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "The page number of the results. Default is 1. Negative numbers and zero are treated as 1.",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The next_cursor of the previous response. Unlike page, it doesn't skip or repeat users who register in the meantime. It can't be used with page.",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "The number of items per page. Default is 10 and maximum is 100. Negative numbers and zero are treated as 1.",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Whether total is counted. Default is true. Counting is slow on very large result sets.",
+                        "name": "count",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "id,phone",
+                        "description": "Comma separated fields of users to return. Default is every field which the caller is allowed to read. Support staff can't read email and avatar_url and get phone numbers with masked middle digits.",
+                        "name": "fields",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.SearchResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid query",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized access",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden or a field which isn't allowed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/stats/users": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Counts new registrations and active users in every day, week or month of the range. Active users are counted in the interval of their last login.\nIntervals start at midnight in tz and weeks start on Monday. Results are cached for a while, so the latest interval may be behind. Only admins are allowed.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "stats"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "One of day, week and month. Default is day.",
+                        "name": "interval",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "2025-01-01",
+                        "description": "A date in YYYY-MM-DD format or an RFC 3339 datetime. Default is 30 days, 12 weeks or 12 months ago.",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "2025-06-30",
+                        "description": "A date in YYYY-MM-DD format, which includes the whole day, or an RFC 3339 datetime. Default is today.",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "Asia/Tehran",
+                        "description": "The IANA time zone of dates and intervals. Default is UTC.",
+                        "name": "tz",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.UserStatsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid query",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized access",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/token": {
+            "post": {
+                "description": "Exchanges an authorization code and its PKCE code verifier for an access token and an ID token",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oidc"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "must be authorization_code",
+                        "name": "grant_type",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "authorization code",
+                        "name": "code",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "the redirect URI which was sent to /authorize",
+                        "name": "redirect_uri",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "PKCE code verifier",
+                        "name": "code_verifier",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "required if basic auth isn't used",
+                        "name": "client_id",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "required for confidential clients if basic auth isn't used",
+                        "name": "client_secret",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.TokenResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/app.OAuthError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/app.OAuthError"
+                        }
+                    }
+                }
+            }
+        },
+        "/userinfo": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns claims about the owner of the access token. Phone claims are returned only if the token has phone scope.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oidc"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.UserInfoResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized access",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "insufficient_scope if the token doesn't have openid scope",
+                        "schema": {
+                            "$ref": "#/definitions/app.OAuthError"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/export": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Streams every user which matches the filters as CSV or newline delimited JSON. It accepts the same filters and sort as /search, pagination is ignored.\nThe export is recorded in the audit log with the filters. Only admins are allowed.",
+                "produces": [
+                    "text/csv",
+                    "application/x-ndjson"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "One of csv and ndjson. Default is csv.",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Same as /search",
+                        "name": "phone",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Same as /search",
+                        "name": "phone_prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Same as /search",
+                        "name": "register",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Same as /search",
+                        "name": "last_login",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Same as /search",
+                        "name": "tz",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Same as /search",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Same as /search",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Same as /search",
+                        "name": "q",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "one user per row or line",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid query",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized access",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/import": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Creates the users of a CSV or NDJSON file and leaves the users which already exist unchanged. Phone numbers such as +98 912 123 4567 are normalized.\nCSV needs a header with a phone column and an optional register_at column, NDJSON needs objects with phone and optional register_at fields, so exports can be imported. Dates are in YYYY-MM-DD format or RFC 3339 datetimes.\nThe response reports every row as created, existing or invalid. Files larger than 32 MB are imported by running the binary with the import argument. Only admins are allowed.",
+                "consumes": [
+                    "text/csv",
+                    "application/x-ndjson"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "One of csv and ndjson. Default is csv.",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "description": "the rows",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.ImportResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid file",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized access",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "413": {
+                        "description": "the file is too large",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a user by ID. Only admins are allowed.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "user ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated fields to return, same as /search",
+                        "name": "fields",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.UserResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid user ID",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "user not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Deletes a user with its sessions and login events, so the user is signed out everywhere. Admins can't delete themselves. The deletion is recorded in the audit log. Only admins are allowed.",
+                "tags": [
+                    "user"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "user ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "invalid user ID",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "user not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Changes the profile fields and the phone number of a user which are sent. Send an empty string to remove a profile field. Same as a change by the user, every session of the user is signed out and the old phone number can't be registered by anyone else during the hold period. The change is recorded in the audit log. Only admins are allowed.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "user ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "fields to change",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/app.UpdateUserRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.UserResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid field",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "user not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "phone number is not available",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/events": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the latest OTP requests, OTP verifications and token issuances of a user, newest first. Phone numbers are masked for support staff like /search. Only support staff and admins are allowed.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "user ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "The number of events. Default is 50 and maximum is 500.",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.LoginEventsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid value for limit",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "user not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/roles/{role}": {
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Grants a role to a user. The role is put in tokens which are issued afterwards. Only admins are allowed.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "user ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "one of user, support and admin",
+                        "name": "role",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.UserResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid role",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "user not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Revokes a role from a user and signs out all of the user's sessions, so tokens with the old roles are rejected. Admins can't revoke their own admin role. Only admins are allowed.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "user ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "one of user, support and admin",
+                        "name": "role",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.UserResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid role",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "user not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/status": {
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Changes the account status of a user. Suspended, banned and deleted users can't log in and their tokens are rejected. Admins can't change their own status. Only admins are allowed.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "user ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "one of active, suspended, banned and deleted",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/app.SetStatusRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.UserResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid status",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "user not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "app.AuthorizeResponse": {
+            "type": "object",
+            "properties": {
+                "auth_request": {
+                    "type": "string"
+                }
+            }
+        },
+        "app.ChangePhoneRequest": {
+            "type": "object",
+            "properties": {
+                "phone": {
+                    "type": "string",
+                    "example": "09012345678"
+                }
+            }
+        },
+        "app.ChangePhoneResponse": {
+            "type": "object",
+            "properties": {
+                "confirm_old": {
+                    "description": "ConfirmOld is true if an OTP code is sent to the old number as well",
+                    "type": "boolean"
+                }
+            }
+        },
+        "app.CheckRequest": {
+            "type": "object",
+            "properties": {
+                "audience": {
+                    "description": "Audience is optional, it must be one of the configured audiences",
+                    "type": "string",
+                    "example": "mobile-app"
+                },
+                "auth_request": {
+                    "description": "AuthRequest is the ID which /authorize passes to the login page.\nIf it's set, an authorization code is issued instead of a token.",
+                    "type": "string"
+                },
+                "code": {
+                    "type": "string",
+                    "example": "123456"
+                },
+                "device": {
+                    "description": "Device is an optional name which is shown in the list of sessions",
+                    "type": "string",
+                    "example": "Pixel 8"
+                },
+                "phone": {
+                    "type": "string",
+                    "example": "09012345678"
+                }
+            }
+        },
+        "app.ClientRequest": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string",
+                    "example": "My App"
+                },
+                "public": {
+                    "description": "Public clients have no secret and must use PKCE, e.g. mobile and single page apps",
+                    "type": "boolean"
+                },
+                "redirect_uris": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "https://app.example.com/callback"
+                    ]
+                }
+            }
+        },
+        "app.ClientResponse": {
+            "type": "object",
+            "properties": {
+                "client_id": {
+                    "type": "string"
+                },
+                "client_secret": {
+                    "description": "ClientSecret is returned only once when the client is created",
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "redirect_uris": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "app.DeleteAccountRequest": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "Code is the OTP code which is sent to the phone number of the account",
+                    "type": "string",
+                    "example": "123456"
+                }
+            }
+        },
+        "app.DiscoveryDocument": {
+            "type": "object",
+            "properties": {
+                "authorization_endpoint": {
+                    "type": "string"
+                },
+                "claims_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "code_challenge_methods_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "grant_types_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "id_token_signing_alg_values_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "introspection_endpoint": {
+                    "type": "string"
+                },
+                "issuer": {
+                    "type": "string"
+                },
+                "jwks_uri": {
+                    "type": "string"
+                },
+                "response_types_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "scopes_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "subject_types_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "token_endpoint": {
+                    "type": "string"
+                },
+                "token_endpoint_auth_methods_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "userinfo_endpoint": {
+                    "type": "string"
+                }
+            }
+        },
+        "app.ExportResponse": {
+            "type": "object",
+            "properties": {
+                "exported_at": {
+                    "type": "string"
+                },
+                "login_events": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.LoginEvent"
+                    }
+                },
+                "sessions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.Session"
+                    }
+                },
+                "user": {
+                    "$ref": "#/definitions/entity.User"
+                }
+            }
+        },
+        "app.ImportReport": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "integer"
+                },
+                "existing": {
+                    "type": "integer"
+                },
+                "invalid": {
+                    "type": "integer"
+                },
+                "rows": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/app.ImportRow"
+                    }
+                }
+            }
+        },
+        "app.ImportResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "result": {
+                    "$ref": "#/definitions/app.ImportReport"
+                }
+            }
+        },
+        "app.ImportRow": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "line": {
+                    "description": "Line is the line number of the row in the file",
+                    "type": "integer"
+                },
+                "outcome": {
+                    "type": "string"
+                },
+                "phone": {
+                    "description": "Phone is the normalized phone number, it's empty if the row has no valid number",
+                    "type": "string"
+                }
+            }
+        },
+        "app.IntrospectionResponse": {
+            "type": "object",
+            "properties": {
+                "active": {
+                    "type": "boolean"
+                },
+                "aud": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "client_id": {
+                    "type": "string"
+                },
+                "exp": {
+                    "type": "integer"
+                },
+                "iat": {
+                    "type": "integer"
+                },
+                "iss": {
+                    "type": "string"
+                },
+                "jti": {
+                    "type": "string"
+                },
+                "nbf": {
+                    "type": "integer"
+                },
+                "roles": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "scope": {
+                    "type": "string"
+                },
+                "sub": {
+                    "type": "string"
+                },
+                "token_type": {
+                    "type": "string"
+                }
+            }
+        },
+        "app.LoginEventsResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "result": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.LoginEvent"
+                    }
+                }
+            }
+        },
+        "app.LoginRequest": {
+            "type": "object",
+            "properties": {
+                "phone": {
+                    "type": "string",
+                    "example": "09012345678"
+                }
+            }
+        },
+        "app.OAuthError": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string",
+                    "example": "invalid_request"
+                },
+                "error_description": {
+                    "type": "string"
+                }
+            }
+        },
+        "app.SearchResponse": {
+            "description": "This an example OTP implementation",
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "has_more": {
+                    "type": "boolean"
+                },
+                "limit": {
+                    "type": "integer",
+                    "example": 10
+                },
+                "next_cursor": {
+                    "description": "NextCursor is sent as cursor for getting the next page, it's empty on the last page",
+                    "type": "string"
+                },
+                "page": {
+                    "description": "Page is omitted when a cursor is used",
+                    "type": "integer",
+                    "example": 3
+                },
+                "result": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.User"
+                    }
+                },
+                "total": {
+                    "description": "Total is the number of matching users, it's omitted when counting is skipped",
+                    "type": "integer",
+                    "example": 1200
+                }
+            }
+        },
+        "app.SessionResponse": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "current": {
+                    "description": "Current is true for the session of the token which is used for the request",
+                    "type": "boolean"
+                },
+                "device_name": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "description": "ExpiresAt is the expiration time of the token, expired sessions are removed automatically",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "ip": {
+                    "type": "string"
+                },
+                "last_seen_at": {
+                    "type": "string"
+                },
+                "user_agent": {
+                    "type": "string"
+                }
+            }
+        },
+        "app.SessionsResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "result": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/app.SessionResponse"
+                    }
+                }
+            }
+        },
+        "app.SetStatusRequest": {
+            "type": "object",
+            "properties": {
+                "status": {
+                    "type": "string",
+                    "example": "suspended"
+                }
+            }
+        },
+        "app.TokenResponse": {
+            "type": "object",
+            "properties": {
+                "access_token": {
+                    "type": "string"
+                },
+                "expires_in": {
+                    "type": "integer",
+                    "example": 3600
+                },
+                "id_token": {
+                    "type": "string"
+                },
+                "scope": {
+                    "type": "string",
+                    "example": "openid phone"
+                },
+                "token_type": {
+                    "type": "string",
+                    "example": "Bearer"
+                }
+            }
+        },
+        "app.UpdateProfileRequest": {
+            "type": "object",
+            "properties": {
+                "avatar_url": {
+                    "type": "string",
+                    "example": "https://cdn.example.com/avatar.png"
+                },
+                "display_name": {
+                    "type": "string",
+                    "example": "Ali"
+                },
+                "email": {
+                    "type": "string",
+                    "example": "ali@example.com"
+                },
+                "locale": {
+                    "type": "string",
+                    "example": "fa-IR"
+                }
+            }
+        },
+        "app.UpdateUserRequest": {
+            "type": "object",
+            "properties": {
+                "avatar_url": {
+                    "type": "string",
+                    "example": "https://cdn.example.com/avatar.png"
+                },
+                "display_name": {
+                    "type": "string",
+                    "example": "Ali"
+                },
+                "email": {
+                    "type": "string",
+                    "example": "ali@example.com"
+                },
+                "locale": {
+                    "type": "string",
+                    "example": "fa-IR"
+                },
+                "phone": {
+                    "description": "Phone is changed without an OTP code, it can't be removed",
+                    "type": "string",
+                    "example": "09012345678"
+                }
+            }
+        },
+        "app.UserInfoResponse": {
+            "type": "object",
+            "properties": {
+                "phone_number": {
+                    "type": "string",
+                    "example": "+989012345678"
+                },
+                "phone_number_verified": {
+                    "type": "boolean"
+                },
+                "sub": {
+                    "type": "string"
+                }
+            }
+        },
+        "app.UserResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "result": {
+                    "$ref": "#/definitions/entity.User"
+                }
+            }
+        },
+        "app.UserStatsResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "interval": {
+                    "type": "string"
+                },
+                "result": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/db.UserStatsBucket"
+                    }
+                },
+                "tz": {
+                    "type": "string"
+                }
+            }
+        },
+        "app.VerifyPhoneChangeRequest": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "Code is the OTP code which is sent to the new number",
+                    "type": "string",
+                    "example": "123456"
+                },
+                "old_code": {
+                    "description": "OldCode is the OTP code which is sent to the old number, if it's required",
+                    "type": "string",
+                    "example": "654321"
+                }
+            }
+        },
+        "db.UserStatsBucket": {
+            "type": "object",
+            "properties": {
+                "active_users": {
+                    "description": "ActiveUsers is the number of users whose last login is in the interval.\nOnly the last login of users is stored, so users who logged in later are counted in a later interval.",
+                    "type": "integer"
+                },
+                "registrations": {
+                    "description": "Registrations is the number of users who registered in the interval",
+                    "type": "integer"
+                },
+                "start": {
+                    "description": "Start is the beginning of the interval",
+                    "type": "string"
+                }
+            }
+        },
+        "entity.LoginEvent": {
+            "type": "object",
+            "properties": {
+                "channel": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "ip": {
+                    "type": "string"
+                },
+                "latency_ms": {
+                    "description": "LatencyMS is the time which handling the request took in milliseconds",
+                    "type": "integer"
+                },
+                "outcome": {
+                    "type": "string"
+                },
+                "phone": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                },
+                "user_agent": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "description": "UserID is empty for events which happen before the user is known, such as OTP requests",
+                    "type": "string"
+                }
+            }
+        },
+        "entity.Session": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "device_name": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "description": "ExpiresAt is the expiration time of the token, expired sessions are removed automatically",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "ip": {
+                    "type": "string"
+                },
+                "last_seen_at": {
+                    "type": "string"
+                },
+                "user_agent": {
+                    "type": "string"
+                }
+            }
+        },
+        "entity.User": {
+            "type": "object",
+            "properties": {
+                "avatar_url": {
+                    "type": "string"
+                },
+                "display_name": {
+                    "description": "profile fields which are set by the user",
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "last_login": {
+                    "type": "string"
+                },
+                "locale": {
+                    "type": "string"
+                },
+                "phone": {
+                    "type": "string"
+                },
+                "register_at": {
+                    "type": "string"
+                },
+                "roles": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        }
+    },
+    "securityDefinitions": {
+        "BearerAuth": {
+            "description": "Type \"Bearer\" followed by a space and the JWT token.",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
         }
     }
 }`
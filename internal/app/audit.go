@@ -0,0 +1,33 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aph138/dekamond/internal/entity"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// recordAudit stores an audit record of an action which the principal of the request does.
+// targetID is empty for actions on many users.
+// Unlike login events, the action must not be done if it can't be recorded, so the error is returned.
+func (a *Application) recordAudit(r *http.Request, action, targetID string, details map[string]string) error {
+	principal, _ := PrincipalFromContext(r.Context())
+	actorID, err := bson.ObjectIDFromHex(principal.UserID)
+	if err != nil {
+		return fmt.Errorf("err when parsing actor id: %w", err)
+	}
+	record := &entity.AuditRecord{
+		ActorID:   actorID,
+		Action:    action,
+		TargetID:  targetID,
+		Details:   details,
+		IP:        clientIP(r),
+		CreatedAt: time.Now(),
+	}
+	if err := a.db.SaveAuditRecord(record); err != nil {
+		return fmt.Errorf("err when saving audit record: %w", err)
+	}
+	return nil
+}
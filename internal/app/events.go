@@ -0,0 +1,100 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aph138/dekamond/internal/db"
+	"github.com/aph138/dekamond/internal/entity"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// phoneEventWindow is the time before registration which events of the phone number belong to the user,
+// it covers the OTP request of the first login
+const phoneEventWindow = time.Minute * 10
+
+// phoneEventsSince returns the time which the events of the user's phone number which aren't linked to
+// any user belong to the user, older ones may belong to a previous owner of the number
+func phoneEventsSince(user *entity.User) time.Time {
+	return user.RegisteredAt.Add(-phoneEventWindow)
+}
+
+type LoginEventsResponse struct {
+	Code   int                 `json:"code"`
+	Result []entity.LoginEvent `json:"result"`
+}
+
+// recordLoginEvent stores a login event of the request which is started at start.
+// userID is empty if the user isn't known yet.
+// Failing to store the event doesn't fail the request, it's only logged.
+func (a *Application) recordLoginEvent(r *http.Request, start time.Time, userID, phone, eventType, channel, outcome string) {
+	now := time.Now()
+	event := &entity.LoginEvent{
+		Phone:     phone,
+		Type:      eventType,
+		Channel:   channel,
+		Outcome:   outcome,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		LatencyMS: now.Sub(start).Milliseconds(),
+		CreatedAt: now,
+		ExpiresAt: now.Add(a.loginEventRetention),
+	}
+	if objectID, err := bson.ObjectIDFromHex(userID); err == nil {
+		event.UserID = objectID
+	}
+	if err := a.db.SaveLoginEvent(event); err != nil {
+		a.logger.Error(fmt.Sprintf("err when saving login event: %s", err.Error()))
+	}
+}
+
+// @Summery		List login events
+// @Description	Returns the latest OTP requests, OTP verifications and token issuances of a user, newest first. Phone numbers are masked for support staff like /search. Only support staff and admins are allowed.
+// @Tags			user
+// @Produce		json
+// @Security		BearerAuth
+// @Param			id		path		string	true	"user ID"
+// @Param			limit	query		int		false	"The number of events. Default is 50 and maximum is 500."
+// @Success		200		{object}	LoginEventsResponse
+// @Failure		400		{string}	string	"invalid value for limit"
+// @Failure		404		{string}	string	"user not found"
+// @Router			/users/{id}/events [get]
+func (a *Application) ListLoginEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var limit int64 = 50
+	if limitQuery := r.URL.Query().Get("limit"); len(limitQuery) > 0 {
+		var err error
+		limit, err = strconv.ParseInt(limitQuery, 10, 64)
+		if err != nil || limit < 1 {
+			http.Error(w, "invalid value for limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, 500)
+	}
+	user, err := a.db.FindUser(r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		a.logger.Error(fmt.Sprintf("err when finding user at /users/{id}/events: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	events, err := a.db.ListLoginEvents(user.ID.Hex(), user.Phone, phoneEventsSince(user), limit)
+	if err != nil {
+		a.logger.Error(fmt.Sprintf("err when listing login events: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	// same as /search, support staff could read the numbers which are masked there
+	principal, _ := PrincipalFromContext(r.Context())
+	if !principal.HasRole(entity.RoleAdmin) {
+		for i := range events {
+			events[i].Phone = maskPhone(events[i].Phone)
+		}
+	}
+	a.writeJSON(w, http.StatusOK, LoginEventsResponse{Code: http.StatusOK, Result: events})
+}
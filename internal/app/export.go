@@ -0,0 +1,227 @@
+package app
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aph138/dekamond/internal/db"
+	"github.com/aph138/dekamond/internal/entity"
+)
+
+// formats of user exports and imports
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// formatContentTypes maps every format to its content type
+var formatContentTypes = map[string]string{
+	FormatCSV:    "text/csv; charset=utf-8",
+	FormatNDJSON: "application/x-ndjson",
+}
+
+// exportFlushInterval is the number of users which are written between flushes
+const exportFlushInterval = 500
+
+// userWriter writes users in an export format
+type userWriter interface {
+	WriteUser(entity.User) error
+	// Flush writes the buffered users to the underlying writer
+	Flush() error
+}
+
+func newUserWriter(format string, w io.Writer) userWriter {
+	if format == FormatNDJSON {
+		return &ndjsonUserWriter{encoder: json.NewEncoder(w)}
+	}
+	return &csvUserWriter{writer: csv.NewWriter(w)}
+}
+
+// csvColumns is the header of CSV exports
+var csvColumns = db.UserFields
+
+type csvUserWriter struct {
+	writer *csv.Writer
+	header bool
+}
+
+func (c *csvUserWriter) WriteUser(user entity.User) error {
+	if !c.header {
+		if err := c.writer.Write(csvColumns); err != nil {
+			return err
+		}
+		c.header = true
+	}
+	return c.writer.Write([]string{
+		user.ID.Hex(),
+		user.Phone,
+		formatExportTime(user.RegisteredAt),
+		formatExportTime(user.LastLogin),
+		// profile fields are set by users
+		escapeCSVFormula(user.DisplayName),
+		escapeCSVFormula(user.Email),
+		escapeCSVFormula(user.Locale),
+		escapeCSVFormula(user.AvatarURL),
+		strings.Join(user.GetRoles(), ";"),
+		user.GetStatus(),
+	})
+}
+
+func (c *csvUserWriter) Flush() error {
+	if !c.header {
+		// the header is written even if no user matches
+		if err := c.writer.Write(csvColumns); err != nil {
+			return err
+		}
+		c.header = true
+	}
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+type ndjsonUserWriter struct {
+	encoder *json.Encoder
+}
+
+func (n *ndjsonUserWriter) WriteUser(user entity.User) error {
+	// Encode ends every user with a new line
+	return n.encoder.Encode(user)
+}
+
+func (n *ndjsonUserWriter) Flush() error {
+	return nil
+}
+
+// formatExportTime returns the time in RFC 3339, or empty if it isn't set
+func formatExportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// escapeCSVFormula prevents spreadsheets from running a value as a formula
+func escapeCSVFormula(value string) string {
+	if len(value) > 0 && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// @Summery		Export users
+// @Description	Streams every user which matches the filters as CSV or newline delimited JSON. It accepts the same filters and sort as /search, pagination is ignored.
+// @Description	The export is recorded in the audit log with the filters. Only admins are allowed.
+// @Tags			user
+// @Produce		text/csv
+// @Produce		application/x-ndjson
+// @Security		BearerAuth
+// @Param			format			query		string	false	"One of csv and ndjson. Default is csv."
+// @Param			phone			query		string	false	"Same as /search"
+// @Param			phone_prefix	query		string	false	"Same as /search"
+// @Param			register		query		string	false	"Same as /search"
+// @Param			last_login		query		string	false	"Same as /search"
+// @Param			tz				query		string	false	"Same as /search"
+// @Param			sort			query		string	false	"Same as /search"
+// @Param			status			query		string	false	"Same as /search"
+// @Param			q				query		string	false	"Same as /search"
+// @Success		200				{string}	string	"one user per row or line"
+// @Failure		400				{string}	string	"invalid query"
+// @Failure		401				{string}	string	"unauthorized access"
+// @Failure		403				{string}	string	"forbidden"
+// @Router			/users/export [get]
+func (a *Application) ExportUsersHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if len(format) == 0 {
+		format = FormatCSV
+	}
+	contentType, ok := formatContentTypes[format]
+	if !ok {
+		http.Error(w, "invalid value for format", http.StatusBadRequest)
+		return
+	}
+	opts, err := searchFilters(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// the filters are validated before auditing, so rejected exports aren't recorded
+	if err := db.ValidateSearchUserOptions(opts...); err != nil {
+		if errors.Is(err, db.ErrInvalidPhonePattern) {
+			http.Error(w, invalidPhonePatternMessage, http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	details := map[string]string{"format": format}
+	for _, param := range searchFilterParams {
+		if value := r.URL.Query().Get(param); len(value) > 0 {
+			details[param] = value
+		}
+	}
+	if err := a.recordAudit(r, entity.AuditUserExport, "", details); err != nil {
+		a.logger.Error(fmt.Sprintf("err when auditing user export: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+
+	controller := http.NewResponseController(w)
+	writer := newUserWriter(format, w)
+	flush := func() error {
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		return controller.Flush()
+	}
+	// the response is started with the first user, so errors before it get a status code
+	started := false
+	start := func() {
+		w.Header().Set("Content-Type", contentType)
+		filename := fmt.Sprintf("users-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		w.WriteHeader(http.StatusOK)
+		started = true
+	}
+	var count int64
+	var writeErr error
+	err = a.db.ExportUsers(r.Context(), func(user entity.User) error {
+		if !started {
+			start()
+		}
+		if writeErr = writer.WriteUser(user); writeErr != nil {
+			return writeErr
+		}
+		count++
+		if count%exportFlushInterval == 0 {
+			writeErr = flush()
+		}
+		return writeErr
+	}, opts...)
+	if err == nil {
+		if !started {
+			start()
+		}
+		writeErr = flush()
+	}
+	if writeErr != nil {
+		// the client is gone
+		a.logger.Warn(fmt.Sprintf("user export is stopped after %d users: %s", count, writeErr.Error()))
+		return
+	}
+	if err != nil {
+		if !started {
+			a.logger.Error(fmt.Sprintf("err when exporting users: %s", err.Error()))
+			http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+			return
+		}
+		// the status is already sent, so the response is aborted to tell the client that it's incomplete
+		a.logger.Error(fmt.Sprintf("err when exporting users after %d users: %s", count, err.Error()))
+		panic(http.ErrAbortHandler)
+	}
+}
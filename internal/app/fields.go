@@ -0,0 +1,117 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/aph138/dekamond/internal/db"
+	"github.com/aph138/dekamond/internal/entity"
+)
+
+var errFieldNotAllowed = errors.New("field is not allowed")
+
+// userFieldsByRole lists the fields of other users which every role can read
+var userFieldsByRole = map[string][]string{
+	entity.RoleAdmin: db.UserFields,
+	// support staff don't need contact details for helping users
+	entity.RoleSupport: {"id", "phone", "register_at", "last_login", "display_name", "locale", "roles", "status"},
+}
+
+// userView shapes the users which a principal reads, so every role gets only the fields which it's allowed to
+type userView struct {
+	fields []string
+	// maskPhone hides the middle digits of phone numbers
+	maskPhone bool
+}
+
+// newUserView returns the view of the principal with the comma separated fields.
+// Every field which the principal is allowed to read is in the view if fields is empty.
+// It returns errFieldNotAllowed if a field isn't allowed for the roles of the principal.
+func newUserView(principal *Principal, fields string) (*userView, error) {
+	allowed := []string{}
+	for _, role := range principal.Roles {
+		for _, field := range userFieldsByRole[role] {
+			if !slices.Contains(allowed, field) {
+				allowed = append(allowed, field)
+			}
+		}
+	}
+	view := &userView{fields: allowed, maskPhone: !principal.HasRole(entity.RoleAdmin)}
+	if len(fields) == 0 {
+		return view, nil
+	}
+	view.fields = []string{}
+	for field := range strings.SplitSeq(fields, ",") {
+		field = strings.TrimSpace(field)
+		if !slices.Contains(db.UserFields, field) {
+			return nil, fmt.Errorf("invalid value for fields: unknown field %q", field)
+		}
+		if !slices.Contains(allowed, field) {
+			return nil, fmt.Errorf("%w: %s", errFieldNotAllowed, field)
+		}
+		if !slices.Contains(view.fields, field) {
+			view.fields = append(view.fields, field)
+		}
+	}
+	return view, nil
+}
+
+// userViewOf returns the view of the principal of the request with the fields query parameter.
+// It responds with an error and returns false if the fields are invalid or not allowed.
+func userViewOf(w http.ResponseWriter, r *http.Request) (*userView, bool) {
+	principal, _ := PrincipalFromContext(r.Context())
+	view, err := newUserView(principal, r.URL.Query().Get("fields"))
+	if err != nil {
+		if errors.Is(err, errFieldNotAllowed) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return nil, false
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return view, true
+}
+
+// shape returns a copy of the user with only the fields of the view
+func (v *userView) shape(user entity.User) entity.User {
+	shaped := entity.User{}
+	for _, field := range v.fields {
+		switch field {
+		case "id":
+			shaped.ID = user.ID
+		case "phone":
+			shaped.Phone = user.Phone
+			if v.maskPhone {
+				shaped.Phone = maskPhone(user.Phone)
+			}
+		case "register_at":
+			shaped.RegisteredAt = user.RegisteredAt
+		case "last_login":
+			shaped.LastLogin = user.LastLogin
+		case "display_name":
+			shaped.DisplayName = user.DisplayName
+		case "email":
+			shaped.Email = user.Email
+		case "locale":
+			shaped.Locale = user.Locale
+		case "avatar_url":
+			shaped.AvatarURL = user.AvatarURL
+		case "roles":
+			shaped.Roles = user.GetRoles()
+		case "status":
+			shaped.Status = user.GetStatus()
+		}
+	}
+	return shaped
+}
+
+// maskPhone hides every digit of the phone number except the first and last four, e.g. 0912***5678
+func maskPhone(phone string) string {
+	if len(phone) <= 8 {
+		return strings.Repeat("*", len(phone))
+	}
+	return phone[:4] + strings.Repeat("*", len(phone)-8) + phone[len(phone)-4:]
+}
@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -13,6 +15,7 @@ import (
 	"github.com/aph138/dekamond/internal/cache"
 	"github.com/aph138/dekamond/internal/db"
 	"github.com/aph138/dekamond/internal/entity"
+	"github.com/aph138/dekamond/pkg/authentication"
 )
 
 //	@Title			dekamond example swagger API
@@ -21,16 +24,45 @@ import (
 //
 // @Host		localhost:9000
 // @BasePath	/
+//
+// @securityDefinitions.apikey	BearerAuth
+// @in							header
+// @name						Authorization
+// @description				Type "Bearer" followed by a space and the JWT token.
 type SearchResponse struct {
 	Code   int           `json:"code"`
 	Result []entity.User `json:"result"`
+	// Total is the number of matching users, it's omitted when counting is skipped
+	Total *int64 `json:"total,omitempty" example:"1200"`
+	// Page is omitted when a cursor is used
+	Page    int64 `json:"page,omitempty" example:"3"`
+	Limit   int64 `json:"limit" example:"10"`
+	HasMore bool  `json:"has_more"`
+	// NextCursor is sent as cursor for getting the next page, it's empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
 }
+
+// phoneRegex matches a valid mobile phone number
+var phoneRegex = regexp.MustCompile(`^09\d{9}$`)
+
+// ValidPhone reports whether the phone number is a valid mobile phone number
+func ValidPhone(phone string) bool {
+	return phoneRegex.MatchString(phone)
+}
+
 type LoginRequest struct {
 	Phone string `json:"phone" example:"09012345678"`
 }
 type CheckRequest struct {
 	Phone string `json:"phone" example:"09012345678"`
 	Code  string `json:"code" example:"123456"`
+	// Audience is optional, it must be one of the configured audiences
+	Audience string `json:"audience,omitempty" example:"mobile-app"`
+	// Device is an optional name which is shown in the list of sessions
+	Device string `json:"device,omitempty" example:"Pixel 8"`
+	// AuthRequest is the ID which /authorize passes to the login page.
+	// If it's set, an authorization code is issued instead of a token.
+	AuthRequest string `json:"auth_request,omitempty"`
 }
 
 // @Summery		Login endpoint
@@ -39,8 +71,10 @@ type CheckRequest struct {
 // @Accept			json
 // @Param			request	body	LoginRequest	true	"valid phone number as string"
 // @Success		201		"No Content"
+// @Failure		409		{string}	string	"the phone number is an old number of another user which is still on hold"
 // @Router			/login [post]
 func (a *Application) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 	var req LoginRequest
 	reqDecoder := json.NewDecoder(r.Body)
 	reqDecoder.DisallowUnknownFields() // for strict validation
@@ -51,37 +85,56 @@ func (a *Application) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// validate phone number
-	rgx := regexp.MustCompile(`09\d{9}$`)
-	if !rgx.MatchString(req.Phone) {
+	if !phoneRegex.MatchString(req.Phone) {
 		http.Error(w, "invalid phone number", http.StatusBadRequest)
 		return
 	}
 
+	// an old number of another user can't be registered during its hold period
+	holder, err := a.phoneHolder(req.Phone)
+	if err != nil {
+		a.logger.Error(fmt.Sprintf("err when checking phone hold: %s", err.Error()))
+		http.Error(w, "Something went wrong. Please contact support team.", http.StatusInternalServerError)
+		return
+	}
+	if holder != "" {
+		a.recordLoginEvent(r, start, "", req.Phone, entity.EventOTPRequest, entity.ChannelAPI, entity.OutcomeBlocked)
+		http.Error(w, "This phone number can't be registered yet.", http.StatusConflict)
+		return
+	}
+
 	code, err := a.cache.NewOTPCode(req.Phone)
 	if err != nil {
 		if errors.Is(err, cache.ErrOTPStillValid) {
+			a.recordLoginEvent(r, start, "", req.Phone, entity.EventOTPRequest, entity.ChannelAPI, entity.OutcomeRateLimited)
 			http.Error(w, "You still have a valid code. Please try again later.", http.StatusTooManyRequests)
 			return
 		} else {
+			a.recordLoginEvent(r, start, "", req.Phone, entity.EventOTPRequest, entity.ChannelAPI, entity.OutcomeError)
 			a.logger.Error(fmt.Sprintf("err when generating OTP code: %s", err.Error()))
 			http.Error(w, "Something went wrong. Please contact support team.", http.StatusInternalServerError)
 			return
 		}
 	}
 	a.logger.Info(fmt.Sprintf("%s: %s", req.Phone, code))
+	a.recordLoginEvent(r, start, "", req.Phone, entity.EventOTPRequest, entity.ChannelAPI, entity.OutcomeSuccess)
 	w.Header().Add("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusCreated)
 }
 
 // @Summery		check endpoint
-// @Description	Accepts a phone number and an OTP code and return JWT token if they are valid
+// @Description	Accepts a phone number and an OTP code and return JWT token if they are valid.
+// @Description	If auth_request is set, the JSON of CheckAuthorizeResponse is returned instead, which holds the client redirect URI with an authorization code.
 // @Tags			login
 // @Accept			json
 // @Produce		plain
 // @Param			request	body		CheckRequest	true	"valid phone number and code"
-// @Success		200		{string}	string			"JWT containing user ID"
+// @Success		200		{string}	string			"JWT containing user ID, encrypted as JWE if encryption is enabled"
+// @Failure		400		{string}	string			"invalid phone number or unknown audience"
+// @Failure		403		{object}	OAuthError		"the account is not active, error is one of account_suspended, account_banned and account_deleted"
 // @Router			/check [post]
 func (a *Application) CheckHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 	var req CheckRequest
 	reqDecoder := json.NewDecoder(r.Body)
 	reqDecoder.DisallowUnknownFields() // for strict validation
@@ -92,100 +145,176 @@ func (a *Application) CheckHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// validate phone number
-	rgx := regexp.MustCompile(`09\d{9}$`)
-	if !rgx.MatchString(req.Phone) {
+	if !phoneRegex.MatchString(req.Phone) {
 		http.Error(w, "invalid phone number", http.StatusBadRequest)
 		return
 	}
+	if len(req.Audience) > 0 && !slices.Contains(a.tokenAudiences, req.Audience) {
+		http.Error(w, "unknown audience", http.StatusBadRequest)
+		return
+	}
+
+	// the user is signing in to an OpenID Connect client if auth_request is set
+	channel := entity.ChannelAPI
+	if len(req.AuthRequest) > 0 {
+		channel = entity.ChannelOIDC
+	}
 
 	err := a.cache.VerifyOTPCode(req.Phone, req.Code)
 	if err != nil {
 		if errors.Is(err, cache.ErrRateLimit) {
+			a.recordLoginEvent(r, start, "", req.Phone, entity.EventOTPVerify, channel, entity.OutcomeRateLimited)
 			http.Error(w, "Rate limit exceeded. Please try again later.", http.StatusTooManyRequests)
 			return
 		}
+		a.recordLoginEvent(r, start, "", req.Phone, entity.EventOTPVerify, channel, entity.OutcomeFailure)
 		http.Error(w, "invalid code", http.StatusUnauthorized)
 		return
 	}
-	// will be saved in JWT payload
-	var userID string
-
+	// users which aren't active are rejected before saving, so their last login isn't updated
+	existing, err := a.db.SearchUser(db.SearchUserByPhone(req.Phone), db.SearchUserByPagination(1, 1))
+	if err != nil {
+		a.recordLoginEvent(r, start, "", req.Phone, entity.EventOTPVerify, channel, entity.OutcomeError)
+		a.logger.Error(fmt.Sprintf("err when finding user at /check: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	if len(existing.Users) > 0 && existing.Users[0].GetStatus() != entity.StatusActive {
+		a.recordLoginEvent(r, start, existing.Users[0].ID.Hex(), req.Phone, entity.EventOTPVerify, channel, entity.OutcomeBlocked)
+		a.writeAccountStatusError(w, existing.Users[0].GetStatus())
+		return
+	}
 	// saving user in db if no records exist
-	userID, err = a.db.SaveUser(req.Phone)
+	user, err := a.db.SaveUser(req.Phone)
 	if err != nil {
+		a.recordLoginEvent(r, start, "", req.Phone, entity.EventOTPVerify, channel, entity.OutcomeError)
 		a.logger.Error(fmt.Sprintf("err when saving user at /check: %s", err.Error()))
 		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
 		return
 	}
+	// will be saved in JWT payload
+	userID := user.ID.Hex()
+	if user.GetStatus() != entity.StatusActive {
+		a.recordLoginEvent(r, start, userID, req.Phone, entity.EventOTPVerify, channel, entity.OutcomeBlocked)
+		a.writeAccountStatusError(w, user.GetStatus())
+		return
+	}
+	a.recordLoginEvent(r, start, userID, req.Phone, entity.EventOTPVerify, channel, entity.OutcomeSuccess)
+
+	// the token is issued by /token after the client exchanges the authorization code
+	if channel == entity.ChannelOIDC {
+		a.completeAuthorization(w, req.AuthRequest, userID, req.Phone)
+		return
+	}
+
+	// every token is linked to a session so the user can sign it out
+	sessionID, err := a.newSession(r, userID, req.Device, time.Hour*24)
+	if err != nil {
+		a.logger.Error(fmt.Sprintf("err when creating session at /check: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
 
 	// generate JWT token
-	// consider encrypting userID in real world scenario
-	token, err := a.jwt.NewToken(map[string]string{"id": userID}, time.Hour*24)
+	// the token is issued as JWE if encryption is enabled
+	tokenOpts := []authentication.TokenOption{}
+	if len(req.Audience) > 0 {
+		tokenOpts = append(tokenOpts, authentication.WithAudience(req.Audience))
+	}
+	token, err := a.jwt.NewToken(map[string]string{
+		"id":    userID,
+		"roles": strings.Join(user.GetRoles(), ","),
+		"scope": FirstPartyScope,
+		"sid":   sessionID,
+	}, time.Hour*24, tokenOpts...)
 	if err != nil {
+		a.recordLoginEvent(r, start, userID, req.Phone, entity.EventTokenIssued, channel, entity.OutcomeError)
 		a.logger.Error(fmt.Sprintf("err when generating new JWT token: %s", err.Error()))
 		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
 		return
 	}
+	a.recordLoginEvent(r, start, userID, req.Phone, entity.EventTokenIssued, channel, entity.OutcomeSuccess)
 	w.Header().Add("Content-Type", "text/plain")
 	fmt.Fprint(w, token)
 
 }
 
+// @Summery		Logout endpoint
+// @Description	Revokes the token which is sent in Authorization header and removes its session
+// @Tags			login
+// @Security		BearerAuth
+// @Success		204	"No Content"
+// @Failure		401	{string}	string	"unauthorized access"
+// @Router			/logout [post]
+func (a *Application) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFromContext(r.Context())
+	if err := a.cache.RevokeToken(principal.TokenID, time.Until(principal.ExpiresAt)); err != nil {
+		a.logger.Error(fmt.Sprintf("err when revoking token at /logout: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	if err := a.db.DeleteSession(principal.SessionID, principal.UserID); err != nil && !errors.Is(err, db.ErrNotFound) {
+		a.logger.Error(fmt.Sprintf("err when deleting session at /logout: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // @Summery		Search for user
-// @Description	Retrieve users
+// @Description	Retrieve users. Only support and admins are allowed. Link headers (RFC 8288) point to the next and previous pages.
 // @Produce		json
 // @Tags			user
-// @Param			phone		query		string	false	"A valid phone number for searching a specific user."																example(09012345678)
-// @Param			register	query		string	false	"A date range to search for users who registered within that period in YYYY-MM-DD format, separated by a comma."	example(2024-01-01,2025-10-12)
-// @Param			page		query		int		false	"The page number of the results. Default is 1. Negative numbers and zero are treated as 1."
-// @Param			limit		query		int		false	"The number of items per page. Default is 10. Negative numbers and zero are treated as 1."
-// @Success		200			{object}	SearchResponse
+// @Security		BearerAuth
+// @Param			phone			query		string	false	"A valid phone number for searching a specific user."																																												example(09012345678)
+// @Param			phone_prefix	query		string	false	"The leading digits of phone numbers, at least 7 digits. Unknown digits can be masked by *, then the whole number must match. Only admins are allowed, since other roles get masked phone numbers."																										example(0912345**67)
+// @Param			register		query		string	false	"A range to search for users who registered within that period, two dates in YYYY-MM-DD format or RFC 3339 datetimes separated by a comma. Either side can be empty for an open range. A date as the end includes the whole day."	example(2024-01-01,2025-10-12)
+// @Param			last_login		query		string	false	"A range of the last login time in the same format as register. Users who have never logged in don't match."																														example(2025-01-01T00:00:00+03:30,)
+// @Param			tz				query		string	false	"The IANA time zone of dates without time in register and last_login. Default is UTC."																																				example(Asia/Tehran)
+// @Param			sort			query		string	false	"One of register_at and last_login, with a leading - for descending order. Default is -register_at."																																example(-last_login)
+// @Param			status			query		string	false	"Account status, one of active, suspended, banned and deleted."
+// @Param			q				query		string	false	"A filter expression which is joined with the other filters. Conditions are a field, an operator and a value, e.g. role:admin. The fields are phone, register_at, last_login, status, role, display_name, email and locale. Every field is compared by : and times by >, >=, < and <= as well. Times are dates in the tz or RFC 3339 datetimes and phone numbers can be masked by *. Values with spaces are put in double quotes. Conditions are joined by AND and OR, negated by NOT and grouped by parentheses. Errors point to the position in the expression. Only admins are allowed."	example(status:active AND register_at>=2025-01-01 AND role:admin)
+// @Param			page			query		int		false	"The page number of the results. Default is 1. Negative numbers and zero are treated as 1."
+// @Param			cursor			query		string	false	"The next_cursor of the previous response. Unlike page, it doesn't skip or repeat users who register in the meantime. It can't be used with page."
+// @Param			limit			query		int		false	"The number of items per page. Default is 10 and maximum is 100. Negative numbers and zero are treated as 1."
+// @Param			count			query		bool	false	"Whether total is counted. Default is true. Counting is slow on very large result sets."
+// @Param			fields			query		string	false	"Comma separated fields of users to return. Default is every field which the caller is allowed to read. Support staff can't read email and avatar_url and get phone numbers with masked middle digits."	example(id,phone)
+// @Success		200				{object}	SearchResponse
+// @Failure		400				{string}	string	"invalid query"
+// @Failure		401				{string}	string	"unauthorized access"
+// @Failure		403				{string}	string	"forbidden or a field which isn't allowed"
 // @Router			/search [get]
 func (a *Application) SearchUserHandler(w http.ResponseWriter, r *http.Request) {
 
-	phoneQuery := r.URL.Query().Get("phone")
-	registerQuery := r.URL.Query().Get("register")
 	pageQuery := r.URL.Query().Get("page")
 	limitQuery := r.URL.Query().Get("limit")
+	cursorQuery := r.URL.Query().Get("cursor")
+	countQuery := r.URL.Query().Get("count")
 
-	// initialize user search option list
-	opts := []db.SearchUserOption{}
-
-	if len(phoneQuery) > 0 {
-		//validate phone number
-		rgx := regexp.MustCompile(`09\d{9}$`)
-		if !rgx.MatchString(phoneQuery) {
-			http.Error(w, "invalid phone number", http.StatusBadRequest)
-
-			return
-		}
-		opts = append(opts, db.SearchUserByPhone(phoneQuery))
+	principal, _ := PrincipalFromContext(r.Context())
+	// support staff can't read every field, so they can't filter by every field either
+	if len(r.URL.Query().Get("q")) > 0 && !principal.HasRole(entity.RoleAdmin) {
+		http.Error(w, "q is only allowed for admins", http.StatusForbidden)
+		return
 	}
-
-	// a similar approach can be implemented for searching by latest login date and time
-	// check if register has any value
-	if len(registerQuery) > 0 {
-		register := strings.Split(registerQuery, ",")
-		if len(register) != 2 {
-			http.Error(w, "register format must be YYYY-MM-DD,YYYY-MM,DD", http.StatusBadRequest)
-			return
-		}
-		registerFrom, err := time.Parse("2006-01-02", register[0])
-		if err != nil {
-			http.Error(w, "invalid date format for register", http.StatusBadRequest)
-			return
-		}
-		registerTo, err := time.Parse("2006-01-02", register[1])
-		if err != nil {
-			http.Error(w, "invalid date format for register", http.StatusBadRequest)
-			return
-		}
-		opts = append(opts, db.SearchUserByRegisterTime(&registerFrom, &registerTo))
+	opts, err := searchFilters(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	view, ok := userViewOf(w, r)
+	if !ok {
+		return
+	}
+	// partial numbers would reveal the masked digits one by one
+	if view.maskPhone && len(r.URL.Query().Get("phone_prefix")) > 0 {
+		http.Error(w, "phone_prefix is only allowed for admins", http.StatusForbidden)
+		return
 	}
+	opts = append(opts, db.SearchUserFields(view.fields...))
 
 	var limit int64 = 10
 	var page int64 = 1
-	var err error
 	if len(limitQuery) > 0 {
 		limit, err = strconv.ParseInt(limitQuery, 10, 64)
 		if err != nil {
@@ -200,19 +329,69 @@ func (a *Application) SearchUserHandler(w http.ResponseWriter, r *http.Request)
 			return
 		}
 	}
+	// same as the database, so the response has the values which are used
+	page, limit = max(page, 1), min(max(limit, 1), db.MaxSearchLimit)
 	opts = append(opts, db.SearchUserByPagination(page, limit))
+	count := true
+	if len(countQuery) > 0 {
+		count, err = strconv.ParseBool(countQuery)
+		if err != nil {
+			http.Error(w, "invalid value for count", http.StatusBadRequest)
+			return
+		}
+	}
+	if count {
+		opts = append(opts, db.SearchUserWithTotal())
+	}
+	if len(cursorQuery) > 0 {
+		if len(pageQuery) > 0 {
+			http.Error(w, "page and cursor can't be used together", http.StatusBadRequest)
+			return
+		}
+		opts = append(opts, db.SearchUserAfter(cursorQuery))
+	}
 
 	list, err := a.db.SearchUser(opts...)
 	if err != nil {
+		if errors.Is(err, db.ErrInvalidCursor) {
+			http.Error(w, "invalid value for cursor", http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, db.ErrInvalidPhonePattern) {
+			http.Error(w, invalidPhonePatternMessage, http.StatusBadRequest)
+			return
+		}
 		a.logger.Error("err when searching user " + err.Error())
 		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
 		return
 	}
-	w.Header().Add("Content-Type", "application/json")
+	users := make([]entity.User, 0, len(list.Users))
+	for _, user := range list.Users {
+		users = append(users, view.shape(user))
+	}
 	res := SearchResponse{
-		Code:   http.StatusOK,
-		Result: list,
+		Code:       http.StatusOK,
+		Result:     users,
+		Total:      list.Total,
+		Limit:      limit,
+		HasMore:    len(list.NextCursor) > 0,
+		NextCursor: list.NextCursor,
+	}
+	if len(cursorQuery) > 0 {
+		// cursors only go forward
+		if res.HasMore {
+			addLink(w, r, "next", "cursor", list.NextCursor)
+		}
+	} else {
+		res.Page = page
+		if res.HasMore {
+			addLink(w, r, "next", "page", strconv.FormatInt(page+1, 10))
+		}
+		if page > 1 {
+			addLink(w, r, "prev", "page", strconv.FormatInt(page-1, 10))
+		}
 	}
+	w.Header().Add("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(res); err != nil {
 		a.logger.Error("err when encoding search user result " + err.Error())
 		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
@@ -220,20 +399,142 @@ func (a *Application) SearchUserHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
-func (a *Application) AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
-		if token == "" || !strings.HasPrefix(token, "Bearer ") {
-			http.Error(w, "unauthorized access", http.StatusUnauthorized)
-			return
+// invalidPhonePatternMessage is the response when the database rejects phone_prefix
+var invalidPhonePatternMessage = fmt.Sprintf("phone_prefix must start with at least %d digits and have only digits and *", db.MinPhonePrefix)
+
+// searchFilterParams lists the query parameters which searchFilters reads
+var searchFilterParams = []string{"phone", "phone_prefix", "register", "last_login", "tz", "status", "q", "sort"}
+
+// searchFilters returns the search options of the filter and sort query parameters, which are
+// shared by every endpoint that lists users. The message of the error is the response of the invalid parameter.
+func searchFilters(query url.Values) ([]db.SearchUserOption, error) {
+	phoneQuery := query.Get("phone")
+	phonePrefixQuery := query.Get("phone_prefix")
+	registerQuery := query.Get("register")
+	lastLoginQuery := query.Get("last_login")
+	tzQuery := query.Get("tz")
+	sortQuery := query.Get("sort")
+	statusQuery := query.Get("status")
+	filterQuery := query.Get("q")
+
+	// initialize user search option list
+	opts := []db.SearchUserOption{}
+
+	if len(phoneQuery) > 0 {
+		//validate phone number
+		if !phoneRegex.MatchString(phoneQuery) {
+			return nil, errors.New("invalid phone number")
+		}
+		opts = append(opts, db.SearchUserByPhone(phoneQuery))
+	}
+	if len(phonePrefixQuery) > 0 {
+		if len(phoneQuery) > 0 {
+			return nil, errors.New("phone and phone_prefix can't be used together")
 		}
-		token = strings.TrimPrefix(token, "Bearer ")
+		// it's validated by the database
+		if strings.Contains(phonePrefixQuery, "*") {
+			opts = append(opts, db.SearchUserByMaskedPhone(phonePrefixQuery))
+		} else {
+			opts = append(opts, db.SearchUserByPhonePrefix(phonePrefixQuery))
+		}
+	}
 
-		result, err := a.jwt.Parse(token)
+	// dates without time are in this location
+	location := time.UTC
+	if len(tzQuery) > 0 {
+		var err error
+		location, err = time.LoadLocation(tzQuery)
 		if err != nil {
-			http.Error(w, "unauthorized access", http.StatusUnauthorized)
-			return
+			return nil, errors.New("invalid value for tz")
 		}
-		_ = result["id"]
-	})
+	}
+	if len(registerQuery) > 0 {
+		registerFrom, registerTo, err := parseTimeRange(registerQuery, location)
+		if err != nil {
+			return nil, errors.New("invalid value for register: " + err.Error())
+		}
+		opts = append(opts, db.SearchUserByRegisterTime(registerFrom, registerTo))
+	}
+	if len(lastLoginQuery) > 0 {
+		lastLoginFrom, lastLoginTo, err := parseTimeRange(lastLoginQuery, location)
+		if err != nil {
+			return nil, errors.New("invalid value for last_login: " + err.Error())
+		}
+		opts = append(opts, db.SearchUserByLastLogin(lastLoginFrom, lastLoginTo))
+	}
+
+	if len(statusQuery) > 0 {
+		if !slices.Contains(entity.Statuses, statusQuery) {
+			return nil, errors.New("invalid value for status")
+		}
+		opts = append(opts, db.SearchUserByStatus(statusQuery))
+	}
+	if len(filterQuery) > 0 {
+		expr, err := db.ParseUserFilter(filterQuery, location)
+		if err != nil {
+			return nil, errors.New("invalid value for q: " + err.Error())
+		}
+		opts = append(opts, db.SearchUserByFilter(expr))
+	}
+
+	if len(sortQuery) > 0 {
+		// a leading - means descending order
+		field, desc := strings.CutPrefix(sortQuery, "-")
+		if !slices.Contains(db.SortFields, field) {
+			return nil, errors.New("invalid value for sort")
+		}
+		opts = append(opts, db.SearchUserSortBy(field, desc))
+	}
+	return opts, nil
+}
+
+// addLink adds an RFC 8288 Link header which points to the request URL with the query parameter changed
+func addLink(w http.ResponseWriter, r *http.Request, rel, key, value string) {
+	query := r.URL.Query()
+	query.Set(key, value)
+	target := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="%s"`, target.String(), rel))
+}
+
+// parseTimeRange parses two dates in YYYY-MM-DD format or RFC 3339 datetimes separated by a comma.
+// Either bound can be empty for an open range. Dates are in the location and
+// a date as the upper bound includes the whole day.
+func parseTimeRange(value string, location *time.Location) (*time.Time, *time.Time, error) {
+	fromValue, toValue, ok := strings.Cut(value, ",")
+	if !ok {
+		return nil, nil, errors.New("two values separated by a comma are required")
+	}
+	if len(fromValue) == 0 && len(toValue) == 0 {
+		return nil, nil, errors.New("at least one bound is required")
+	}
+	from, err := parseTimeBound(fromValue, location, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	to, err := parseTimeBound(toValue, location, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if from != nil && to != nil && to.Before(*from) {
+		return nil, nil, errors.New("start is after end")
+	}
+	return from, to, nil
+}
+
+func parseTimeBound(value string, location *time.Location, end bool) (*time.Time, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	if date, err := time.ParseInLocation("2006-01-02", value, location); err == nil {
+		if end {
+			date = date.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		return &date, nil
+	}
+	// + in offsets is decoded as a space if it isn't escaped in the URL
+	datetime, err := time.Parse(time.RFC3339, strings.ReplaceAll(value, " ", "+"))
+	if err != nil {
+		return nil, fmt.Errorf("%s is neither a YYYY-MM-DD date nor an RFC 3339 datetime", value)
+	}
+	return &datetime, nil
 }
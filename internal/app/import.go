@@ -0,0 +1,376 @@
+package app
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aph138/dekamond/internal/db"
+	"github.com/aph138/dekamond/internal/entity"
+)
+
+const (
+	// importBatchSize is the number of rows which are written to the database at once
+	importBatchSize = 1000
+	// importMaxBytes is the largest body which /users/import accepts, larger files are imported by the CLI
+	importMaxBytes = 32 << 20
+	// importMaxLine is the longest line of NDJSON imports
+	importMaxLine = 64 << 10
+)
+
+// errInvalidImport is the error of files which can't be read, such as a CSV without header
+var errInvalidImport = errors.New("invalid import file")
+
+// outcomes of imported rows
+const (
+	ImportCreated  = "created"
+	ImportExisting = "existing"
+	ImportInvalid  = "invalid"
+)
+
+// ImportRow is the outcome of one row of an import
+type ImportRow struct {
+	// Line is the line number of the row in the file
+	Line int `json:"line"`
+	// Phone is the normalized phone number, it's empty if the row has no valid number
+	Phone   string `json:"phone,omitempty"`
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ImportReport holds the outcome of every row of an import
+type ImportReport struct {
+	Created  int         `json:"created"`
+	Existing int         `json:"existing"`
+	Invalid  int         `json:"invalid"`
+	Rows     []ImportRow `json:"rows"`
+}
+
+type ImportResponse struct {
+	Code   int          `json:"code"`
+	Result ImportReport `json:"result"`
+}
+
+// importRecord is a row of an import before it's validated
+type importRecord struct {
+	line       int
+	phone      string
+	registerAt string
+	// err is set if the row can't be read
+	err error
+}
+
+// userImporter validates rows and writes them in batches
+type userImporter struct {
+	app    *Application
+	report *ImportReport
+	// batch holds the rows which are waiting to be written, as indexes of report rows
+	batch []int
+	// registered holds the registration time of every row in batch
+	registered []time.Time
+	// seen maps every valid phone number of the import to its line
+	seen map[string]int
+}
+
+// ImportUsers creates the users of the CSV or NDJSON rows which r reads and returns the outcome of every row.
+// CSV needs a header with a phone column and an optional register_at column, NDJSON needs objects
+// with phone and optional register_at fields, so exports can be imported. Dates are in YYYY-MM-DD format
+// or RFC 3339 datetimes and the time of the import is used without a date.
+// Invalid rows are reported and skipped. If r can't be read, the rows before the error are imported
+// and they are returned with the error. If the database fails, the rows which aren't written are left out
+// of the report which is returned with the error.
+func (a *Application) ImportUsers(format string, r io.Reader) (*ImportReport, error) {
+	importer := &userImporter{app: a, report: &ImportReport{Rows: []ImportRow{}}, seen: map[string]int{}}
+	var err error
+	switch format {
+	case FormatCSV:
+		err = readCSVImport(r, importer.add)
+	case FormatNDJSON:
+		err = readNDJSONImport(r, importer.add)
+	default:
+		return nil, fmt.Errorf("unsupported import format %q", format)
+	}
+	if err != nil {
+		// rows can't be read after the error, but the queued ones before it are valid
+		if flushErr := importer.flush(); flushErr != nil {
+			return importer.report, flushErr
+		}
+		return importer.report, err
+	}
+	if err := importer.flush(); err != nil {
+		return importer.report, err
+	}
+	return importer.report, nil
+}
+
+// readCSVImport calls add with every row of the CSV
+func readCSVImport(r io.Reader, add func(importRecord) error) error {
+	reader := csv.NewReader(r)
+	// rows with missing optional columns are allowed
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("%w: the csv file is empty", errInvalidImport)
+		}
+		return fmt.Errorf("%w: %w", errInvalidImport, err)
+	}
+	phoneColumn, registerColumn := -1, -1
+	for i, column := range header {
+		switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))) {
+		case "phone":
+			phoneColumn = i
+		case "register_at":
+			registerColumn = i
+		}
+	}
+	if phoneColumn == -1 {
+		return fmt.Errorf("%w: the csv header has no phone column", errInvalidImport)
+	}
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var record importRecord
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			// the reader goes on with the next line
+			record = importRecord{line: parseErr.StartLine, err: parseErr.Err}
+		} else if err != nil {
+			return fmt.Errorf("%w: %w", errInvalidImport, err)
+		} else {
+			// the position is only known after a row is read
+			line, _ := reader.FieldPos(0)
+			record = importRecord{line: line}
+			if phoneColumn < len(row) {
+				record.phone = row[phoneColumn]
+			}
+			if registerColumn != -1 && registerColumn < len(row) {
+				record.registerAt = row[registerColumn]
+			}
+		}
+		if err := add(record); err != nil {
+			return err
+		}
+	}
+}
+
+// readNDJSONImport calls add with every line of the NDJSON, empty lines are skipped
+func readNDJSONImport(r io.Reader, add func(importRecord) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), importMaxLine)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var row struct {
+			Phone      string `json:"phone"`
+			RegisterAt string `json:"register_at"`
+		}
+		record := importRecord{line: line}
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			record.err = errors.New("invalid json")
+		}
+		record.phone, record.registerAt = row.Phone, row.RegisterAt
+		if err := add(record); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%w: after line %d: %w", errInvalidImport, line, err)
+	}
+	return nil
+}
+
+// add validates the record and queues it, the queue is written when it's full
+func (i *userImporter) add(record importRecord) error {
+	row := ImportRow{Line: record.line, Outcome: ImportInvalid}
+	registeredAt, err := i.validate(record, &row)
+	if err == nil {
+		// numbers which someone changed away from can't be registered by anyone else during the hold period
+		holder, holdErr := i.app.phoneHolder(row.Phone)
+		if holdErr != nil {
+			return fmt.Errorf("err when checking phone hold: %w", holdErr)
+		}
+		if len(holder) > 0 {
+			err = errors.New("the phone number is on hold")
+		}
+	}
+	if err != nil {
+		row.Error = err.Error()
+		i.report.Invalid++
+		i.report.Rows = append(i.report.Rows, row)
+		return nil
+	}
+	i.seen[row.Phone] = row.Line
+	i.batch = append(i.batch, len(i.report.Rows))
+	i.registered = append(i.registered, registeredAt)
+	i.report.Rows = append(i.report.Rows, row)
+	if len(i.batch) == importBatchSize {
+		return i.flush()
+	}
+	return nil
+}
+
+// validate normalizes the record and sets the phone number of the row.
+// It returns the registration time of the row, which is zero if the row has no date.
+func (i *userImporter) validate(record importRecord, row *ImportRow) (time.Time, error) {
+	if record.err != nil {
+		return time.Time{}, record.err
+	}
+	phone := normalizePhone(record.phone)
+	if !phoneRegex.MatchString(phone) {
+		return time.Time{}, errors.New("invalid phone number")
+	}
+	row.Phone = phone
+	if line, ok := i.seen[phone]; ok {
+		return time.Time{}, fmt.Errorf("duplicate of line %d", line)
+	}
+	var registeredAt time.Time
+	if value := strings.TrimSpace(record.registerAt); len(value) > 0 {
+		t, err := parseTimeBound(value, time.UTC, false)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid register_at: %w", err)
+		}
+		if t.After(time.Now()) {
+			return time.Time{}, errors.New("invalid register_at: it's in the future")
+		}
+		registeredAt = *t
+	}
+	return registeredAt, nil
+}
+
+// flush writes the queued rows
+func (i *userImporter) flush() error {
+	if len(i.batch) == 0 {
+		return nil
+	}
+	users := make([]db.UserImport, 0, len(i.batch))
+	for n, index := range i.batch {
+		users = append(users, db.UserImport{Phone: i.report.Rows[index].Phone, RegisteredAt: i.registered[n]})
+	}
+	created, err := i.app.db.ImportUsers(users)
+	if err != nil {
+		err = fmt.Errorf("err when importing the rows before line %d: %w", i.report.Rows[i.batch[0]].Line, err)
+		// the queued rows aren't processed, so they aren't reported as invalid
+		queued := map[int]bool{}
+		for _, index := range i.batch {
+			queued[index] = true
+		}
+		rows := make([]ImportRow, 0, len(i.report.Rows)-len(i.batch))
+		for index, row := range i.report.Rows {
+			if !queued[index] {
+				rows = append(rows, row)
+			}
+		}
+		i.report.Rows = rows
+		i.batch, i.registered = nil, nil
+		return err
+	}
+	for n, index := range i.batch {
+		if created[n] {
+			i.report.Rows[index].Outcome = ImportCreated
+			i.report.Created++
+		} else {
+			i.report.Rows[index].Outcome = ImportExisting
+			i.report.Existing++
+		}
+	}
+	i.batch, i.registered = i.batch[:0], i.registered[:0]
+	return nil
+}
+
+// persianDigits maps Persian and Arabic digits to ASCII digits
+var persianDigits = strings.NewReplacer(
+	"۰", "0", "۱", "1", "۲", "2", "۳", "3", "۴", "4", "۵", "5", "۶", "6", "۷", "7", "۸", "8", "۹", "9",
+	"٠", "0", "١", "1", "٢", "2", "٣", "3", "٤", "4", "٥", "5", "٦", "6", "٧", "7", "٨", "8", "٩", "9",
+)
+
+// normalizePhone converts the formats of phone numbers which other systems keep, such as +98 912 123 4567,
+// to the format of phoneRegex. The result isn't valid if the number isn't an Iranian mobile number.
+func normalizePhone(phone string) string {
+	phone = persianDigits.Replace(strings.TrimSpace(phone))
+	phone = strings.Map(func(r rune) rune {
+		if slices.Contains([]rune(" -()."), r) {
+			return -1
+		}
+		return r
+	}, phone)
+	for _, prefix := range []string{"+98", "0098", "98"} {
+		if rest, ok := strings.CutPrefix(phone, prefix); ok && len(rest) == 10 {
+			return "0" + rest
+		}
+	}
+	if len(phone) == 10 && strings.HasPrefix(phone, "9") {
+		return "0" + phone
+	}
+	return phone
+}
+
+// @Summery		Import users
+// @Description	Creates the users of a CSV or NDJSON file and leaves the users which already exist unchanged. Phone numbers such as +98 912 123 4567 are normalized.
+// @Description	CSV needs a header with a phone column and an optional register_at column, NDJSON needs objects with phone and optional register_at fields, so exports can be imported. Dates are in YYYY-MM-DD format or RFC 3339 datetimes.
+// @Description	The response reports every row as created, existing or invalid. Files larger than 32 MB are imported by running the binary with the import argument. Only admins are allowed.
+// @Tags			user
+// @Accept			text/csv
+// @Accept			application/x-ndjson
+// @Produce		json
+// @Security		BearerAuth
+// @Param			format	query		string	false	"One of csv and ndjson. Default is csv."
+// @Param			request	body		string	true	"the rows"
+// @Success		200		{object}	ImportResponse
+// @Failure		400		{string}	string	"invalid file"
+// @Failure		401		{string}	string	"unauthorized access"
+// @Failure		403		{string}	string	"forbidden"
+// @Failure		413		{string}	string	"the file is too large"
+// @Router			/users/import [post]
+func (a *Application) ImportUsersHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if len(format) == 0 {
+		format = FormatCSV
+	}
+	if _, ok := formatContentTypes[format]; !ok {
+		http.Error(w, "invalid value for format", http.StatusBadRequest)
+		return
+	}
+	report, err := a.ImportUsers(format, http.MaxBytesReader(w, r.Body, importMaxBytes))
+	if report != nil && (report.Created > 0 || report.Existing > 0 || report.Invalid > 0) {
+		details := map[string]string{
+			"format":   format,
+			"created":  strconv.Itoa(report.Created),
+			"existing": strconv.Itoa(report.Existing),
+			"invalid":  strconv.Itoa(report.Invalid),
+		}
+		// the users are already imported, so the response doesn't fail
+		if err := a.recordAudit(r, entity.AuditUserImport, "", details); err != nil {
+			a.logger.Error(fmt.Sprintf("err when auditing user import: %s", err.Error()))
+		}
+	}
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "the file is larger than 32 MB, import it with the CLI", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if errors.Is(err, errInvalidImport) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.logger.Error(fmt.Sprintf("err when importing users: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, ImportResponse{Code: http.StatusOK, Result: *report})
+}
@@ -0,0 +1,590 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aph138/dekamond/internal/cache"
+	"github.com/aph138/dekamond/internal/db"
+	"github.com/aph138/dekamond/internal/entity"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"golang.org/x/text/language"
+)
+
+type UserResponse struct {
+	Code   int         `json:"code"`
+	Result entity.User `json:"result"`
+}
+
+// UpdateProfileRequest holds the profile fields to change.
+// Missing fields are left unchanged and empty strings remove the field.
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"display_name,omitempty" example:"Ali"`
+	Email       *string `json:"email,omitempty" example:"ali@example.com"`
+	Locale      *string `json:"locale,omitempty" example:"fa-IR"`
+	AvatarURL   *string `json:"avatar_url,omitempty" example:"https://cdn.example.com/avatar.png"`
+}
+
+// validate checks the fields which are set and normalizes them
+func (req *UpdateProfileRequest) validate() error {
+	if req.DisplayName != nil {
+		*req.DisplayName = strings.TrimSpace(*req.DisplayName)
+		if utf8.RuneCountInString(*req.DisplayName) > 64 {
+			return errors.New("display_name must be at most 64 characters")
+		}
+	}
+	if req.Email != nil && len(*req.Email) > 0 {
+		addr, err := mail.ParseAddress(*req.Email)
+		if err != nil || addr.Address != *req.Email || len(*req.Email) > 254 {
+			return errors.New("invalid email")
+		}
+		*req.Email = strings.ToLower(*req.Email)
+	}
+	if req.Locale != nil && len(*req.Locale) > 0 {
+		tag, err := language.Parse(*req.Locale)
+		if err != nil {
+			return errors.New("locale must be a BCP 47 language tag such as fa-IR")
+		}
+		*req.Locale = tag.String()
+	}
+	if req.AvatarURL != nil && len(*req.AvatarURL) > 0 {
+		u, err := url.Parse(*req.AvatarURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" || len(*req.AvatarURL) > 2048 {
+			return errors.New("avatar_url must be an https URL")
+		}
+	}
+	return nil
+}
+
+type ChangePhoneRequest struct {
+	Phone string `json:"phone" example:"09012345678"`
+}
+type ChangePhoneResponse struct {
+	// ConfirmOld is true if an OTP code is sent to the old number as well
+	ConfirmOld bool `json:"confirm_old"`
+}
+type VerifyPhoneChangeRequest struct {
+	// Code is the OTP code which is sent to the new number
+	Code string `json:"code" example:"123456"`
+	// OldCode is the OTP code which is sent to the old number, if it's required
+	OldCode string `json:"old_code,omitempty" example:"654321"`
+}
+
+// pendingPhoneChange is kept in cache until the new number is verified
+type pendingPhoneChange struct {
+	OldPhone string `json:"old_phone"`
+	NewPhone string `json:"new_phone"`
+}
+
+const (
+	phoneChangeTTL       = time.Minute * 10
+	phoneChangeKeyPrefix = "phone_change:"
+	phoneHoldKeyPrefix   = "phone_hold:"
+)
+
+// phoneHolder returns the ID of the user which recently changed away from the phone number.
+// The number can't be registered by anyone else during the hold period.
+// It returns an empty string if the number isn't held or its holder is deleted.
+func (a *Application) phoneHolder(phone string) (string, error) {
+	holder, err := a.cache.GetValue(phoneHoldKeyPrefix + phone)
+	if errors.Is(err, cache.ErrNotFound) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	// holds are stored by phone number, so they aren't removed with the user
+	if _, err := a.db.FindUser(string(holder)); errors.Is(err, db.ErrNotFound) {
+		if _, err := a.cache.TakeValue(phoneHoldKeyPrefix + phone); err != nil && !errors.Is(err, cache.ErrNotFound) {
+			a.logger.Error(fmt.Sprintf("err when removing phone hold of deleted user: %s", err.Error()))
+		}
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return string(holder), nil
+}
+
+type DeleteAccountRequest struct {
+	// Code is the OTP code which is sent to the phone number of the account
+	Code string `json:"code" example:"123456"`
+}
+
+// ExportResponse holds everything which is stored about the user
+type ExportResponse struct {
+	ExportedAt  time.Time           `json:"exported_at"`
+	User        entity.User         `json:"user"`
+	Sessions    []entity.Session    `json:"sessions"`
+	LoginEvents []entity.LoginEvent `json:"login_events"`
+}
+
+type SessionResponse struct {
+	entity.Session
+	// Current is true for the session of the token which is used for the request
+	Current bool `json:"current"`
+}
+type SessionsResponse struct {
+	Code   int               `json:"code"`
+	Result []SessionResponse `json:"result"`
+}
+
+// clientIP returns the IP address of the direct peer
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// newSession creates a session for a token which is issued to the user and returns its ID
+func (a *Application) newSession(r *http.Request, userID, deviceName string, ttl time.Duration) (string, error) {
+	userObjectID, err := bson.ObjectIDFromHex(userID)
+	if err != nil {
+		return "", fmt.Errorf("err when parsing user id: %w", err)
+	}
+	now := time.Now()
+	return a.db.CreateSession(&entity.Session{
+		UserID:     userObjectID,
+		DeviceName: deviceName,
+		IP:         clientIP(r),
+		UserAgent:  r.UserAgent(),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(ttl),
+	})
+}
+
+// @Summery		Get profile
+// @Description	Returns the user which owns the token
+// @Tags			me
+// @Produce		json
+// @Security		BearerAuth
+// @Success		200	{object}	UserResponse
+// @Failure		401	{string}	string	"unauthorized access"
+// @Router			/me [get]
+func (a *Application) GetProfileHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFromContext(r.Context())
+	user, err := a.db.FindUser(principal.UserID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "unauthorized access", http.StatusUnauthorized)
+			return
+		}
+		a.logger.Error(fmt.Sprintf("err when finding user at /me: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, UserResponse{Code: http.StatusOK, Result: *user})
+}
+
+// @Summery		Update profile
+// @Description	Changes the profile fields which are sent. Send an empty string to remove a field.
+// @Tags			me
+// @Accept			json
+// @Produce		json
+// @Security		BearerAuth
+// @Param			request	body		UpdateProfileRequest	true	"profile fields"
+// @Success		200		{object}	UserResponse
+// @Failure		400		{string}	string	"invalid field"
+// @Failure		401		{string}	string	"unauthorized access"
+// @Router			/me [patch]
+func (a *Application) UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFromContext(r.Context())
+	var req UpdateProfileRequest
+	reqDecoder := json.NewDecoder(r.Body)
+	reqDecoder.DisallowUnknownFields() // for strict validation
+	if err := reqDecoder.Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := a.db.UpdateUser(principal.UserID, db.UserUpdate{
+		DisplayName: req.DisplayName,
+		Email:       req.Email,
+		Locale:      req.Locale,
+		AvatarURL:   req.AvatarURL,
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "unauthorized access", http.StatusUnauthorized)
+			return
+		}
+		a.logger.Error(fmt.Sprintf("err when updating user at /me: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, UserResponse{Code: http.StatusOK, Result: *user})
+}
+
+// @Summery		Change phone number
+// @Description	Sends an OTP code to the new phone number, and to the old one if confirming with the old number is enabled. The change is applied by /me/phone/verify within 10 minutes.
+// @Tags			me
+// @Accept			json
+// @Produce		json
+// @Security		BearerAuth
+// @Param			request	body		ChangePhoneRequest	true	"the new phone number"
+// @Success		202		{object}	ChangePhoneResponse
+// @Failure		400		{string}	string	"invalid phone number"
+// @Failure		409		{string}	string	"phone number is not available"
+// @Failure		429		{string}	string	"a valid code still exists"
+// @Router			/me/phone [post]
+func (a *Application) ChangePhoneHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFromContext(r.Context())
+	var req ChangePhoneRequest
+	reqDecoder := json.NewDecoder(r.Body)
+	reqDecoder.DisallowUnknownFields() // for strict validation
+	if err := reqDecoder.Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !phoneRegex.MatchString(req.Phone) {
+		http.Error(w, "invalid phone number", http.StatusBadRequest)
+		return
+	}
+	user, err := a.db.FindUser(principal.UserID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "unauthorized access", http.StatusUnauthorized)
+			return
+		}
+		a.logger.Error(fmt.Sprintf("err when finding user at /me/phone: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	if user.Phone == req.Phone {
+		http.Error(w, "the new phone number is the same as the current one", http.StatusBadRequest)
+		return
+	}
+
+	// the number must be neither taken nor held for another user
+	holder, err := a.phoneHolder(req.Phone)
+	if err != nil {
+		a.logger.Error(fmt.Sprintf("err when checking phone hold: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	if holder != "" && holder != principal.UserID {
+		http.Error(w, "phone number is not available", http.StatusConflict)
+		return
+	}
+	existing, err := a.db.SearchUser(db.SearchUserByPhone(req.Phone), db.SearchUserByPagination(1, 1))
+	if err != nil {
+		a.logger.Error(fmt.Sprintf("err when searching phone at /me/phone: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	if len(existing.Users) > 0 {
+		http.Error(w, "phone number is not available", http.StatusConflict)
+		return
+	}
+
+	phones := []string{req.Phone}
+	if a.confirmOldPhone {
+		phones = append(phones, user.Phone)
+	}
+	for _, phone := range phones {
+		code, err := a.cache.NewOTPCodeFor(cache.PurposePhoneChange, phone)
+		if err != nil {
+			if errors.Is(err, cache.ErrOTPStillValid) {
+				http.Error(w, "You still have a valid code. Please try again later.", http.StatusTooManyRequests)
+				return
+			}
+			a.logger.Error(fmt.Sprintf("err when generating OTP code: %s", err.Error()))
+			http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+			return
+		}
+		a.logger.Info(fmt.Sprintf("%s: %s", phone, code))
+	}
+
+	data, _ := json.Marshal(pendingPhoneChange{OldPhone: user.Phone, NewPhone: req.Phone})
+	if err := a.cache.SetValue(phoneChangeKeyPrefix+principal.UserID, data, phoneChangeTTL); err != nil {
+		a.logger.Error(fmt.Sprintf("err when saving pending phone change: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	a.writeJSON(w, http.StatusAccepted, ChangePhoneResponse{ConfirmOld: a.confirmOldPhone})
+}
+
+// @Summery		Verify phone number change
+// @Description	Verifies the OTP codes and changes the phone number. All sessions are signed out afterwards and the old number can't be registered by anyone else during the hold period.
+// @Tags			me
+// @Accept			json
+// @Produce		json
+// @Security		BearerAuth
+// @Param			request	body		VerifyPhoneChangeRequest	true	"OTP codes"
+// @Success		200		{object}	UserResponse
+// @Failure		400		{string}	string	"no pending phone change"
+// @Failure		401		{string}	string	"invalid code"
+// @Failure		409		{string}	string	"phone number is not available"
+// @Failure		429		{string}	string	"rate limit exceeded"
+// @Router			/me/phone/verify [post]
+func (a *Application) VerifyPhoneChangeHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFromContext(r.Context())
+	var req VerifyPhoneChangeRequest
+	reqDecoder := json.NewDecoder(r.Body)
+	reqDecoder.DisallowUnknownFields() // for strict validation
+	if err := reqDecoder.Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	data, err := a.cache.GetValue(phoneChangeKeyPrefix + principal.UserID)
+	if err != nil {
+		if errors.Is(err, cache.ErrNotFound) {
+			http.Error(w, "no pending phone change", http.StatusBadRequest)
+			return
+		}
+		a.logger.Error(fmt.Sprintf("err when getting pending phone change: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	var pending pendingPhoneChange
+	if err := json.Unmarshal(data, &pending); err != nil {
+		a.logger.Error(fmt.Sprintf("err when decoding pending phone change: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+
+	codes := map[string]string{pending.NewPhone: req.Code}
+	if a.confirmOldPhone {
+		codes[pending.OldPhone] = req.OldCode
+	}
+	for phone, code := range codes {
+		if err := a.cache.VerifyOTPCodeFor(cache.PurposePhoneChange, phone, code); err != nil {
+			if errors.Is(err, cache.ErrRateLimit) {
+				http.Error(w, "Rate limit exceeded. Please try again later.", http.StatusTooManyRequests)
+				return
+			}
+			if !errors.Is(err, cache.ErrInvalidCode) {
+				a.logger.Error(fmt.Sprintf("err when verifying OTP code: %s", err.Error()))
+			}
+			http.Error(w, "invalid code", http.StatusUnauthorized)
+			return
+		}
+	}
+	if _, err := a.cache.TakeValue(phoneChangeKeyPrefix + principal.UserID); err != nil && !errors.Is(err, cache.ErrNotFound) {
+		a.logger.Error(fmt.Sprintf("err when removing pending phone change: %s", err.Error()))
+	}
+
+	user, err := a.db.UpdateUserPhone(principal.UserID, pending.NewPhone)
+	if err != nil {
+		if errors.Is(err, db.ErrDuplicate) {
+			http.Error(w, "phone number is not available", http.StatusConflict)
+			return
+		}
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "unauthorized access", http.StatusUnauthorized)
+			return
+		}
+		a.logger.Error(fmt.Sprintf("err when updating phone: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	if a.phoneHoldPeriod > 0 {
+		if err := a.cache.SetValue(phoneHoldKeyPrefix+pending.OldPhone, []byte(principal.UserID), a.phoneHoldPeriod); err != nil {
+			a.logger.Error(fmt.Sprintf("err when holding old phone number: %s", err.Error()))
+		}
+	}
+	// tokens are issued for the old number, so every device must log in again
+	if err := a.db.DeleteUserSessions(principal.UserID); err != nil {
+		a.logger.Error(fmt.Sprintf("err when deleting sessions after phone change: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, UserResponse{Code: http.StatusOK, Result: *user})
+}
+
+// @Summery		Delete account
+// @Description	Deletes the account in two steps. Without a code, an OTP code is sent to the phone number of the account.
+// @Description	With the code, the user, its sessions, its login history and its OTP state are removed and every token of the user is rejected afterwards.
+// @Tags			me
+// @Accept			json
+// @Security		BearerAuth
+// @Param			request	body	DeleteAccountRequest	false	"the OTP code"
+// @Success		202		"OTP code is sent"
+// @Success		204		"No Content"
+// @Failure		401		{string}	string	"invalid code"
+// @Failure		429		{string}	string	"rate limit exceeded"
+// @Router			/me [delete]
+func (a *Application) DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFromContext(r.Context())
+	var req DeleteAccountRequest
+	reqDecoder := json.NewDecoder(r.Body)
+	reqDecoder.DisallowUnknownFields() // for strict validation
+	// the body is optional for requesting a code
+	if err := reqDecoder.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	user, err := a.db.FindUser(principal.UserID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "unauthorized access", http.StatusUnauthorized)
+			return
+		}
+		a.logger.Error(fmt.Sprintf("err when finding user at /me: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+
+	if len(req.Code) == 0 {
+		code, err := a.cache.NewOTPCodeFor(cache.PurposeDeleteAccount, user.Phone)
+		if err != nil {
+			if errors.Is(err, cache.ErrOTPStillValid) {
+				http.Error(w, "You still have a valid code. Please try again later.", http.StatusTooManyRequests)
+				return
+			}
+			a.logger.Error(fmt.Sprintf("err when generating OTP code: %s", err.Error()))
+			http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+			return
+		}
+		a.logger.Info(fmt.Sprintf("%s: %s", user.Phone, code))
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if err := a.cache.VerifyOTPCodeFor(cache.PurposeDeleteAccount, user.Phone, req.Code); err != nil {
+		if errors.Is(err, cache.ErrRateLimit) {
+			http.Error(w, "Rate limit exceeded. Please try again later.", http.StatusTooManyRequests)
+			return
+		}
+		if !errors.Is(err, cache.ErrInvalidCode) {
+			a.logger.Error(fmt.Sprintf("err when verifying OTP code: %s", err.Error()))
+		}
+		http.Error(w, "invalid code", http.StatusUnauthorized)
+		return
+	}
+	if err := a.removeUser(user); err != nil {
+		a.logger.Error(err.Error())
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeUser deletes the user with its sessions, login events and OTP state
+func (a *Application) removeUser(user *entity.User) error {
+	userID := user.ID.Hex()
+	if err := a.db.DeleteUser(userID); err != nil && !errors.Is(err, db.ErrNotFound) {
+		return fmt.Errorf("err when deleting user: %w", err)
+	}
+	// tokens without a session are rejected, so the user is signed out everywhere
+	if err := a.db.DeleteUserSessions(userID); err != nil {
+		return fmt.Errorf("err when deleting sessions of deleted user: %w", err)
+	}
+	if err := a.db.DeleteLoginEvents(userID, user.Phone, phoneEventsSince(user)); err != nil {
+		return fmt.Errorf("err when deleting login events of deleted user: %w", err)
+	}
+	if err := a.cache.ClearPhone(user.Phone); err != nil {
+		a.logger.Error(fmt.Sprintf("err when clearing OTP state of deleted user: %s", err.Error()))
+	}
+	if _, err := a.cache.TakeValue(phoneChangeKeyPrefix + userID); err != nil && !errors.Is(err, cache.ErrNotFound) {
+		a.logger.Error(fmt.Sprintf("err when removing pending phone change of deleted user: %s", err.Error()))
+	}
+	return nil
+}
+
+// @Summery		Export personal data
+// @Description	Returns a JSON archive of everything which is stored about the user.
+// @Description	Audit records of admin actions on the account aren't included, they belong to the admins who did them.
+// @Tags			me
+// @Produce		json
+// @Security		BearerAuth
+// @Success		200	{object}	ExportResponse
+// @Failure		401	{string}	string	"unauthorized access"
+// @Router			/me/export [get]
+func (a *Application) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFromContext(r.Context())
+	user, err := a.db.FindUser(principal.UserID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "unauthorized access", http.StatusUnauthorized)
+			return
+		}
+		a.logger.Error(fmt.Sprintf("err when finding user at /me/export: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	sessions, err := a.db.ListSessions(principal.UserID)
+	if err != nil {
+		a.logger.Error(fmt.Sprintf("err when listing sessions at /me/export: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	events, err := a.db.ListLoginEvents(principal.UserID, user.Phone, phoneEventsSince(user), 0)
+	if err != nil {
+		a.logger.Error(fmt.Sprintf("err when listing login events at /me/export: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="dekamond-export.json"`)
+	a.writeJSON(w, http.StatusOK, ExportResponse{
+		ExportedAt:  time.Now(),
+		User:        *user,
+		Sessions:    sessions,
+		LoginEvents: events,
+	})
+}
+
+// @Summery		List active sessions
+// @Description	Returns the devices which the user is logged in with
+// @Tags			me
+// @Produce		json
+// @Security		BearerAuth
+// @Success		200	{object}	SessionsResponse
+// @Failure		401	{string}	string	"unauthorized access"
+// @Router			/me/sessions [get]
+func (a *Application) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFromContext(r.Context())
+	sessions, err := a.db.ListSessions(principal.UserID)
+	if err != nil {
+		a.logger.Error(fmt.Sprintf("err when listing sessions: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	res := SessionsResponse{
+		Code:   http.StatusOK,
+		Result: make([]SessionResponse, 0, len(sessions)),
+	}
+	for _, s := range sessions {
+		res.Result = append(res.Result, SessionResponse{
+			Session: s,
+			Current: s.ID.Hex() == principal.SessionID,
+		})
+	}
+	a.writeJSON(w, http.StatusOK, res)
+}
+
+// @Summery		Sign out a device
+// @Description	Removes a session of the user, tokens of that session are rejected afterwards
+// @Tags			me
+// @Security		BearerAuth
+// @Param			id	path	string	true	"session ID"
+// @Success		204	"No Content"
+// @Failure		401	{string}	string	"unauthorized access"
+// @Failure		404	{string}	string	"session not found"
+// @Router			/me/sessions/{id} [delete]
+func (a *Application) DeleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFromContext(r.Context())
+	if err := a.db.DeleteSession(r.PathValue("id"), principal.UserID); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+		a.logger.Error(fmt.Sprintf("err when deleting session: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
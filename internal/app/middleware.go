@@ -0,0 +1,211 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aph138/dekamond/internal/db"
+	"github.com/aph138/dekamond/internal/entity"
+	"github.com/aph138/dekamond/pkg/authentication"
+)
+
+type contextKey int
+
+const principalKey contextKey = iota
+
+// sessionTouchInterval is the minimum time between two updates of the last seen time of a session
+const sessionTouchInterval = time.Minute
+
+// Principal holds the identity of an authenticated caller
+type Principal struct {
+	UserID    string
+	Roles     []string
+	TokenID   string
+	SessionID string
+	// Scopes has api for tokens issued by /check, tokens of OpenID Connect clients only have the granted scopes
+	Scopes    []string
+	ExpiresAt time.Time
+}
+
+// HasRole reports whether the principal has at least one of the given roles
+func (p *Principal) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if slices.Contains(p.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// PrincipalFromContext returns the principal which is stored by AuthMiddleware
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey).(*Principal)
+	return p, ok
+}
+
+// writeAccountStatusError rejects a user whose account isn't active.
+// The error code is account_ followed by the status, such as account_suspended.
+func (a *Application) writeAccountStatusError(w http.ResponseWriter, status string) {
+	a.writeOAuthError(w, http.StatusForbidden, "account_"+status, "the account is "+status)
+}
+
+// validAudience reports whether every audience of a token issued by /check is one of the configured audiences.
+// Tokens of OpenID Connect clients must have a registered client as audience.
+func (a *Application) validAudience(claims *authentication.CustomClaim) (bool, error) {
+	if !slices.Contains(strings.Fields(claims.Value["scope"]), scopeAPI) {
+		if len(claims.Audience) == 0 {
+			return false, nil
+		}
+		for _, aud := range claims.Audience {
+			if _, err := a.db.FindClient(aud); err != nil {
+				if errors.Is(err, db.ErrNotFound) {
+					return false, nil
+				}
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	for _, aud := range claims.Audience {
+		if !slices.Contains(a.tokenAudiences, aud) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// AuthMiddleware validates the bearer token and puts the caller's Principal into the request context.
+// Only tokens issued by /check are accepted, tokens of OpenID Connect clients are rejected.
+func (a *Application) AuthMiddleware(next http.Handler) http.Handler {
+	return a.authenticate(next, scopeAPI)
+}
+
+// authenticate is like AuthMiddleware but accepts any token which has the scope
+func (a *Application) authenticate(next http.Handler, scope string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization")
+		if token == "" || !strings.HasPrefix(token, "Bearer ") {
+			http.Error(w, "unauthorized access", http.StatusUnauthorized)
+			return
+		}
+		token = strings.TrimPrefix(token, "Bearer ")
+
+		claims, err := a.jwt.ParseClaims(token)
+		if err != nil {
+			http.Error(w, "unauthorized access", http.StatusUnauthorized)
+			return
+		}
+		userID := claims.Value["id"]
+		sessionID := claims.Value["sid"]
+		if userID == "" || sessionID == "" {
+			http.Error(w, "unauthorized access", http.StatusUnauthorized)
+			return
+		}
+		valid, err := a.validAudience(claims)
+		if err != nil {
+			a.logger.Error(fmt.Sprintf("err when checking token audience: %s", err.Error()))
+			http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+			return
+		}
+		if !valid {
+			http.Error(w, "unauthorized access", http.StatusUnauthorized)
+			return
+		}
+		scopes := strings.Fields(claims.Value["scope"])
+		if !slices.Contains(scopes, scope) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+			a.writeOAuthError(w, http.StatusForbidden, "insufficient_scope", "the token doesn't have "+scope+" scope")
+			return
+		}
+		revoked, err := a.cache.IsTokenRevoked(claims.ID)
+		if err != nil {
+			a.logger.Error(fmt.Sprintf("err when checking token revocation: %s", err.Error()))
+			http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+			return
+		}
+		if revoked {
+			http.Error(w, "unauthorized access", http.StatusUnauthorized)
+			return
+		}
+		// the session is removed when the user signs out that device
+		session, err := a.db.FindSession(sessionID)
+		if err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				http.Error(w, "unauthorized access", http.StatusUnauthorized)
+				return
+			}
+			a.logger.Error(fmt.Sprintf("err when finding session: %s", err.Error()))
+			http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+			return
+		}
+		if session.UserID.Hex() != userID {
+			http.Error(w, "unauthorized access", http.StatusUnauthorized)
+			return
+		}
+		// last seen time is only updated once per sessionTouchInterval to avoid a write on every request
+		if time.Since(session.LastSeenAt) >= sessionTouchInterval {
+			if err := a.db.TouchSession(sessionID, userID); err != nil {
+				if errors.Is(err, db.ErrNotFound) {
+					http.Error(w, "unauthorized access", http.StatusUnauthorized)
+					return
+				}
+				a.logger.Error(fmt.Sprintf("err when touching session: %s", err.Error()))
+				http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+				return
+			}
+		}
+		// suspended, banned and deleted users are rejected even with a valid session
+		user, err := a.db.FindUser(userID)
+		if err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				http.Error(w, "unauthorized access", http.StatusUnauthorized)
+				return
+			}
+			a.logger.Error(fmt.Sprintf("err when finding user of token: %s", err.Error()))
+			http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+			return
+		}
+		if user.GetStatus() != entity.StatusActive {
+			a.writeAccountStatusError(w, user.GetStatus())
+			return
+		}
+		var roles []string
+		if len(claims.Value["roles"]) > 0 {
+			roles = strings.Split(claims.Value["roles"], ",")
+		}
+		principal := &Principal{
+			UserID:    userID,
+			Roles:     roles,
+			TokenID:   claims.ID,
+			SessionID: sessionID,
+			Scopes:    scopes,
+		}
+		if claims.ExpiresAt != nil {
+			principal.ExpiresAt = claims.ExpiresAt.Time
+		}
+		ctx := context.WithValue(r.Context(), principalKey, principal)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireRole only lets callers with at least one of the given roles through.
+// It must be wrapped by AuthMiddleware.
+func (a *Application) RequireRole(next http.Handler, roles ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			http.Error(w, "unauthorized access", http.StatusUnauthorized)
+			return
+		}
+		if !principal.HasRole(roles...) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
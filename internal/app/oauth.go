@@ -0,0 +1,168 @@
+package app
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aph138/dekamond/internal/db"
+	"github.com/aph138/dekamond/internal/entity"
+)
+
+// scopeAPI grants access to the endpoints of the service, it is never granted to clients
+const scopeAPI = "api"
+
+// FirstPartyScope is the scope of tokens issued by /check
+const FirstPartyScope = "openid phone " + scopeAPI
+
+// OAuthError is the error body defined by RFC 6749 section 5.2
+type OAuthError struct {
+	Error            string `json:"error" example:"invalid_request"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// IntrospectionResponse is the response of /introspect as defined by RFC 7662.
+// Roles is an extension which carries the roles of the token owner.
+type IntrospectionResponse struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Nbf       int64    `json:"nbf,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Aud       []string `json:"aud,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+}
+
+func (a *Application) writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(OAuthError{Error: code, ErrorDescription: description}); err != nil {
+		a.logger.Error(fmt.Sprintf("err when encoding oauth error: %s", err.Error()))
+	}
+}
+
+// authenticateClient checks client credentials sent either by basic auth or in the form body
+func authenticateClient(r *http.Request, clients map[string]string) (string, bool) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostFormValue("client_id")
+		clientSecret = r.PostFormValue("client_secret")
+	}
+	if clientID == "" {
+		return "", false
+	}
+	expected, exists := clients[clientID]
+	if !exists {
+		return "", false
+	}
+	// comparing hashes so the comparison doesn't leak the secret length
+	given := sha256.Sum256([]byte(clientSecret))
+	wanted := sha256.Sum256([]byte(expected))
+	if subtle.ConstantTimeCompare(given[:], wanted[:]) != 1 {
+		return "", false
+	}
+	return clientID, true
+}
+
+// @Summery		Token introspection endpoint
+// @Description	Returns the state of a token as defined by RFC 7662. Revoked tokens, tokens of signed out sessions and tokens of users which aren't active are inactive. Callers must authenticate with client credentials, either by basic auth or client_id and client_secret form fields.
+// @Tags			oauth
+// @Accept			x-www-form-urlencoded
+// @Produce		json
+// @Param			token			formData	string	true	"the token to introspect"
+// @Param			token_type_hint	formData	string	false	"ignored, only access tokens are supported"
+// @Success		200				{object}	IntrospectionResponse
+// @Failure		400				{object}	OAuthError
+// @Failure		401				{object}	OAuthError
+// @Failure		500				{object}	OAuthError
+// @Router			/introspect [post]
+func (a *Application) IntrospectHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		a.writeOAuthError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+	if _, ok := authenticateClient(r, a.introspectionClients); !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="introspect"`)
+		a.writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+	token := r.PostFormValue("token")
+	if token == "" {
+		a.writeOAuthError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	res := IntrospectionResponse{Active: false}
+	// any parse error means the token is expired, malformed or not issued by us
+	if claims, err := a.jwt.ParseClaims(token); err == nil {
+		valid, err := a.validAudience(claims)
+		if err != nil {
+			a.logger.Error(fmt.Sprintf("err when checking token audience at /introspect: %s", err.Error()))
+			a.writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+			return
+		}
+		revoked, err := a.cache.IsTokenRevoked(claims.ID)
+		if err != nil {
+			a.logger.Error(fmt.Sprintf("err when checking token revocation at /introspect: %s", err.Error()))
+			a.writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+			return
+		}
+		// tokens of a signed out device are not active either
+		session, err := a.db.FindSession(claims.Value["sid"])
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			a.logger.Error(fmt.Sprintf("err when finding session at /introspect: %s", err.Error()))
+			a.writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+			return
+		}
+		// tokens of users which aren't active are not active either
+		active := valid && !revoked && session != nil && session.UserID.Hex() == claims.Value["id"]
+		if active {
+			user, err := a.db.FindUser(claims.Value["id"])
+			if err != nil && !errors.Is(err, db.ErrNotFound) {
+				a.logger.Error(fmt.Sprintf("err when finding user at /introspect: %s", err.Error()))
+				a.writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+				return
+			}
+			active = user != nil && user.GetStatus() == entity.StatusActive
+		}
+		if active {
+			res = IntrospectionResponse{
+				Active:    true,
+				Scope:     claims.Value["scope"],
+				TokenType: "Bearer",
+				Sub:       claims.Value["id"],
+				Aud:       claims.Audience,
+				Iss:       claims.Issuer,
+				Jti:       claims.ID,
+			}
+			if len(claims.Value["roles"]) > 0 {
+				res.Roles = strings.Split(claims.Value["roles"], ",")
+			}
+			if claims.ExpiresAt != nil {
+				res.Exp = claims.ExpiresAt.Unix()
+			}
+			if claims.IssuedAt != nil {
+				res.Iat = claims.IssuedAt.Unix()
+			}
+			if claims.NotBefore != nil {
+				res.Nbf = claims.NotBefore.Unix()
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		a.logger.Error("err when encoding introspection result " + err.Error())
+	}
+}
@@ -0,0 +1,573 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aph138/dekamond/internal/cache"
+	"github.com/aph138/dekamond/internal/db"
+	"github.com/aph138/dekamond/internal/entity"
+	"github.com/aph138/dekamond/pkg/authentication"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	authRequestTTL       = time.Minute * 10
+	authCodeTTL          = time.Minute
+	oidcAccessTokenTTL   = time.Hour
+	idTokenTTL           = time.Hour
+	authRequestKeyPrefix = "oidc:request:"
+	authCodeKeyPrefix    = "oidc:code:"
+)
+
+// scopes which can be granted to clients, anything else is dropped
+var supportedScopes = []string{"openid", "phone"}
+
+// OpenIDConfig configures the OpenID Connect provider mode
+type OpenIDConfig struct {
+	// Issuer is the public base URL of the service, e.g. https://auth.example.com
+	Issuer string
+	// LoginURL is the page which runs /login and /check for an authorization request.
+	// The request ID is appended as auth_request query parameter.
+	LoginURL string
+	Signer   *authentication.IDTokenSigner
+}
+
+// WithOpenIDProvider enables OpenID Connect endpoints
+func WithOpenIDProvider(cfg OpenIDConfig) ApplicationOption {
+	return func(a *Application) {
+		cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+		a.oidc = &cfg
+	}
+}
+
+// authorizationRequest is kept in cache while the user logs in with OTP
+type authorizationRequest struct {
+	ClientID      string `json:"client_id"`
+	RedirectURI   string `json:"redirect_uri"`
+	Scope         string `json:"scope"`
+	State         string `json:"state"`
+	Nonce         string `json:"nonce"`
+	CodeChallenge string `json:"code_challenge"`
+}
+
+// authorizationCode is kept in cache until the client exchanges it at /token
+type authorizationCode struct {
+	authorizationRequest
+	UserID   string `json:"user_id"`
+	Phone    string `json:"phone"`
+	AuthTime int64  `json:"auth_time"`
+}
+
+type AuthorizeResponse struct {
+	AuthRequest string `json:"auth_request"`
+}
+type CheckAuthorizeResponse struct {
+	RedirectTo string `json:"redirect_to" example:"https://app.example.com/callback?code=abc&state=xyz"`
+}
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type" example:"Bearer"`
+	ExpiresIn   int64  `json:"expires_in" example:"3600"`
+	Scope       string `json:"scope,omitempty" example:"openid phone"`
+	IDToken     string `json:"id_token,omitempty"`
+}
+type UserInfoResponse struct {
+	Sub                 string `json:"sub"`
+	PhoneNumber         string `json:"phone_number,omitempty" example:"+989012345678"`
+	PhoneNumberVerified bool   `json:"phone_number_verified,omitempty"`
+}
+type DiscoveryDocument struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+type ClientRequest struct {
+	Name         string   `json:"name" example:"My App"`
+	RedirectURIs []string `json:"redirect_uris" example:"https://app.example.com/callback"`
+	// Public clients have no secret and must use PKCE, e.g. mobile and single page apps
+	Public bool `json:"public"`
+}
+type ClientResponse struct {
+	entity.Client
+	// ClientSecret is returned only once when the client is created
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+func randomString(length int) (string, error) {
+	b := make([]byte, length)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("err when generating random string: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashClientSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// e164Phone converts a local phone number such as 09012345678 to +989012345678
+func e164Phone(phone string) string {
+	return "+98" + strings.TrimPrefix(phone, "0")
+}
+
+func (a *Application) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		a.logger.Error("err when encoding response " + err.Error())
+	}
+}
+
+// redirectWithError sends an authorization error back to the client as defined by RFC 6749 section 4.1.2.1
+func redirectWithError(w http.ResponseWriter, r *http.Request, redirectURI, state, code, description string) {
+	u, _ := url.Parse(redirectURI)
+	q := u.Query()
+	q.Set("error", code)
+	q.Set("error_description", description)
+	if state != "" {
+		q.Set("state", state)
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// @Summery		OpenID Connect discovery document
+// @Tags			oidc
+// @Produce		json
+// @Success		200	{object}	DiscoveryDocument
+// @Router			/.well-known/openid-configuration [get]
+func (a *Application) DiscoveryHandler(w http.ResponseWriter, r *http.Request) {
+	issuer := a.oidc.Issuer
+	a.writeJSON(w, http.StatusOK, DiscoveryDocument{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/authorize",
+		TokenEndpoint:                     issuer + "/token",
+		UserInfoEndpoint:                  issuer + "/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             issuer + "/introspect",
+		ScopesSupported:                   supportedScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported: []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "phone_number", "phone_number_verified",
+		},
+	})
+}
+
+// @Summery		JSON Web Key Set for verifying ID tokens
+// @Tags			oidc
+// @Produce		json
+// @Success		200
+// @Router			/.well-known/jwks.json [get]
+func (a *Application) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	a.writeJSON(w, http.StatusOK, a.oidc.Signer.JWKS())
+}
+
+// @Summery		Authorization endpoint
+// @Description	Starts the authorization code flow with PKCE. The request is kept for 10 minutes and the user is redirected to the login page with auth_request query parameter. The login page must send auth_request along with the OTP code to /check. If no login page is configured, the auth_request ID is returned as JSON.
+// @Tags			oidc
+// @Produce		json
+// @Param			response_type			query		string	true	"must be code"
+// @Param			client_id				query		string	true	"registered client ID"
+// @Param			redirect_uri			query		string	true	"one of the registered redirect URIs"
+// @Param			scope					query		string	true	"space separated scopes, must contain openid"	example(openid phone)
+// @Param			state					query		string	false	"opaque value which is returned to the client"
+// @Param			nonce					query		string	false	"value which is put in the ID token"
+// @Param			code_challenge			query		string	true	"PKCE code challenge"
+// @Param			code_challenge_method	query		string	true	"must be S256"
+// @Success		200						{object}	AuthorizeResponse
+// @Success		302						"redirect to the login page or to the client with an error"
+// @Failure		400						{string}	string	"invalid client or redirect URI"
+// @Router			/authorize [get]
+func (a *Application) AuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	clientID := q.Get("client_id")
+	redirectURI := q.Get("redirect_uri")
+	state := q.Get("state")
+
+	// client and redirect URI must be checked before redirecting anywhere
+	client, err := a.db.FindClient(clientID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "invalid client_id", http.StatusBadRequest)
+			return
+		}
+		a.logger.Error(fmt.Sprintf("err when finding client at /authorize: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	if q.Get("response_type") != "code" {
+		redirectWithError(w, r, redirectURI, state, "unsupported_response_type", "only code is supported")
+		return
+	}
+	requested := strings.Fields(q.Get("scope"))
+	if !slices.Contains(requested, "openid") {
+		redirectWithError(w, r, redirectURI, state, "invalid_scope", "openid scope is required")
+		return
+	}
+	granted := []string{}
+	for _, scope := range requested {
+		if slices.Contains(supportedScopes, scope) && !slices.Contains(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		redirectWithError(w, r, redirectURI, state, "invalid_request", "PKCE with S256 is required")
+		return
+	}
+
+	requestID, err := randomString(24)
+	if err != nil {
+		a.logger.Error(err.Error())
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	data, _ := json.Marshal(authorizationRequest{
+		ClientID:      clientID,
+		RedirectURI:   redirectURI,
+		Scope:         strings.Join(granted, " "),
+		State:         state,
+		Nonce:         q.Get("nonce"),
+		CodeChallenge: q.Get("code_challenge"),
+	})
+	if err := a.cache.SetValue(authRequestKeyPrefix+requestID, data, authRequestTTL); err != nil {
+		a.logger.Error(fmt.Sprintf("err when saving authorization request: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+
+	if a.oidc.LoginURL == "" {
+		a.writeJSON(w, http.StatusOK, AuthorizeResponse{AuthRequest: requestID})
+		return
+	}
+	loginURL, err := url.Parse(a.oidc.LoginURL)
+	if err != nil {
+		a.logger.Error(fmt.Sprintf("err when parsing login URL: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	lq := loginURL.Query()
+	lq.Set("auth_request", requestID)
+	loginURL.RawQuery = lq.Encode()
+	http.Redirect(w, r, loginURL.String(), http.StatusFound)
+}
+
+// completeAuthorization is called by CheckHandler after a successful OTP verification
+// which carries an auth_request. It issues an authorization code for the pending request.
+func (a *Application) completeAuthorization(w http.ResponseWriter, requestID, userID, phone string) {
+	if a.oidc == nil {
+		http.Error(w, "OpenID Connect is not enabled", http.StatusBadRequest)
+		return
+	}
+	data, err := a.cache.TakeValue(authRequestKeyPrefix + requestID)
+	if err != nil {
+		if errors.Is(err, cache.ErrNotFound) {
+			http.Error(w, "authorization request is expired or invalid", http.StatusBadRequest)
+			return
+		}
+		a.logger.Error(fmt.Sprintf("err when taking authorization request: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	var authReq authorizationRequest
+	if err := json.Unmarshal(data, &authReq); err != nil {
+		a.logger.Error(fmt.Sprintf("err when decoding authorization request: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+
+	code, err := randomString(32)
+	if err != nil {
+		a.logger.Error(err.Error())
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	data, _ = json.Marshal(authorizationCode{
+		authorizationRequest: authReq,
+		UserID:               userID,
+		Phone:                phone,
+		AuthTime:             time.Now().Unix(),
+	})
+	if err := a.cache.SetValue(authCodeKeyPrefix+code, data, authCodeTTL); err != nil {
+		a.logger.Error(fmt.Sprintf("err when saving authorization code: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+
+	redirectTo, _ := url.Parse(authReq.RedirectURI)
+	q := redirectTo.Query()
+	q.Set("code", code)
+	if authReq.State != "" {
+		q.Set("state", authReq.State)
+	}
+	redirectTo.RawQuery = q.Encode()
+	a.writeJSON(w, http.StatusOK, CheckAuthorizeResponse{RedirectTo: redirectTo.String()})
+}
+
+// authenticateOIDCClient authenticates a registered client at /token.
+// Public clients send only client_id and are protected by PKCE.
+func (a *Application) authenticateOIDCClient(r *http.Request) (*entity.Client, error) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostFormValue("client_id")
+		clientSecret = r.PostFormValue("client_secret")
+	}
+	client, err := a.db.FindClient(clientID)
+	if err != nil {
+		return nil, err
+	}
+	if client.IsPublic() {
+		return client, nil
+	}
+	given := hashClientSecret(clientSecret)
+	if subtle.ConstantTimeCompare([]byte(given), []byte(client.SecretHash)) != 1 {
+		return nil, db.ErrNotFound
+	}
+	return client, nil
+}
+
+// @Summery		Token endpoint
+// @Description	Exchanges an authorization code and its PKCE code verifier for an access token and an ID token
+// @Tags			oidc
+// @Accept			x-www-form-urlencoded
+// @Produce		json
+// @Param			grant_type		formData	string	true	"must be authorization_code"
+// @Param			code			formData	string	true	"authorization code"
+// @Param			redirect_uri	formData	string	true	"the redirect URI which was sent to /authorize"
+// @Param			code_verifier	formData	string	true	"PKCE code verifier"
+// @Param			client_id		formData	string	false	"required if basic auth isn't used"
+// @Param			client_secret	formData	string	false	"required for confidential clients if basic auth isn't used"
+// @Success		200				{object}	TokenResponse
+// @Failure		400				{object}	OAuthError
+// @Failure		401				{object}	OAuthError
+// @Router			/token [post]
+func (a *Application) TokenHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if err := r.ParseForm(); err != nil {
+		a.writeOAuthError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		a.writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+	client, err := a.authenticateOIDCClient(r)
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			a.logger.Error(fmt.Sprintf("err when authenticating client at /token: %s", err.Error()))
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		a.writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+
+	// the code is removed on first use, whether the exchange succeeds or not
+	data, err := a.cache.TakeValue(authCodeKeyPrefix + r.PostFormValue("code"))
+	if err != nil {
+		if !errors.Is(err, cache.ErrNotFound) {
+			a.logger.Error(fmt.Sprintf("err when taking authorization code: %s", err.Error()))
+		}
+		a.writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "authorization code is invalid or expired")
+		return
+	}
+	var code authorizationCode
+	if err := json.Unmarshal(data, &code); err != nil {
+		a.logger.Error(fmt.Sprintf("err when decoding authorization code: %s", err.Error()))
+		a.writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "authorization code is invalid or expired")
+		return
+	}
+	if code.ClientID != client.ID || code.RedirectURI != r.PostFormValue("redirect_uri") {
+		a.writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "authorization code was issued for another client or redirect_uri")
+		return
+	}
+	if !authentication.VerifyPKCE(r.PostFormValue("code_verifier"), code.CodeChallenge) {
+		a.writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "invalid code_verifier")
+		return
+	}
+
+	// roles are read again since they may be changed after the code is issued
+	user, err := a.db.FindUser(code.UserID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			a.writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "user doesn't exist")
+			return
+		}
+		a.logger.Error(fmt.Sprintf("err when finding user at /token: %s", err.Error()))
+		a.writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	if user.GetStatus() != entity.StatusActive {
+		a.recordLoginEvent(r, start, code.UserID, code.Phone, entity.EventTokenIssued, entity.ChannelOIDC, entity.OutcomeBlocked)
+		a.writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "the account is "+user.GetStatus())
+		return
+	}
+	sessionID, err := a.newSession(r, code.UserID, client.Name, oidcAccessTokenTTL)
+	if err != nil {
+		a.logger.Error(fmt.Sprintf("err when creating session at /token: %s", err.Error()))
+		a.writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	// roles aren't given to clients, the token can only be used at /userinfo
+	accessToken, err := a.jwt.NewToken(map[string]string{
+		"id":    code.UserID,
+		"scope": code.Scope,
+		"sid":   sessionID,
+	}, oidcAccessTokenTTL, authentication.WithAudience(client.ID))
+	if err != nil {
+		a.logger.Error(fmt.Sprintf("err when generating access token: %s", err.Error()))
+		a.writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	idClaims := authentication.IDTokenClaims{
+		Nonce:    code.Nonce,
+		AuthTime: code.AuthTime,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:   a.oidc.Issuer,
+			Subject:  code.UserID,
+			Audience: jwt.ClaimStrings{client.ID},
+		},
+	}
+	if slices.Contains(strings.Fields(code.Scope), "phone") {
+		idClaims.PhoneNumber = e164Phone(code.Phone)
+		idClaims.PhoneNumberVerified = true
+	}
+	idToken, err := a.oidc.Signer.Sign(idClaims, idTokenTTL)
+	if err != nil {
+		a.logger.Error(fmt.Sprintf("err when generating id token: %s", err.Error()))
+		a.writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	a.recordLoginEvent(r, start, code.UserID, code.Phone, entity.EventTokenIssued, entity.ChannelOIDC, entity.OutcomeSuccess)
+	w.Header().Set("Cache-Control", "no-store")
+	a.writeJSON(w, http.StatusOK, TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(oidcAccessTokenTTL.Seconds()),
+		Scope:       code.Scope,
+		IDToken:     idToken,
+	})
+}
+
+// @Summery		UserInfo endpoint
+// @Description	Returns claims about the owner of the access token. Phone claims are returned only if the token has phone scope.
+// @Tags			oidc
+// @Produce		json
+// @Security		BearerAuth
+// @Success		200	{object}	UserInfoResponse
+// @Failure		401	{string}	string	"unauthorized access"
+// @Failure		403	{object}	OAuthError	"insufficient_scope if the token doesn't have openid scope"
+// @Router			/userinfo [get]
+func (a *Application) UserInfoHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFromContext(r.Context())
+	user, err := a.db.FindUser(principal.UserID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "unauthorized access", http.StatusUnauthorized)
+			return
+		}
+		a.logger.Error(fmt.Sprintf("err when finding user at /userinfo: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	res := UserInfoResponse{Sub: principal.UserID}
+	if slices.Contains(principal.Scopes, "phone") {
+		res.PhoneNumber = e164Phone(user.Phone)
+		res.PhoneNumberVerified = true
+	}
+	a.writeJSON(w, http.StatusOK, res)
+}
+
+// @Summery		Register an OpenID Connect client
+// @Description	Creates a client. The secret is returned only in this response. Only admins are allowed.
+// @Tags			oidc
+// @Accept			json
+// @Produce		json
+// @Security		BearerAuth
+// @Param			request	body		ClientRequest	true	"client details"
+// @Success		201		{object}	ClientResponse
+// @Failure		400		{string}	string	"invalid request"
+// @Router			/clients [post]
+func (a *Application) CreateClientHandler(w http.ResponseWriter, r *http.Request) {
+	var req ClientRequest
+	reqDecoder := json.NewDecoder(r.Body)
+	reqDecoder.DisallowUnknownFields() // for strict validation
+	if err := reqDecoder.Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Name) == 0 || len(req.RedirectURIs) == 0 {
+		http.Error(w, "name and redirect_uris are required", http.StatusBadRequest)
+		return
+	}
+	for _, uri := range req.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			http.Error(w, fmt.Sprintf("invalid redirect uri %q", uri), http.StatusBadRequest)
+			return
+		}
+	}
+
+	clientID, err := randomString(16)
+	if err != nil {
+		a.logger.Error(err.Error())
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	res := ClientResponse{
+		Client: entity.Client{
+			ID:           clientID,
+			Name:         req.Name,
+			RedirectURIs: req.RedirectURIs,
+			CreatedAt:    time.Now(),
+		},
+	}
+	if !req.Public {
+		res.ClientSecret, err = randomString(32)
+		if err != nil {
+			a.logger.Error(err.Error())
+			http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+			return
+		}
+		res.SecretHash = hashClientSecret(res.ClientSecret)
+	}
+	if err := a.db.SaveClient(&res.Client); err != nil {
+		a.logger.Error(fmt.Sprintf("err when saving client: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	a.writeJSON(w, http.StatusCreated, res)
+}
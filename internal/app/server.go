@@ -15,6 +15,7 @@ import (
 	_ "github.com/aph138/dekamond/docs"
 	"github.com/aph138/dekamond/internal/cache"
 	"github.com/aph138/dekamond/internal/db"
+	"github.com/aph138/dekamond/internal/entity"
 	"github.com/aph138/dekamond/pkg/authentication"
 	httpSwagger "github.com/swaggo/http-swagger"
 )
@@ -24,6 +25,63 @@ type Application struct {
 	jwt    *authentication.JWT
 	cache  cache.Cache
 	db     db.Database
+
+	// client ID to client secret, used for authenticating /introspect callers
+	introspectionClients map[string]string
+	// tokenAudiences are the audiences which can be requested at /check
+	tokenAudiences []string
+	// oidc is nil when OpenID Connect provider mode is disabled
+	oidc *OpenIDConfig
+
+	// confirmOldPhone requires an OTP code from the old number when changing phone number
+	confirmOldPhone bool
+	// phoneHoldPeriod is the time which an old phone number can't be registered after a change
+	phoneHoldPeriod time.Duration
+	// loginEventRetention is the time which login events are kept
+	loginEventRetention time.Duration
+	// statsCacheTTL is the time which user statistics are cached, zero disables caching
+	statsCacheTTL time.Duration
+}
+
+type ApplicationOption func(*Application)
+
+// WithIntrospectionClients sets the clients which are allowed to call /introspect.
+// The map key is client ID and the value is client secret.
+func WithIntrospectionClients(clients map[string]string) ApplicationOption {
+	return func(a *Application) {
+		a.introspectionClients = clients
+	}
+}
+
+// WithTokenAudiences sets the audiences which can be requested at /check.
+// Tokens for any other audience are rejected.
+func WithTokenAudiences(audiences ...string) ApplicationOption {
+	return func(a *Application) {
+		a.tokenAudiences = audiences
+	}
+}
+
+// WithPhoneChange configures changing phone number.
+// Default is confirming with the old number and a 30 days hold period.
+func WithPhoneChange(confirmOld bool, holdPeriod time.Duration) ApplicationOption {
+	return func(a *Application) {
+		a.confirmOldPhone = confirmOld
+		a.phoneHoldPeriod = holdPeriod
+	}
+}
+
+// WithLoginEventRetention sets the time which login events are kept. Default is 90 days.
+func WithLoginEventRetention(retention time.Duration) ApplicationOption {
+	return func(a *Application) {
+		a.loginEventRetention = retention
+	}
+}
+
+// WithStatsCacheTTL sets the time which user statistics are cached. Default is 5 minutes and zero disables caching.
+func WithStatsCacheTTL(ttl time.Duration) ApplicationOption {
+	return func(a *Application) {
+		a.statsCacheTTL = ttl
+	}
 }
 
 func NewApplication(
@@ -31,28 +89,78 @@ func NewApplication(
 	jwt *authentication.JWT,
 	cache cache.Cache,
 	db db.Database,
+	opts ...ApplicationOption,
 ) *Application {
-	return &Application{
+	a := &Application{
 		logger: logger,
 		jwt:    jwt,
 		cache:  cache,
 		db:     db,
+
+		confirmOldPhone: true,
+		phoneHoldPeriod: time.Hour * 24 * 30,
+
+		loginEventRetention: time.Hour * 24 * 90,
+		statsCacheTTL:       time.Minute * 5,
+	}
+	for _, opt := range opts {
+		opt(a)
 	}
+	return a
 }
 
-func (a *Application) Run(port int) {
+// withRole protects the handler by AuthMiddleware and RequireRole
+func (a *Application) withRole(handler http.HandlerFunc, roles ...string) http.Handler {
+	return a.AuthMiddleware(a.RequireRole(handler, roles...))
+}
 
+// Routes registers all of the endpoints and returns the root handler
+func (a *Application) Routes() http.Handler {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("POST /login", a.LoginHandler)
 	mux.HandleFunc("POST /check", a.CheckHandler)
-	mux.HandleFunc("GET /search", a.SearchUserHandler)
+	mux.Handle("POST /logout", a.AuthMiddleware(http.HandlerFunc(a.LogoutHandler)))
+	mux.HandleFunc("POST /introspect", a.IntrospectHandler)
+	mux.Handle("GET /me", a.AuthMiddleware(http.HandlerFunc(a.GetProfileHandler)))
+	mux.Handle("PATCH /me", a.AuthMiddleware(http.HandlerFunc(a.UpdateProfileHandler)))
+	mux.Handle("DELETE /me", a.AuthMiddleware(http.HandlerFunc(a.DeleteAccountHandler)))
+	mux.Handle("GET /me/export", a.AuthMiddleware(http.HandlerFunc(a.ExportHandler)))
+	mux.Handle("POST /me/phone", a.AuthMiddleware(http.HandlerFunc(a.ChangePhoneHandler)))
+	mux.Handle("POST /me/phone/verify", a.AuthMiddleware(http.HandlerFunc(a.VerifyPhoneChangeHandler)))
+	mux.Handle("GET /me/sessions", a.AuthMiddleware(http.HandlerFunc(a.ListSessionsHandler)))
+	mux.Handle("DELETE /me/sessions/{id}", a.AuthMiddleware(http.HandlerFunc(a.DeleteSessionHandler)))
+	mux.Handle("GET /search", a.withRole(a.SearchUserHandler, entity.RoleSupport, entity.RoleAdmin))
+	mux.Handle("GET /stats/users", a.withRole(a.UserStatsHandler, entity.RoleAdmin))
+	mux.Handle("POST /users/import", a.withRole(a.ImportUsersHandler, entity.RoleAdmin))
+	mux.Handle("GET /users/export", a.withRole(a.ExportUsersHandler, entity.RoleAdmin))
+	mux.Handle("GET /users/{id}", a.withRole(a.GetUserHandler, entity.RoleAdmin))
+	mux.Handle("PATCH /users/{id}", a.withRole(a.UpdateUserHandler, entity.RoleAdmin))
+	mux.Handle("DELETE /users/{id}", a.withRole(a.DeleteUserHandler, entity.RoleAdmin))
+	mux.Handle("GET /users/{id}/events", a.withRole(a.ListLoginEventsHandler, entity.RoleSupport, entity.RoleAdmin))
+	mux.Handle("PUT /users/{id}/status", a.withRole(a.SetStatusHandler, entity.RoleAdmin))
+	mux.Handle("PUT /users/{id}/roles/{role}", a.withRole(a.GrantRoleHandler, entity.RoleAdmin))
+	mux.Handle("DELETE /users/{id}/roles/{role}", a.withRole(a.RevokeRoleHandler, entity.RoleAdmin))
+	if a.oidc != nil {
+		mux.HandleFunc("GET /.well-known/openid-configuration", a.DiscoveryHandler)
+		mux.HandleFunc("GET /.well-known/jwks.json", a.JWKSHandler)
+		mux.HandleFunc("GET /authorize", a.AuthorizeHandler)
+		mux.HandleFunc("POST /token", a.TokenHandler)
+		// access tokens of clients can only be used here
+		mux.Handle("GET /userinfo", a.authenticate(http.HandlerFunc(a.UserInfoHandler), "openid"))
+		mux.Handle("POST /userinfo", a.authenticate(http.HandlerFunc(a.UserInfoHandler), "openid"))
+		mux.Handle("POST /clients", a.withRole(a.CreateClientHandler, entity.RoleAdmin))
+	}
 	mux.Handle("/swagger/", httpSwagger.WrapHandler)
+	return mux
+}
+
+func (a *Application) Run(port int) {
 
 	// at the production level, it's better to specify timeouts explicitly
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", port),
-		Handler: mux,
+		Handler: a.Routes(),
 	}
 
 	go func() {
@@ -0,0 +1,128 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/aph138/dekamond/internal/cache"
+	"github.com/aph138/dekamond/internal/db"
+)
+
+const statsKeyPrefix = "stats:users:"
+
+type UserStatsResponse struct {
+	Code     int                  `json:"code"`
+	Interval string               `json:"interval"`
+	TimeZone string               `json:"tz"`
+	Result   []db.UserStatsBucket `json:"result"`
+}
+
+// defaultStatsFrom returns the start of the range when from isn't set,
+// which is 30 days, 12 weeks or 12 months including today
+func defaultStatsFrom(interval string, today time.Time) time.Time {
+	year, month, day := today.Date()
+	switch interval {
+	case db.IntervalMonth:
+		return time.Date(year, month-11, 1, 0, 0, 0, 0, today.Location())
+	case db.IntervalWeek:
+		return time.Date(year, month, day-7*11, 0, 0, 0, 0, today.Location())
+	default:
+		return time.Date(year, month, day-29, 0, 0, 0, 0, today.Location())
+	}
+}
+
+// @Summery		User statistics
+// @Description	Counts new registrations and active users in every day, week or month of the range. Active users are counted in the interval of their last login.
+// @Description	Intervals start at midnight in tz and weeks start on Monday. Results are cached for a while, so the latest interval may be behind. Only admins are allowed.
+// @Tags			stats
+// @Produce		json
+// @Security		BearerAuth
+// @Param			interval	query		string	false	"One of day, week and month. Default is day."
+// @Param			from		query		string	false	"A date in YYYY-MM-DD format or an RFC 3339 datetime. Default is 30 days, 12 weeks or 12 months ago."	example(2025-01-01)
+// @Param			to			query		string	false	"A date in YYYY-MM-DD format, which includes the whole day, or an RFC 3339 datetime. Default is today."	example(2025-06-30)
+// @Param			tz			query		string	false	"The IANA time zone of dates and intervals. Default is UTC."																		example(Asia/Tehran)
+// @Success		200			{object}	UserStatsResponse
+// @Failure		400			{string}	string	"invalid query"
+// @Failure		401			{string}	string	"unauthorized access"
+// @Failure		403			{string}	string	"forbidden"
+// @Router			/stats/users [get]
+func (a *Application) UserStatsHandler(w http.ResponseWriter, r *http.Request) {
+	intervalQuery := r.URL.Query().Get("interval")
+	fromQuery := r.URL.Query().Get("from")
+	toQuery := r.URL.Query().Get("to")
+	tzQuery := r.URL.Query().Get("tz")
+
+	interval := db.IntervalDay
+	if len(intervalQuery) > 0 {
+		if !slices.Contains(db.Intervals, intervalQuery) {
+			http.Error(w, "invalid value for interval", http.StatusBadRequest)
+			return
+		}
+		interval = intervalQuery
+	}
+	location := time.UTC
+	if len(tzQuery) > 0 {
+		var err error
+		location, err = time.LoadLocation(tzQuery)
+		if err != nil {
+			http.Error(w, "invalid value for tz", http.StatusBadRequest)
+			return
+		}
+	}
+	// default bounds are whole days, so requests of the same day share the cache
+	today := time.Now().In(location).Format("2006-01-02")
+	if len(toQuery) == 0 {
+		toQuery = today
+	}
+	to, err := parseTimeBound(toQuery, location, true)
+	if err != nil {
+		http.Error(w, "invalid value for to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	from := new(time.Time)
+	if len(fromQuery) > 0 {
+		from, err = parseTimeBound(fromQuery, location, false)
+		if err != nil {
+			http.Error(w, "invalid value for from: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		*from = defaultStatsFrom(interval, *to)
+	}
+
+	res := UserStatsResponse{Code: http.StatusOK, Interval: interval, TimeZone: location.String()}
+	key := fmt.Sprintf("%s%s:%s:%d:%d", statsKeyPrefix, interval, location, from.UnixNano(), to.UnixNano())
+	if a.statsCacheTTL > 0 {
+		data, err := a.cache.GetValue(key)
+		if err == nil && json.Unmarshal(data, &res.Result) == nil {
+			a.writeJSON(w, http.StatusOK, res)
+			return
+		}
+		if err != nil && !errors.Is(err, cache.ErrNotFound) {
+			a.logger.Error(fmt.Sprintf("err when reading cached user stats: %s", err.Error()))
+		}
+	}
+	res.Result, err = a.db.UserStats(interval, *from, *to, location)
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidStatsRange) {
+			msg := fmt.Sprintf("from must be before to and the range must have at most %d intervals", db.MaxStatsBuckets)
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		a.logger.Error(fmt.Sprintf("err when counting user stats: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	if a.statsCacheTTL > 0 {
+		data, _ := json.Marshal(res.Result)
+		// stats are served from the database if they can't be cached
+		if err := a.cache.SetValue(key, data, a.statsCacheTTL); err != nil {
+			a.logger.Error(fmt.Sprintf("err when caching user stats: %s", err.Error()))
+		}
+	}
+	a.writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,386 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/aph138/dekamond/internal/cache"
+	"github.com/aph138/dekamond/internal/db"
+	"github.com/aph138/dekamond/internal/entity"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// @Summery		Grant a role
+// @Description	Grants a role to a user. The role is put in tokens which are issued afterwards. Only admins are allowed.
+// @Tags			user
+// @Produce		json
+// @Security		BearerAuth
+// @Param			id		path		string	true	"user ID"
+// @Param			role	path		string	true	"one of user, support and admin"
+// @Success		200		{object}	UserResponse
+// @Failure		400		{string}	string	"invalid role"
+// @Failure		404		{string}	string	"user not found"
+// @Router			/users/{id}/roles/{role} [put]
+func (a *Application) GrantRoleHandler(w http.ResponseWriter, r *http.Request) {
+	role := r.PathValue("role")
+	if !slices.Contains(entity.Roles, role) {
+		http.Error(w, "invalid role", http.StatusBadRequest)
+		return
+	}
+	userID := r.PathValue("id")
+	if _, ok := a.targetUser(w, userID); !ok {
+		return
+	}
+	if !a.auditUserAction(w, r, entity.AuditRoleGrant, userID, map[string]string{"role": role}) {
+		return
+	}
+	user, err := a.db.AddUserRole(userID, role)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		a.logger.Error(fmt.Sprintf("err when granting role: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, UserResponse{Code: http.StatusOK, Result: *user})
+}
+
+// @Summery		Revoke a role
+// @Description	Revokes a role from a user and signs out all of the user's sessions, so tokens with the old roles are rejected. Admins can't revoke their own admin role. Only admins are allowed.
+// @Tags			user
+// @Produce		json
+// @Security		BearerAuth
+// @Param			id		path		string	true	"user ID"
+// @Param			role	path		string	true	"one of user, support and admin"
+// @Success		200		{object}	UserResponse
+// @Failure		400		{string}	string	"invalid role"
+// @Failure		404		{string}	string	"user not found"
+// @Router			/users/{id}/roles/{role} [delete]
+func (a *Application) RevokeRoleHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFromContext(r.Context())
+	userID := r.PathValue("id")
+	role := r.PathValue("role")
+	if !slices.Contains(entity.Roles, role) {
+		http.Error(w, "invalid role", http.StatusBadRequest)
+		return
+	}
+	// admins can't revoke their own admin role, other admins can
+	if userID == principal.UserID && role == entity.RoleAdmin {
+		http.Error(w, "you can't revoke your own admin role", http.StatusBadRequest)
+		return
+	}
+	if _, ok := a.targetUser(w, userID); !ok {
+		return
+	}
+	if !a.auditUserAction(w, r, entity.AuditRoleRevoke, userID, map[string]string{"role": role}) {
+		return
+	}
+	user, err := a.db.RemoveUserRole(userID, role)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		a.logger.Error(fmt.Sprintf("err when revoking role: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	if err := a.db.DeleteUserSessions(userID); err != nil {
+		a.logger.Error(fmt.Sprintf("err when deleting sessions after revoking role: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, UserResponse{Code: http.StatusOK, Result: *user})
+}
+
+type SetStatusRequest struct {
+	Status string `json:"status" example:"suspended"`
+}
+
+// @Summery		Change account status
+// @Description	Changes the account status of a user. Suspended, banned and deleted users can't log in and their tokens are rejected. Admins can't change their own status. Only admins are allowed.
+// @Tags			user
+// @Accept			json
+// @Produce		json
+// @Security		BearerAuth
+// @Param			id		path		string				true	"user ID"
+// @Param			request	body		SetStatusRequest	true	"one of active, suspended, banned and deleted"
+// @Success		200		{object}	UserResponse
+// @Failure		400		{string}	string	"invalid status"
+// @Failure		404		{string}	string	"user not found"
+// @Router			/users/{id}/status [put]
+func (a *Application) SetStatusHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFromContext(r.Context())
+	userID := r.PathValue("id")
+	var req SetStatusRequest
+	reqDecoder := json.NewDecoder(r.Body)
+	reqDecoder.DisallowUnknownFields() // for strict validation
+	if err := reqDecoder.Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !slices.Contains(entity.Statuses, req.Status) {
+		http.Error(w, "invalid status", http.StatusBadRequest)
+		return
+	}
+	if userID == principal.UserID {
+		http.Error(w, "you can't change your own status", http.StatusBadRequest)
+		return
+	}
+	if _, ok := a.targetUser(w, userID); !ok {
+		return
+	}
+	if !a.auditUserAction(w, r, entity.AuditUserStatus, userID, map[string]string{"status": req.Status}) {
+		return
+	}
+	user, err := a.db.SetUserStatus(userID, req.Status)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		a.logger.Error(fmt.Sprintf("err when changing status: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, UserResponse{Code: http.StatusOK, Result: *user})
+}
+
+// UpdateUserRequest holds the fields of a user to change.
+// Missing fields are left unchanged and empty strings remove the profile field.
+type UpdateUserRequest struct {
+	UpdateProfileRequest
+	// Phone is changed without an OTP code, it can't be removed
+	Phone *string `json:"phone,omitempty" example:"09012345678"`
+}
+
+// userIDParam returns the id path value. It responds with 400 and returns false if it isn't a valid ID.
+func userIDParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID := r.PathValue("id")
+	if _, err := bson.ObjectIDFromHex(userID); err != nil {
+		http.Error(w, "invalid user ID", http.StatusBadRequest)
+		return "", false
+	}
+	return userID, true
+}
+
+// targetUser returns the user which an admin acts on. It responds with 404 or 500 and returns false if it isn't found.
+func (a *Application) targetUser(w http.ResponseWriter, userID string) (*entity.User, bool) {
+	user, err := a.db.FindUser(userID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return nil, false
+		}
+		a.logger.Error(fmt.Sprintf("err when finding user at /users/{id}: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return nil, false
+	}
+	return user, true
+}
+
+// auditUserAction records an action on a user before it's done, so nothing is changed without a record.
+// It responds with 500 and returns false if the record can't be saved.
+// An action which fails afterwards keeps its record.
+func (a *Application) auditUserAction(w http.ResponseWriter, r *http.Request, action, userID string, details map[string]string) bool {
+	if err := a.recordAudit(r, action, userID, details); err != nil {
+		a.logger.Error(fmt.Sprintf("err when auditing %s: %s", action, err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// @Summery		Get a user
+// @Description	Returns a user by ID. Only admins are allowed.
+// @Tags			user
+// @Produce		json
+// @Security		BearerAuth
+// @Param			id		path		string	true	"user ID"
+// @Param			fields	query		string	false	"Comma separated fields to return, same as /search"
+// @Success		200		{object}	UserResponse
+// @Failure		400		{string}	string	"invalid user ID"
+// @Failure		404		{string}	string	"user not found"
+// @Router			/users/{id} [get]
+func (a *Application) GetUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+	view, ok := userViewOf(w, r)
+	if !ok {
+		return
+	}
+	user, err := a.db.FindUser(userID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		a.logger.Error(fmt.Sprintf("err when finding user at /users/{id}: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, UserResponse{Code: http.StatusOK, Result: view.shape(*user)})
+}
+
+// @Summery		Update a user
+// @Description	Changes the profile fields and the phone number of a user which are sent. Send an empty string to remove a profile field. Same as a change by the user, every session of the user is signed out and the old phone number can't be registered by anyone else during the hold period. The change is recorded in the audit log. Only admins are allowed.
+// @Tags			user
+// @Accept			json
+// @Produce		json
+// @Security		BearerAuth
+// @Param			id		path		string				true	"user ID"
+// @Param			request	body		UpdateUserRequest	true	"fields to change"
+// @Success		200		{object}	UserResponse
+// @Failure		400		{string}	string	"invalid field"
+// @Failure		404		{string}	string	"user not found"
+// @Failure		409		{string}	string	"phone number is not available"
+// @Router			/users/{id} [patch]
+func (a *Application) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+	var req UpdateUserRequest
+	reqDecoder := json.NewDecoder(r.Body)
+	reqDecoder.DisallowUnknownFields() // for strict validation
+	if err := reqDecoder.Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Phone != nil && !phoneRegex.MatchString(*req.Phone) {
+		http.Error(w, "invalid phone number", http.StatusBadRequest)
+		return
+	}
+	old, ok := a.targetUser(w, userID)
+	if !ok {
+		return
+	}
+	if req.Phone != nil && *req.Phone != old.Phone {
+		holder, err := a.phoneHolder(*req.Phone)
+		if err != nil {
+			a.logger.Error(fmt.Sprintf("err when checking phone hold: %s", err.Error()))
+			http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+			return
+		}
+		if len(holder) > 0 && holder != userID {
+			http.Error(w, "phone number is not available", http.StatusConflict)
+			return
+		}
+		// a taken number is rejected before the change is audited
+		taken, err := a.db.SearchUser(db.SearchUserByPhone(*req.Phone), db.SearchUserByPagination(1, 1))
+		if err != nil {
+			a.logger.Error(fmt.Sprintf("err when finding user by phone at /users/{id}: %s", err.Error()))
+			http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+			return
+		}
+		if len(taken.Users) > 0 {
+			http.Error(w, "phone number is not available", http.StatusConflict)
+			return
+		}
+	}
+	// the old and new values of every changed field, the request is already normalized
+	details := map[string]string{}
+	for _, f := range []struct {
+		name, before string
+		after        *string
+	}{
+		{"display_name", old.DisplayName, req.DisplayName},
+		{"email", old.Email, req.Email},
+		{"locale", old.Locale, req.Locale},
+		{"avatar_url", old.AvatarURL, req.AvatarURL},
+		{"phone", old.Phone, req.Phone},
+	} {
+		if f.after != nil && f.before != *f.after {
+			details["old_"+f.name] = f.before
+			details[f.name] = *f.after
+		}
+	}
+	if len(details) > 0 && !a.auditUserAction(w, r, entity.AuditUserUpdate, userID, details) {
+		return
+	}
+	user, err := a.db.UpdateUser(userID, db.UserUpdate{
+		DisplayName: req.DisplayName,
+		Email:       req.Email,
+		Locale:      req.Locale,
+		AvatarURL:   req.AvatarURL,
+		Phone:       req.Phone,
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, db.ErrDuplicate) {
+			http.Error(w, "phone number is not available", http.StatusConflict)
+			return
+		}
+		a.logger.Error(fmt.Sprintf("err when updating user at /users/{id}: %s", err.Error()))
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	if user.Phone != old.Phone {
+		// same as a change by the user, the old number is held, a pending change is dropped
+		// and every session is signed out
+		if a.phoneHoldPeriod > 0 {
+			if err := a.cache.SetValue(phoneHoldKeyPrefix+old.Phone, []byte(userID), a.phoneHoldPeriod); err != nil {
+				a.logger.Error(fmt.Sprintf("err when holding old phone number: %s", err.Error()))
+			}
+		}
+		if _, err := a.cache.TakeValue(phoneChangeKeyPrefix + userID); err != nil && !errors.Is(err, cache.ErrNotFound) {
+			a.logger.Error(fmt.Sprintf("err when removing pending phone change: %s", err.Error()))
+		}
+		// tokens are issued for the old number, so every device must log in again
+		if err := a.db.DeleteUserSessions(userID); err != nil {
+			a.logger.Error(fmt.Sprintf("err when deleting sessions after phone change: %s", err.Error()))
+			http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+			return
+		}
+	}
+	a.writeJSON(w, http.StatusOK, UserResponse{Code: http.StatusOK, Result: *user})
+}
+
+// @Summery		Delete a user
+// @Description	Deletes a user with its sessions and login events, so the user is signed out everywhere. Admins can't delete themselves. The deletion is recorded in the audit log. Only admins are allowed.
+// @Tags			user
+// @Security		BearerAuth
+// @Param			id	path	string	true	"user ID"
+// @Success		204	"No Content"
+// @Failure		400	{string}	string	"invalid user ID"
+// @Failure		404	{string}	string	"user not found"
+// @Router			/users/{id} [delete]
+func (a *Application) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFromContext(r.Context())
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+	// admins can't delete themselves, other admins can
+	if userID == principal.UserID {
+		http.Error(w, "you can't delete yourself", http.StatusBadRequest)
+		return
+	}
+	user, ok := a.targetUser(w, userID)
+	if !ok {
+		return
+	}
+	if !a.auditUserAction(w, r, entity.AuditUserDelete, userID, map[string]string{"phone": user.Phone}) {
+		return
+	}
+	if err := a.removeUser(user); err != nil {
+		a.logger.Error(err.Error())
+		http.Error(w, "something went wrong, try again later", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -3,11 +3,53 @@ package cache
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 var ErrOTPStillValid = errors.New("a valid OTP still exists")
 var ErrInvalidCode = errors.New("invalid OTP code")
 var ErrRateLimit = errors.New("rate limit exceeded")
+var ErrNotFound = errors.New("value not found")
+
+// purposes of OTP codes, a code which is generated for one purpose can't be used for another
+const (
+	PurposeLogin         = "login"
+	PurposePhoneChange   = "phone_change"
+	PurposeDeleteAccount = "delete_account"
+)
+
+// settings holds the limits of OTP codes
+type settings struct {
+	otpTTL            time.Duration
+	rateLimitAttempts int
+	rateLimitWindow   time.Duration
+}
+
+// defaultSettings are 2 minutes for OTP codes and 3 verification attempts per 10 minutes
+func defaultSettings() settings {
+	return settings{
+		otpTTL:            time.Minute * 2,
+		rateLimitAttempts: 3,
+		rateLimitWindow:   time.Minute * 10,
+	}
+}
+
+type Option func(*settings)
+
+// WithOTPTTL sets the time which an OTP code is valid
+func WithOTPTTL(ttl time.Duration) Option {
+	return func(s *settings) {
+		s.otpTTL = ttl
+	}
+}
+
+// WithRateLimit sets the number of OTP verification attempts which a phone number has in the window
+func WithRateLimit(attempts int, window time.Duration) Option {
+	return func(s *settings) {
+		s.rateLimitAttempts = attempts
+		s.rateLimitWindow = window
+	}
+}
 
 type Cache interface {
 	// Close closes all connections and releases resources, if any exists.
@@ -23,4 +65,32 @@ type Cache interface {
 	// It returns ErrRateLimit with must to wait time if user exceeds rate limit.
 	// It returns ErrInvalidCode if the code doesn't exist or is wrong.
 	VerifyOTPCode(string, string) error
+
+	// NewOTPCodeFor is like NewOTPCode but the code is generated for the purpose which is the first argument.
+	NewOTPCodeFor(string, string) (string, error)
+
+	// VerifyOTPCodeFor is like VerifyOTPCode but only accepts a code of the purpose which is the first argument.
+	VerifyOTPCodeFor(string, string, string) error
+
+	// ClearPhone removes every OTP code and rate limit state of the phone number
+	ClearPhone(string) error
+
+	// RevokeToken marks a token ID (jti) as revoked.
+	// The mark is kept for the given duration which should be the token's remaining lifetime.
+	RevokeToken(string, time.Duration) error
+
+	// IsTokenRevoked reports whether a token ID (jti) has been revoked.
+	IsTokenRevoked(string) (bool, error)
+
+	// SetValue stores an arbitrary value under the key for the given duration.
+	SetValue(string, []byte, time.Duration) error
+
+	// GetValue returns the value stored under the key.
+	// It returns ErrNotFound if the key doesn't exist or is expired.
+	GetValue(string) ([]byte, error)
+
+	// TakeValue returns the value stored under the key and removes it atomically,
+	// so a value can be taken only once.
+	// It returns ErrNotFound if the key doesn't exist or is expired.
+	TakeValue(string) ([]byte, error)
 }
@@ -0,0 +1,169 @@
+// Package cachetest provides a conformance suite which every cache.Cache implementation must pass
+package cachetest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aph138/dekamond/internal/cache"
+)
+
+// Factory returns an empty cache with the options.
+// The cache should be closed by t.Cleanup.
+type Factory func(t *testing.T, opts ...cache.Option) cache.Cache
+
+const phone = "09000000001"
+
+// RunCacheSuite runs every test of the suite against the caches which factory returns
+func RunCacheSuite(t *testing.T, factory Factory) {
+	t.Run("OTPSingleUse", func(t *testing.T) { testOTPSingleUse(t, factory(t)) })
+	t.Run("OTPPurpose", func(t *testing.T) { testOTPPurpose(t, factory(t)) })
+	t.Run("OTPExpiry", func(t *testing.T) { testOTPExpiry(t, factory(t, cache.WithOTPTTL(time.Second))) })
+	t.Run("RateLimit", func(t *testing.T) { testRateLimit(t, factory(t, cache.WithRateLimit(3, time.Second*2))) })
+	t.Run("ClearPhone", func(t *testing.T) { testClearPhone(t, factory(t)) })
+	t.Run("RevokeToken", func(t *testing.T) { testRevokeToken(t, factory(t)) })
+	t.Run("Values", func(t *testing.T) { testValues(t, factory(t)) })
+}
+
+func newCode(t *testing.T, c cache.Cache, purpose string) string {
+	t.Helper()
+	code, err := c.NewOTPCodeFor(purpose, phone)
+	if err != nil {
+		t.Fatalf("err when generating otp code %s", err.Error())
+	}
+	if len(code) != 6 {
+		t.Fatalf("expected 6 digits code but got %s", code)
+	}
+	return code
+}
+
+func testOTPSingleUse(t *testing.T, c cache.Cache) {
+	code := newCode(t, c, cache.PurposeLogin)
+	if _, err := c.NewOTPCode(phone); !errors.Is(err, cache.ErrOTPStillValid) {
+		t.Fatalf("expected ErrOTPStillValid but got %v", err)
+	}
+	if err := c.VerifyOTPCode(phone, "wrong"); !errors.Is(err, cache.ErrInvalidCode) {
+		t.Fatalf("expected ErrInvalidCode but got %v", err)
+	}
+	if err := c.VerifyOTPCode(phone, code); err != nil {
+		t.Fatalf("expected valid code but got %v", err)
+	}
+	if err := c.VerifyOTPCode(phone, code); !errors.Is(err, cache.ErrInvalidCode) {
+		t.Fatalf("expected ErrInvalidCode for used code but got %v", err)
+	}
+	// a new code can be generated after the code is used
+	newCode(t, c, cache.PurposeLogin)
+}
+
+func testOTPPurpose(t *testing.T, c cache.Cache) {
+	code := newCode(t, c, cache.PurposePhoneChange)
+	// codes of different purposes don't block each other
+	newCode(t, c, cache.PurposeLogin)
+	if err := c.VerifyOTPCodeFor(cache.PurposeDeleteAccount, phone, code); !errors.Is(err, cache.ErrInvalidCode) {
+		t.Fatalf("expected ErrInvalidCode for another purpose but got %v", err)
+	}
+	if err := c.VerifyOTPCodeFor(cache.PurposePhoneChange, phone, code); err != nil {
+		t.Fatalf("expected valid code but got %v", err)
+	}
+}
+
+func testOTPExpiry(t *testing.T, c cache.Cache) {
+	code := newCode(t, c, cache.PurposeLogin)
+	time.Sleep(time.Millisecond * 1200)
+	if err := c.VerifyOTPCode(phone, code); !errors.Is(err, cache.ErrInvalidCode) {
+		t.Fatalf("expected ErrInvalidCode for expired code but got %v", err)
+	}
+	newCode(t, c, cache.PurposeLogin)
+}
+
+func testRateLimit(t *testing.T, c cache.Cache) {
+	code := newCode(t, c, cache.PurposeLogin)
+	// attempts in the same second are counted separately
+	for range 3 {
+		if err := c.VerifyOTPCode(phone, "wrong"); !errors.Is(err, cache.ErrInvalidCode) {
+			t.Fatalf("expected ErrInvalidCode but got %v", err)
+		}
+	}
+	if err := c.VerifyOTPCode(phone, code); !errors.Is(err, cache.ErrRateLimit) {
+		t.Fatalf("expected ErrRateLimit but got %v", err)
+	}
+	// attempts of other numbers are not limited
+	otherPhone := "09000000002"
+	if err := c.VerifyOTPCode(otherPhone, "wrong"); !errors.Is(err, cache.ErrInvalidCode) {
+		t.Fatalf("expected ErrInvalidCode for another number but got %v", err)
+	}
+	// the limit is reset after the window
+	time.Sleep(time.Millisecond * 2200)
+	if err := c.VerifyOTPCode(phone, code); err != nil {
+		t.Fatalf("expected valid code after the window but got %v", err)
+	}
+}
+
+func testClearPhone(t *testing.T, c cache.Cache) {
+	newCode(t, c, cache.PurposeLogin)
+	newCode(t, c, cache.PurposeDeleteAccount)
+	for range 3 {
+		c.VerifyOTPCode(phone, "wrong")
+	}
+	if err := c.ClearPhone(phone); err != nil {
+		t.Fatalf("err when clearing phone %s", err.Error())
+	}
+	// both codes and attempts are removed
+	code := newCode(t, c, cache.PurposeLogin)
+	newCode(t, c, cache.PurposeDeleteAccount)
+	if err := c.VerifyOTPCode(phone, code); err != nil {
+		t.Fatalf("expected valid code but got %v", err)
+	}
+}
+
+func testRevokeToken(t *testing.T, c cache.Cache) {
+	if err := c.RevokeToken("token", time.Second); err != nil {
+		t.Fatalf("err when revoking token %s", err.Error())
+	}
+	if err := c.RevokeToken("expired", 0); err != nil {
+		t.Fatalf("err when revoking expired token %s", err.Error())
+	}
+	for id, expected := range map[string]bool{"token": true, "expired": false, "other": false} {
+		revoked, err := c.IsTokenRevoked(id)
+		if err != nil {
+			t.Fatalf("err when checking token %s", err.Error())
+		}
+		if revoked != expected {
+			t.Fatalf("expected revoked to be %v for %s", expected, id)
+		}
+	}
+	// the mark is removed with the token's lifetime
+	time.Sleep(time.Millisecond * 1200)
+	if revoked, _ := c.IsTokenRevoked("token"); revoked {
+		t.Fatal("expected revocation to expire")
+	}
+}
+
+func testValues(t *testing.T, c cache.Cache) {
+	if _, err := c.GetValue("missing"); !errors.Is(err, cache.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound but got %v", err)
+	}
+	if err := c.SetValue("key", []byte("value"), time.Minute); err != nil {
+		t.Fatalf("err when setting value %s", err.Error())
+	}
+	value, err := c.GetValue("key")
+	if err != nil || string(value) != "value" {
+		t.Fatalf("expected value but got %s %v", value, err)
+	}
+	// a value can be taken only once
+	value, err = c.TakeValue("key")
+	if err != nil || string(value) != "value" {
+		t.Fatalf("expected value but got %s %v", value, err)
+	}
+	if _, err := c.TakeValue("key"); !errors.Is(err, cache.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound but got %v", err)
+	}
+	if err := c.SetValue("short", []byte("value"), time.Second); err != nil {
+		t.Fatalf("err when setting value %s", err.Error())
+	}
+	time.Sleep(time.Millisecond * 1200)
+	if _, err := c.GetValue("short"); !errors.Is(err, cache.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for expired value but got %v", err)
+	}
+}
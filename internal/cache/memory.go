@@ -0,0 +1,209 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryItem struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func (i memoryItem) expired(now time.Time) bool {
+	return !i.expiresAt.IsZero() && !now.Before(i.expiresAt)
+}
+
+// Memory implements Cache in the memory of the process.
+// It's meant for a single instance, such as local development together with SQLite,
+// since nothing is shared between instances or kept after restart.
+type Memory struct {
+	mu    sync.Mutex
+	items map[string]memoryItem
+	// attempts holds the times of OTP verification attempts of each phone number
+	attempts  map[string][]time.Time
+	settings  settings
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewMemory creates an in-memory cache which removes expired items every minute until it's closed
+func NewMemory(opts ...Option) *Memory {
+	m := &Memory{
+		items:    map[string]memoryItem{},
+		attempts: map[string][]time.Time{},
+		settings: defaultSettings(),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&m.settings)
+	}
+	go m.cleanup(time.Minute)
+	return m
+}
+
+func (m *Memory) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case now := <-ticker.C:
+			m.mu.Lock()
+			for key, item := range m.items {
+				if item.expired(now) {
+					delete(m.items, key)
+				}
+			}
+			for phone, attempts := range m.attempts {
+				if len(attempts) == 0 || now.Sub(attempts[len(attempts)-1]) > m.settings.rateLimitWindow {
+					delete(m.attempts, phone)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}
+}
+
+// get returns the item of the key if it's not expired, m.mu must be held
+func (m *Memory) get(key string) ([]byte, bool) {
+	item, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+	if item.expired(time.Now()) {
+		delete(m.items, key)
+		return nil, false
+	}
+	return item.value, true
+}
+
+// set stores the value for ttl, zero ttl means no expiration. m.mu must be held.
+func (m *Memory) set(key string, value []byte, ttl time.Duration) {
+	item := memoryItem{value: value}
+	if ttl > 0 {
+		item.expiresAt = time.Now().Add(ttl)
+	}
+	m.items[key] = item
+}
+
+func (m *Memory) NewOTPCode(phone string) (string, error) {
+	return m.NewOTPCodeFor(PurposeLogin, phone)
+}
+
+func (m *Memory) NewOTPCodeFor(purpose, phone string) (string, error) {
+	otpKey := "otp:" + phone + ":" + purpose
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.get(otpKey); ok {
+		return "", ErrOTPStillValid
+	}
+	// generate a 6 digits random number
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", fmt.Errorf("err when generating random OTP %w", err)
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	m.set(otpKey, []byte(code), m.settings.otpTTL)
+	return code, nil
+}
+
+func (m *Memory) VerifyOTPCode(phone, code string) error {
+	return m.VerifyOTPCodeFor(PurposeLogin, phone, code)
+}
+
+func (m *Memory) VerifyOTPCodeFor(purpose, phone, code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// keep only the attempts of the window
+	now := time.Now()
+	attempts := m.attempts[phone]
+	for len(attempts) > 0 && now.Sub(attempts[0]) >= m.settings.rateLimitWindow {
+		attempts = attempts[1:]
+	}
+	if len(attempts) >= m.settings.rateLimitAttempts {
+		m.attempts[phone] = attempts
+		return ErrRateLimit
+	}
+	m.attempts[phone] = append(attempts, now)
+
+	otpKey := "otp:" + phone + ":" + purpose
+	expectedCode, ok := m.get(otpKey)
+	if !ok || string(expectedCode) != code {
+		return ErrInvalidCode
+	}
+	// remove old valid code after successful verification
+	delete(m.items, otpKey)
+	return nil
+}
+
+func (m *Memory) ClearPhone(phone string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.attempts, phone)
+	for key := range m.items {
+		if strings.HasPrefix(key, "otp:"+phone+":") {
+			delete(m.items, key)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) RevokeToken(tokenID string, ttl time.Duration) error {
+	if ttl <= 0 {
+		// the token is already expired
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set("revoked:"+tokenID, nil, ttl)
+	return nil
+}
+
+func (m *Memory) IsTokenRevoked(tokenID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.get("revoked:" + tokenID)
+	return ok, nil
+}
+
+func (m *Memory) SetValue(key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// the caller may change the slice afterwards
+	m.set("kv:"+key, append([]byte(nil), value...), ttl)
+	return nil
+}
+
+func (m *Memory) GetValue(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.get("kv:" + key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (m *Memory) TakeValue(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.get("kv:" + key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(m.items, "kv:"+key)
+	return value, nil
+}
+
+func (m *Memory) Close(ctx context.Context) error {
+	m.closeOnce.Do(func() { close(m.done) })
+	return nil
+}
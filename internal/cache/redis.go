@@ -12,21 +12,31 @@ import (
 
 // MyRedis implement Cache interface
 type MyRedis struct {
-	client *redis.Client
+	client   *redis.Client
+	settings settings
 }
 
-func NewRedis(opts *redis.Options) (*MyRedis, error) {
+func NewRedis(opts *redis.Options, cacheOpts ...Option) (*MyRedis, error) {
 	c := redis.NewClient(opts)
 	if cmd := c.Ping(context.Background()); cmd.Err() != nil {
 		return nil, fmt.Errorf("err when connecting to redis %w", cmd.Err())
 	}
-	return &MyRedis{
-		client: c,
-	}, nil
+	r := &MyRedis{
+		client:   c,
+		settings: defaultSettings(),
+	}
+	for _, opt := range cacheOpts {
+		opt(&r.settings)
+	}
+	return r, nil
 }
 
 func (r *MyRedis) NewOTPCode(phone string) (string, error) {
-	otpKey := "otp:" + phone + ":login"
+	return r.NewOTPCodeFor(PurposeLogin, phone)
+}
+
+func (r *MyRedis) NewOTPCodeFor(purpose, phone string) (string, error) {
+	otpKey := "otp:" + phone + ":" + purpose
 
 	// check if currently a valid code exists and return an error if it does
 	exists, err := r.client.Exists(context.Background(), otpKey).Result()
@@ -43,7 +53,7 @@ func (r *MyRedis) NewOTPCode(phone string) (string, error) {
 	}
 	code := fmt.Sprintf("%06d", n.Int64())
 
-	_, err = r.client.Set(context.Background(), otpKey, code, time.Minute*2).Result()
+	_, err = r.client.Set(context.Background(), otpKey, code, r.settings.otpTTL).Result()
 	if err != nil {
 		return "", fmt.Errorf("err when saving otp code %w", err)
 	}
@@ -52,45 +62,49 @@ func (r *MyRedis) NewOTPCode(phone string) (string, error) {
 }
 
 func (r *MyRedis) VerifyOTPCode(phone string, code string) error {
+	return r.VerifyOTPCodeFor(PurposeLogin, phone, code)
+}
+
+func (r *MyRedis) VerifyOTPCodeFor(purpose, phone, code string) error {
 	// using ZSET (sorted set) for implementing rate limit mechanism.
 	key := "req:" + phone
 
-	// remove any attempts longer than 10 minutes
-	now := time.Now().Unix()
+	// remove any attempts older than the window, scores are in milliseconds
+	now := time.Now()
 	_, err := r.client.ZRemRangeByScore(context.Background(),
 		key,
 		"0",
-		fmt.Sprintf("%d", now-int64(time.Second*60*10)),
+		fmt.Sprintf("%d", now.Add(-r.settings.rateLimitWindow).UnixMilli()),
 	).Result()
 
 	if err != nil {
 		return fmt.Errorf("err when ZRemRangeByScore %w", err)
 	}
 
-	// count all of the attempts in 10 minutes
+	// count all of the attempts in the window
 	count, err := r.client.ZCard(context.Background(), key).Result()
 	if err != nil {
 		return fmt.Errorf("err when ZCard %w", err)
 	}
 
-	// return ErrRateLimit if attempts number is greater than 3
-	if count >= 3 {
+	// return ErrRateLimit if all of the attempts are used
+	if count >= int64(r.settings.rateLimitAttempts) {
 		return ErrRateLimit
 	}
 
-	// add new attempt
-	_, err = r.client.ZAdd(context.Background(), key, redis.Z{Score: float64(now), Member: now}).Result()
+	// add new attempt, members must be unique so attempts in the same second are counted
+	_, err = r.client.ZAdd(context.Background(), key, redis.Z{Score: float64(now.UnixMilli()), Member: now.UnixNano()}).Result()
 	if err != nil {
 		return fmt.Errorf("err when ZAdd %w", err)
 	}
 
 	// set an expire time in order to clean
-	if _, err := r.client.Expire(context.Background(), key, time.Second*60*10).Result(); err != nil {
+	if _, err := r.client.Expire(context.Background(), key, r.settings.rateLimitWindow).Result(); err != nil {
 		return fmt.Errorf("err when Expire %w", err)
 	}
 
 	// get OTP code
-	key = "otp:" + phone + ":login"
+	key = "otp:" + phone + ":" + purpose
 	expectedCode, err := r.client.Get(context.Background(), key).Result()
 
 	// check if there is any code
@@ -110,6 +124,69 @@ func (r *MyRedis) VerifyOTPCode(phone string, code string) error {
 	}
 	return nil
 }
+
+func (r *MyRedis) ClearPhone(phone string) error {
+	keys := []string{"req:" + phone}
+	// codes of every purpose
+	iter := r.client.Scan(context.Background(), 0, "otp:"+phone+":*", 100).Iterator()
+	for iter.Next(context.Background()) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("err when scanning otp codes %w", err)
+	}
+	if _, err := r.client.Del(context.Background(), keys...).Result(); err != nil {
+		return fmt.Errorf("err when deleting otp codes %w", err)
+	}
+	return nil
+}
+
+func (r *MyRedis) RevokeToken(tokenID string, ttl time.Duration) error {
+	if ttl <= 0 {
+		// the token is already expired
+		return nil
+	}
+	if _, err := r.client.Set(context.Background(), "revoked:"+tokenID, 1, ttl).Result(); err != nil {
+		return fmt.Errorf("err when revoking token %w", err)
+	}
+	return nil
+}
+
+func (r *MyRedis) IsTokenRevoked(tokenID string) (bool, error) {
+	exists, err := r.client.Exists(context.Background(), "revoked:"+tokenID).Result()
+	if err != nil {
+		return false, fmt.Errorf("err when checking revoked token %w", err)
+	}
+	return exists > 0, nil
+}
+
+func (r *MyRedis) SetValue(key string, value []byte, ttl time.Duration) error {
+	if _, err := r.client.Set(context.Background(), "kv:"+key, value, ttl).Result(); err != nil {
+		return fmt.Errorf("err when setting value %w", err)
+	}
+	return nil
+}
+
+func (r *MyRedis) GetValue(key string) ([]byte, error) {
+	value, err := r.client.Get(context.Background(), "kv:"+key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("err when getting value %w", err)
+	}
+	return value, nil
+}
+
+func (r *MyRedis) TakeValue(key string) ([]byte, error) {
+	value, err := r.client.GetDel(context.Background(), "kv:"+key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("err when taking value %w", err)
+	}
+	return value, nil
+}
+
 func (r *MyRedis) Close(ctx context.Context) error {
 	return r.client.Close()
 }
@@ -2,39 +2,278 @@ package db
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/aph138/dekamond/internal/entity"
+	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
+var ErrNotFound = errors.New("document not found")
+var ErrDuplicate = errors.New("duplicate document")
+var ErrInvalidCursor = errors.New("invalid cursor")
+var ErrInvalidPhonePattern = errors.New("invalid phone pattern")
+
 type Database interface {
 	// Close will close database
 	Close(context.Context) error
-	// SaveUser gets phone number and return either an error or user ID
-	// If the user already exists, it only returns its ID
-	SaveUser(string) (string, error)
-	// FindUser(string) (*entity.User, error)
-	SearchUser(...SearchUserOption) ([]entity.User, error)
+	// SaveUser gets phone number and return either an error or the user
+	// If the user already exists, it only updates its last login
+	SaveUser(string) (*entity.User, error)
+	// FindUser gets user ID and returns the user.
+	// It returns ErrNotFound if no user exists with that ID.
+	FindUser(string) (*entity.User, error)
+	// UpdateUser gets user ID and changes and returns the updated user.
+	// It returns ErrNotFound if no user exists with that ID and
+	// ErrDuplicate if the phone number of the changes belongs to another user.
+	UpdateUser(string, UserUpdate) (*entity.User, error)
+	// UpdateUserPhone gets user ID and a new phone number and changes the phone of the user.
+	// It returns ErrNotFound if no user exists with that ID and
+	// ErrDuplicate if another user has the phone number.
+	UpdateUserPhone(string, string) (*entity.User, error)
+	// AddUserRole gets user ID and a role and grants the role to the user.
+	// It returns ErrNotFound if no user exists with that ID.
+	AddUserRole(string, string) (*entity.User, error)
+	// RemoveUserRole gets user ID and a role and revokes the role from the user.
+	// It returns ErrNotFound if no user exists with that ID.
+	RemoveUserRole(string, string) (*entity.User, error)
+	// GrantRoleByPhone grants the role to the user with the phone number,
+	// the user is created if it doesn't exist.
+	GrantRoleByPhone(string, string) (*entity.User, error)
+	// SetUserStatus gets user ID and an account status and changes the status of the user.
+	// It returns ErrNotFound if no user exists with that ID.
+	SetUserStatus(string, string) (*entity.User, error)
+	// DeleteUser gets user ID and removes the user.
+	// It returns ErrNotFound if no user exists with that ID.
+	DeleteUser(string) error
+	// ImportUsers creates the users whose phone numbers don't exist yet and leaves the existing users unchanged.
+	// Imported users have never logged in. It returns whether every user is created, in the order of the list.
+	ImportUsers([]UserImport) ([]bool, error)
+	// SearchUser returns the users which match the options, the newest first.
+	// It returns ErrInvalidCursor if the cursor of SearchUserAfter is malformed.
+	SearchUser(...SearchUserOption) (*UserPage, error)
+	// ExportUsers calls the function with every user which matches the filters of the options, in the order of the sort.
+	// Pagination, cursor and total options are ignored. Users are read one at a time or in small batches,
+	// so memory doesn't grow with the number of users. It stops at the first error of the function and returns it.
+	ExportUsers(context.Context, func(entity.User) error, ...SearchUserOption) error
+	// UserStats gets an interval, a time range and a location and counts registrations and active users
+	// in every interval of the range, intervals start at midnight in the location.
+	// It returns ErrInvalidStatsRange if the interval isn't one of Intervals, the range is reversed
+	// or it has more than MaxStatsBuckets intervals.
+	UserStats(string, time.Time, time.Time, *time.Location) ([]UserStatsBucket, error)
+
+	// SaveClient stores a new OpenID Connect client
+	SaveClient(*entity.Client) error
+	// FindClient gets client ID and returns the client.
+	// It returns ErrNotFound if no client exists with that ID.
+	FindClient(string) (*entity.Client, error)
+
+	// CreateSession stores a new session and returns its ID
+	CreateSession(*entity.Session) (string, error)
+	// FindSession gets session ID and returns the session if it's not expired.
+	// It returns ErrNotFound if no session exists with that ID.
+	FindSession(string) (*entity.Session, error)
+	// ListSessions gets user ID and returns all of its sessions which are not expired
+	ListSessions(string) ([]entity.Session, error)
+	// TouchSession gets session ID and user ID and updates last seen time of the session.
+	// It returns ErrNotFound if the session doesn't exist, is expired or belongs to another user.
+	TouchSession(string, string) error
+	// DeleteSession gets session ID and user ID and removes the session.
+	// It returns ErrNotFound if the session doesn't exist or belongs to another user.
+	DeleteSession(string, string) error
+	// DeleteUserSessions gets user ID and removes all of its sessions
+	DeleteUserSessions(string) error
+
+	// SaveLoginEvent stores a login event
+	SaveLoginEvent(*entity.LoginEvent) error
+	// ListLoginEvents gets user ID, phone number, a time and limit and returns the latest events
+	// of the user and the events of the phone number which aren't linked to any user and are created since the time,
+	// so events of a previous owner of the number aren't returned. A limit less than 1 returns every event.
+	ListLoginEvents(string, string, time.Time, int64) ([]entity.LoginEvent, error)
+	// DeleteLoginEvents gets user ID, phone number and a time and removes the events
+	// which ListLoginEvents returns
+	DeleteLoginEvents(string, string, time.Time) error
+
+	// SaveAuditRecord stores an audit record
+	SaveAuditRecord(*entity.AuditRecord) error
+	// ListAuditRecords gets limit and returns the latest audit records, newest first.
+	// A limit less than 1 returns every record.
+	ListAuditRecords(int64) ([]entity.AuditRecord, error)
+}
+
+// UserUpdate holds the changes of a user.
+// Nil fields are left unchanged and empty strings remove the field.
+type UserUpdate struct {
+	DisplayName *string
+	Email       *string
+	Locale      *string
+	AvatarURL   *string
+	// Phone is changed without confirming the new number, it can't be removed
+	Phone *string
+}
+
+// UserImport is a user which is imported from another system
+type UserImport struct {
+	Phone string
+	// RegisteredAt is the time of registration in the other system, the time of the import is used if it's zero
+	RegisteredAt time.Time
+}
+
+// UserPage is a page of search results
+type UserPage struct {
+	Users []entity.User
+	// NextCursor is passed to SearchUserAfter for getting the next page, it's empty on the last page
+	NextCursor string
+	// Total is the number of users which match the filters, it's only set with SearchUserWithTotal
+	Total *int64
 }
 
 type searchUserOption struct {
-	phone        string
-	registerFrom *time.Time
-	registerTO   *time.Time
-	pagination   searchUserPagination
+	phone         string
+	phonePattern  phonePattern
+	registerFrom  *time.Time
+	registerTO    *time.Time
+	lastLoginFrom *time.Time
+	lastLoginTo   *time.Time
+	status        string
+	pagination    searchUserPagination
+	sort          searchUserSort
+	cursor        string
+	total         bool
+	fields        []string
+	filter        FilterExpr
+}
+
+// phonePattern is a partial phone number, which is either a prefix or
+// a number with masked digits
+type phonePattern struct {
+	value  string
+	prefix bool
+	// from and to are the range of numbers which start with the known leading digits,
+	// every matching number is in this range
+	from, to string
 }
 type searchUserPagination struct {
 	page  int64
 	limit int64
 }
+type searchUserSort struct {
+	field string
+	desc  bool
+}
 type SearchUserOption func(*searchUserOption)
 
+// newSearchUserOption applies the options to the default values
+func newSearchUserOption(opts []SearchUserOption) (*searchUserOption, error) {
+	option := &searchUserOption{
+		pagination: searchUserPagination{
+			limit: 10,
+			page:  1,
+		},
+		sort: searchUserSort{
+			field: SortByRegisterTime,
+			desc:  true,
+		},
+	}
+	for _, opt := range opts {
+		opt(option)
+	}
+	// the field is put in queries
+	if !slices.Contains(SortFields, option.sort.field) {
+		return nil, fmt.Errorf("unsupported sort field %q", option.sort.field)
+	}
+	for _, field := range option.fields {
+		// the field is put in projections
+		if !slices.Contains(UserFields, field) {
+			return nil, fmt.Errorf("unsupported user field %q", field)
+		}
+	}
+	if len(option.phonePattern.value) > 0 {
+		pattern, err := newPhonePattern(option.phonePattern.value, option.phonePattern.prefix)
+		if err != nil {
+			return nil, err
+		}
+		option.phonePattern = pattern
+	}
+	if option.filter != nil {
+		if err := checkFilter(option.filter); err != nil {
+			return nil, err
+		}
+	}
+	if len(option.cursor) > 0 {
+		if _, err := decodeUserCursor(option.cursor, option.sort); err != nil {
+			return nil, err
+		}
+	}
+	return option, nil
+}
+
+// ValidateSearchUserOptions returns the error which SearchUser returns for invalid options, such as
+// ErrInvalidPhonePattern and ErrInvalidCursor, without reading any user
+func ValidateSearchUserOptions(opts ...SearchUserOption) error {
+	_, err := newSearchUserOption(opts)
+	return err
+}
+
+// newPhonePattern returns the pattern of a prefix or a masked number with its range.
+// It returns ErrInvalidPhonePattern if the value doesn't start with at least MinPhonePrefix digits.
+func newPhonePattern(value string, prefix bool) (phonePattern, error) {
+	known, _, _ := strings.Cut(value, "*")
+	if !phonePatternRegex.MatchString(value) || len(known) < MinPhonePrefix ||
+		(prefix && len(known) != len(value)) {
+		return phonePattern{}, ErrInvalidPhonePattern
+	}
+	// the next prefix, 9 is followed by : in ASCII, so phone columns must be compared in byte order
+	return phonePattern{
+		value:  value,
+		prefix: prefix,
+		from:   known,
+		to:     known[:len(known)-1] + string(known[len(known)-1]+1),
+	}, nil
+}
+
 func SearchUserByPhone(phone string) SearchUserOption {
 	return func(suo *searchUserOption) {
 		suo.phone = phone
 	}
 }
 
+// MinPhonePrefix is the least number of leading digits which partial phone searches need,
+// so they are narrowed by the phone index instead of reading every user
+const MinPhonePrefix = 7
+
+// MaxSearchLimit is the largest number of users which one page of search results has
+const MaxSearchLimit = 100
+
+// phonePatternRegex matches a partial phone number, digits which are followed by digits and masks
+var phonePatternRegex = regexp.MustCompile(`^[0-9]+[0-9*]*$`)
+
+// SearchUserByPhonePrefix filters users whose phone number starts with the prefix.
+// The prefix must have at least MinPhonePrefix digits, otherwise SearchUser returns ErrInvalidPhonePattern.
+// It's ignored if SearchUserByPhone is used.
+func SearchUserByPhonePrefix(prefix string) SearchUserOption {
+	return func(suo *searchUserOption) {
+		suo.phonePattern = phonePattern{value: prefix, prefix: true}
+	}
+}
+
+// SearchUserByMaskedPhone filters users whose phone number matches the masked number,
+// where every * is an unknown digit, e.g. 0912345**67. The number must start with at least
+// MinPhonePrefix digits, otherwise SearchUser returns ErrInvalidPhonePattern.
+// It's ignored if SearchUserByPhone is used.
+func SearchUserByMaskedPhone(masked string) SearchUserOption {
+	return func(suo *searchUserOption) {
+		suo.phonePattern = phonePattern{value: masked}
+	}
+}
+
+// SearchUserByRegisterTime filters users by register time, both bounds are inclusive.
+// A nil bound leaves the range open on that side.
 func SearchUserByRegisterTime(from, to *time.Time) SearchUserOption {
 	return func(suo *searchUserOption) {
 		suo.registerFrom = from
@@ -42,17 +281,145 @@ func SearchUserByRegisterTime(from, to *time.Time) SearchUserOption {
 	}
 }
 
+// SearchUserByLastLogin filters users by their last login time, both bounds are inclusive.
+// A nil bound leaves the range open on that side. Users who have never logged in don't match.
+func SearchUserByLastLogin(from, to *time.Time) SearchUserOption {
+	return func(suo *searchUserOption) {
+		suo.lastLoginFrom = from
+		suo.lastLoginTo = to
+	}
+}
+
+// SearchUserByStatus filters users by account status
+func SearchUserByStatus(status string) SearchUserOption {
+	return func(suo *searchUserOption) {
+		suo.status = status
+	}
+}
+
 // default values for pagination are: limit=10, page=1.
-// any value less than 1 is treated as 1
+// any value less than 1 is treated as 1 and a limit greater than MaxSearchLimit is treated as MaxSearchLimit
 func SearchUserByPagination(page, limit int64) SearchUserOption {
 	if page < 1 {
 		page = 1
 	}
-	if limit < 1 {
-		limit = 1
-	}
+	limit = min(max(limit, 1), MaxSearchLimit)
 	return func(suo *searchUserOption) {
 		suo.pagination.limit = limit
 		suo.pagination.page = page
 	}
 }
+
+// fields which search results can be sorted by
+const (
+	SortByRegisterTime = "register_at"
+	SortByLastLogin    = "last_login"
+)
+
+// SortFields lists every field which search results can be sorted by
+var SortFields = []string{SortByRegisterTime, SortByLastLogin}
+
+// SearchUserSortBy sorts users by one of SortFields, users with the same value are sorted by ID.
+// Users who have never logged in are the last when sorting by last login in descending order and the first otherwise.
+// The default is newest registered first.
+func SearchUserSortBy(field string, desc bool) SearchUserOption {
+	return func(suo *searchUserOption) {
+		suo.sort = searchUserSort{field: field, desc: desc}
+	}
+}
+
+// SearchUserAfter returns the users after the cursor, which is the NextCursor of the previous page.
+// Unlike pages, cursors don't skip or repeat users when new users register between requests.
+// The page number is ignored when a cursor is set and the cursor must be used with the same sort.
+func SearchUserAfter(cursor string) SearchUserOption {
+	return func(suo *searchUserOption) {
+		suo.cursor = cursor
+	}
+}
+
+// SearchUserWithTotal counts the users which match the filters.
+// Counting reads every matching user, so it should be skipped on very large result sets.
+func SearchUserWithTotal() SearchUserOption {
+	return func(suo *searchUserOption) {
+		suo.total = true
+	}
+}
+
+// UserFields lists the JSON names of every field of users which search results can be projected to
+var UserFields = []string{"id", "phone", "register_at", "last_login", "display_name", "email", "locale", "avatar_url", "roles", "status"}
+
+// SearchUserFields reads only the fields of users, which are names of UserFields.
+// The ID and the sort field are always read for cursors. Databases which read whole rows return
+// every field, so callers must remove the fields which they don't want.
+func SearchUserFields(fields ...string) SearchUserOption {
+	return func(suo *searchUserOption) {
+		suo.fields = fields
+	}
+}
+
+// userCursor is the position of a user in search results,
+// which are sorted by the sort field and ID
+type userCursor struct {
+	sort searchUserSort
+	// value is the sort field of the user, it's nil if the field isn't set
+	value *time.Time
+	id    bson.ObjectID
+}
+
+// sortValue returns the value of the sort field of the user
+func sortValue(user entity.User, field string) time.Time {
+	if field == SortByLastLogin {
+		return user.LastLogin
+	}
+	return user.RegisteredAt
+}
+
+// encodeUserCursor returns an opaque cursor which points after the user
+func encodeUserCursor(user entity.User, sort searchUserSort) string {
+	direction := "a"
+	if sort.desc {
+		direction = "d"
+	}
+	value := ""
+	if v := sortValue(user, sort.field); !v.IsZero() {
+		value = v.UTC().Format(time.RFC3339Nano)
+	}
+	raw := strings.Join([]string{sort.field, direction, value, user.ID.Hex()}, "|")
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeUserCursor returns the position of the cursor.
+// It returns ErrInvalidCursor if the cursor is malformed or belongs to another sort.
+func decodeUserCursor(cursor string, sort searchUserSort) (*userCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 4 || parts[0] != sort.field || (parts[1] == "d") != sort.desc {
+		return nil, ErrInvalidCursor
+	}
+	c := userCursor{sort: sort}
+	if len(parts[2]) > 0 {
+		value, err := time.Parse(time.RFC3339Nano, parts[2])
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		c.value = &value
+	}
+	if c.id, err = bson.ObjectIDFromHex(parts[3]); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// newUserPage returns the page of users. The list has one more user than the limit
+// if there is a next page, which is removed from the page.
+func newUserPage(users []entity.User, option *searchUserOption) *UserPage {
+	page := &UserPage{Users: users}
+	if limit := option.pagination.limit; int64(len(users)) > limit {
+		page.Users = users[:limit]
+		page.NextCursor = encodeUserCursor(page.Users[limit-1], option.sort)
+	}
+	return page
+}
@@ -0,0 +1,984 @@
+// Package dbtest provides a conformance suite which every db.Database implementation must pass
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aph138/dekamond/internal/db"
+	"github.com/aph138/dekamond/internal/entity"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Factory returns an empty database.
+// The database should be closed by t.Cleanup.
+type Factory func(t *testing.T) db.Database
+
+// RunDatabaseSuite runs every test of the suite against the databases which factory returns
+func RunDatabaseSuite(t *testing.T, factory Factory) {
+	t.Run("SaveUserUpsert", func(t *testing.T) { testSaveUserUpsert(t, factory(t)) })
+	t.Run("FindUser", func(t *testing.T) { testFindUser(t, factory(t)) })
+	t.Run("UpdateUser", func(t *testing.T) { testUpdateUser(t, factory(t)) })
+	t.Run("UpdateUserPhone", func(t *testing.T) { testUpdateUserPhone(t, factory(t)) })
+	t.Run("Roles", func(t *testing.T) { testRoles(t, factory(t)) })
+	t.Run("DeleteUser", func(t *testing.T) { testDeleteUser(t, factory(t)) })
+	t.Run("ImportUsers", func(t *testing.T) { testImportUsers(t, factory(t)) })
+	t.Run("SearchFilters", func(t *testing.T) { testSearchFilters(t, factory(t)) })
+	t.Run("SearchPagination", func(t *testing.T) { testSearchPagination(t, factory(t)) })
+	t.Run("SearchCursor", func(t *testing.T) { testSearchCursor(t, factory(t)) })
+	t.Run("SearchSort", func(t *testing.T) { testSearchSort(t, factory(t)) })
+	t.Run("SearchPhonePattern", func(t *testing.T) { testSearchPhonePattern(t, factory(t)) })
+	t.Run("SearchFields", func(t *testing.T) { testSearchFields(t, factory(t)) })
+	t.Run("SearchFilterExpression", func(t *testing.T) { testSearchFilterExpression(t, factory(t)) })
+	t.Run("Clients", func(t *testing.T) { testClients(t, factory(t)) })
+	t.Run("Sessions", func(t *testing.T) { testSessions(t, factory(t)) })
+	t.Run("LoginEvents", func(t *testing.T) { testLoginEvents(t, factory(t)) })
+	t.Run("ExportUsers", func(t *testing.T) { testExportUsers(t, factory(t)) })
+	t.Run("ExportUsersBatches", func(t *testing.T) { testExportUsersBatches(t, factory(t)) })
+	t.Run("AuditRecords", func(t *testing.T) { testAuditRecords(t, factory(t)) })
+	t.Run("UserStats", func(t *testing.T) { testUserStats(t, factory(t)) })
+}
+
+func saveUser(t *testing.T, d db.Database, phone string) *entity.User {
+	t.Helper()
+	user, err := d.SaveUser(phone)
+	if err != nil {
+		t.Fatalf("err when saving user %s", err.Error())
+	}
+	return user
+}
+
+func search(t *testing.T, d db.Database, opts ...db.SearchUserOption) []entity.User {
+	t.Helper()
+	return searchPage(t, d, opts...).Users
+}
+
+func searchPage(t *testing.T, d db.Database, opts ...db.SearchUserOption) *db.UserPage {
+	t.Helper()
+	page, err := d.SearchUser(opts...)
+	if err != nil {
+		t.Fatalf("err when searching users %s", err.Error())
+	}
+	return page
+}
+
+// ids returns the IDs of the users in order
+func ids(users []entity.User) []bson.ObjectID {
+	result := make([]bson.ObjectID, 0, len(users))
+	for _, u := range users {
+		result = append(result, u.ID)
+	}
+	return result
+}
+
+func testSaveUserUpsert(t *testing.T, d db.Database) {
+	user := saveUser(t, d, "09000000001")
+	if user.ID.IsZero() || user.Phone != "09000000001" || user.RegisteredAt.IsZero() || user.LastLogin.IsZero() {
+		t.Fatalf("expected a complete user but got %+v", user)
+	}
+	if !slices.Equal(user.GetRoles(), []string{entity.RoleUser}) || user.GetStatus() != entity.StatusActive {
+		t.Fatalf("expected an active user with user role but got %+v", user)
+	}
+
+	time.Sleep(time.Millisecond * 10)
+	same := saveUser(t, d, "09000000001")
+	if same.ID != user.ID || !same.RegisteredAt.Equal(user.RegisteredAt) {
+		t.Fatalf("expected the same user but got %+v and %+v", user, same)
+	}
+	// only last login is changed
+	if !same.LastLogin.After(user.LastLogin) {
+		t.Fatalf("expected last login to be updated but got %s and %s", user.LastLogin, same.LastLogin)
+	}
+	if list := search(t, d); len(list) != 1 {
+		t.Fatalf("expected one user but got %d", len(list))
+	}
+}
+
+func testFindUser(t *testing.T, d db.Database) {
+	user := saveUser(t, d, "09000000001")
+	found, err := d.FindUser(user.ID.Hex())
+	if err != nil {
+		t.Fatalf("err when finding user %s", err.Error())
+	}
+	if found.ID != user.ID || found.Phone != user.Phone {
+		t.Fatalf("expected %+v but got %+v", user, found)
+	}
+	for _, id := range []string{bson.NewObjectID().Hex(), "invalid"} {
+		if _, err := d.FindUser(id); !errors.Is(err, db.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound for %s but got %v", id, err)
+		}
+	}
+}
+
+func testUpdateUser(t *testing.T, d db.Database) {
+	user := saveUser(t, d, "09000000001")
+	name, email, empty := "Ali", "ali@example.com", ""
+	updated, err := d.UpdateUser(user.ID.Hex(), db.UserUpdate{DisplayName: &name, Email: &email})
+	if err != nil {
+		t.Fatalf("err when updating user %s", err.Error())
+	}
+	if updated.DisplayName != name || updated.Email != email {
+		t.Fatalf("expected profile fields to be set but got %+v", updated)
+	}
+	// nil fields are unchanged and empty fields are removed
+	updated, err = d.UpdateUser(user.ID.Hex(), db.UserUpdate{Email: &empty})
+	if err != nil {
+		t.Fatalf("err when updating user %s", err.Error())
+	}
+	if updated.DisplayName != name || updated.Email != "" {
+		t.Fatalf("expected only email to be removed but got %+v", updated)
+	}
+	if _, err := d.UpdateUser(bson.NewObjectID().Hex(), db.UserUpdate{Email: &email}); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound but got %v", err)
+	}
+
+	// the phone number is changed with the profile
+	other := saveUser(t, d, "09000000002")
+	phone, taken := "09000000003", other.Phone
+	updated, err = d.UpdateUser(user.ID.Hex(), db.UserUpdate{DisplayName: &empty, Phone: &phone})
+	if err != nil {
+		t.Fatalf("err when updating user %s", err.Error())
+	}
+	if updated.Phone != phone || updated.DisplayName != "" {
+		t.Fatalf("expected the new phone without display name but got %+v", updated)
+	}
+	if _, err := d.UpdateUser(user.ID.Hex(), db.UserUpdate{DisplayName: &name, Phone: &taken}); !errors.Is(err, db.ErrDuplicate) {
+		t.Fatalf("expected ErrDuplicate but got %v", err)
+	}
+	// nothing is changed if the phone number is taken
+	if found, _ := d.FindUser(user.ID.Hex()); found.Phone != phone || found.DisplayName != "" {
+		t.Fatalf("expected the user to be unchanged but got %+v", found)
+	}
+	// phone numbers can't be removed
+	if updated, err := d.UpdateUser(user.ID.Hex(), db.UserUpdate{Phone: &empty}); err != nil || updated.Phone != phone {
+		t.Fatalf("expected the phone to be kept but got %+v %v", updated, err)
+	}
+}
+
+func testUpdateUserPhone(t *testing.T, d db.Database) {
+	user := saveUser(t, d, "09000000001")
+	other := saveUser(t, d, "09000000002")
+	updated, err := d.UpdateUserPhone(user.ID.Hex(), "09000000003")
+	if err != nil {
+		t.Fatalf("err when updating phone %s", err.Error())
+	}
+	if updated.Phone != "09000000003" {
+		t.Fatalf("expected the new phone but got %s", updated.Phone)
+	}
+	if _, err := d.UpdateUserPhone(other.ID.Hex(), "09000000003"); !errors.Is(err, db.ErrDuplicate) {
+		t.Fatalf("expected ErrDuplicate but got %v", err)
+	}
+	if _, err := d.UpdateUserPhone(bson.NewObjectID().Hex(), "09000000004"); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound but got %v", err)
+	}
+	// the old number is free
+	if user := saveUser(t, d, "09000000001"); user.ID == updated.ID {
+		t.Fatal("expected a new user for the old number")
+	}
+}
+
+func testRoles(t *testing.T, d db.Database) {
+	user := saveUser(t, d, "09000000001")
+	for range 2 {
+		updated, err := d.AddUserRole(user.ID.Hex(), entity.RoleAdmin)
+		if err != nil {
+			t.Fatalf("err when adding role %s", err.Error())
+		}
+		roles := updated.GetRoles()
+		if len(roles) != 2 || !slices.Contains(roles, entity.RoleUser) || !slices.Contains(roles, entity.RoleAdmin) {
+			t.Fatalf("expected user and admin roles but got %v", roles)
+		}
+	}
+	updated, err := d.RemoveUserRole(user.ID.Hex(), entity.RoleAdmin)
+	if err != nil {
+		t.Fatalf("err when removing role %s", err.Error())
+	}
+	if !slices.Equal(updated.GetRoles(), []string{entity.RoleUser}) {
+		t.Fatalf("expected only user role but got %v", updated.GetRoles())
+	}
+	if _, err := d.AddUserRole(bson.NewObjectID().Hex(), entity.RoleAdmin); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound but got %v", err)
+	}
+	if _, err := d.RemoveUserRole(bson.NewObjectID().Hex(), entity.RoleAdmin); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound but got %v", err)
+	}
+
+	// GrantRoleByPhone creates the user if it doesn't exist
+	granted, err := d.GrantRoleByPhone("09000000002", entity.RoleSupport)
+	if err != nil {
+		t.Fatalf("err when granting role by phone %s", err.Error())
+	}
+	roles := granted.GetRoles()
+	if granted.ID.IsZero() || len(roles) != 2 || !slices.Contains(roles, entity.RoleSupport) {
+		t.Fatalf("expected a new user with user and support roles but got %+v", granted)
+	}
+	if granted.GetStatus() != entity.StatusActive {
+		t.Fatalf("expected an active user but got %s", granted.GetStatus())
+	}
+	granted, err = d.GrantRoleByPhone("09000000001", entity.RoleSupport)
+	if err != nil {
+		t.Fatalf("err when granting role by phone %s", err.Error())
+	}
+	if granted.ID != user.ID || !slices.Contains(granted.GetRoles(), entity.RoleSupport) {
+		t.Fatalf("expected the existing user with support role but got %+v", granted)
+	}
+}
+
+func testDeleteUser(t *testing.T, d db.Database) {
+	user := saveUser(t, d, "09000000001")
+	if err := d.DeleteUser(user.ID.Hex()); err != nil {
+		t.Fatalf("err when deleting user %s", err.Error())
+	}
+	if _, err := d.FindUser(user.ID.Hex()); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound but got %v", err)
+	}
+	if err := d.DeleteUser(user.ID.Hex()); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound but got %v", err)
+	}
+}
+
+func testImportUsers(t *testing.T, d db.Database) {
+	existing := saveUser(t, d, "09000000001")
+	registeredAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	created, err := d.ImportUsers([]db.UserImport{
+		{Phone: "09000000001", RegisteredAt: registeredAt},
+		{Phone: "09000000002", RegisteredAt: registeredAt},
+		{Phone: "09000000003"},
+	})
+	if err != nil {
+		t.Fatalf("err when importing users %s", err.Error())
+	}
+	if !slices.Equal(created, []bool{false, true, true}) {
+		t.Fatalf("expected only new numbers to be created but got %v", created)
+	}
+
+	users := search(t, d, db.SearchUserSortBy(db.SortByRegisterTime, false))
+	if len(users) != 3 || users[0].Phone != "09000000002" || !users[0].RegisteredAt.Equal(registeredAt) {
+		t.Fatalf("expected the imported user with its registration date first but got %+v", users)
+	}
+	// imported users have never logged in
+	if !users[0].LastLogin.IsZero() || users[0].GetStatus() != entity.StatusActive || !slices.Equal(users[0].GetRoles(), []string{entity.RoleUser}) {
+		t.Fatalf("expected an active user who has never logged in but got %+v", users[0])
+	}
+	if users[1].ID != existing.ID || !users[1].RegisteredAt.Equal(existing.RegisteredAt) {
+		t.Fatalf("expected the existing user to be unchanged but got %+v", users[1])
+	}
+	if users[2].Phone != "09000000003" || users[2].RegisteredAt.Before(existing.RegisteredAt) {
+		t.Fatalf("expected the user without a date to be registered now but got %+v", users[2])
+	}
+
+	created, err = d.ImportUsers(nil)
+	if err != nil || len(created) != 0 {
+		t.Fatalf("expected nothing to be imported but got %v %v", created, err)
+	}
+}
+
+func testSearchFilters(t *testing.T, d db.Database) {
+	// some databases keep times in milliseconds
+	from := time.Now().Add(-time.Millisecond)
+	first := saveUser(t, d, "09000000001")
+	to := time.Now().Add(time.Millisecond * 5)
+	time.Sleep(time.Millisecond * 20)
+	second := saveUser(t, d, "09000000002")
+	third := saveUser(t, d, "09000000003")
+	if _, err := d.SetUserStatus(third.ID.Hex(), entity.StatusBanned); err != nil {
+		t.Fatalf("err when changing status %s", err.Error())
+	}
+	if _, err := d.SetUserStatus(bson.NewObjectID().Hex(), entity.StatusBanned); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound but got %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		opts     []db.SearchUserOption
+		expected []bson.ObjectID
+	}{
+		{"no filter", nil, []bson.ObjectID{third.ID, second.ID, first.ID}},
+		{"phone", []db.SearchUserOption{db.SearchUserByPhone("09000000002")}, []bson.ObjectID{second.ID}},
+		{"unknown phone", []db.SearchUserOption{db.SearchUserByPhone("09000000009")}, []bson.ObjectID{}},
+		{"register time", []db.SearchUserOption{db.SearchUserByRegisterTime(&from, &to)}, []bson.ObjectID{first.ID}},
+		{"active", []db.SearchUserOption{db.SearchUserByStatus(entity.StatusActive)}, []bson.ObjectID{second.ID, first.ID}},
+		{"banned", []db.SearchUserOption{db.SearchUserByStatus(entity.StatusBanned)}, []bson.ObjectID{third.ID}},
+		{"phone and status", []db.SearchUserOption{
+			db.SearchUserByPhone("09000000001"), db.SearchUserByStatus(entity.StatusBanned),
+		}, []bson.ObjectID{}},
+	}
+	for _, test := range tests {
+		if result := ids(search(t, d, test.opts...)); !slices.Equal(result, test.expected) {
+			t.Fatalf("%s: expected %v but got %v", test.name, test.expected, result)
+		}
+		// total is the number of every matching user, not only the page
+		page := searchPage(t, d, append(test.opts, db.SearchUserWithTotal(), db.SearchUserByPagination(1, 1))...)
+		if page.Total == nil || *page.Total != int64(len(test.expected)) {
+			t.Fatalf("%s: expected total %d but got %v", test.name, len(test.expected), page.Total)
+		}
+	}
+	if page := searchPage(t, d); page.Total != nil {
+		t.Fatalf("expected no total without counting but got %d", *page.Total)
+	}
+}
+
+func testSearchPagination(t *testing.T, d db.Database) {
+	users := []bson.ObjectID{}
+	for _, phone := range []string{"09000000001", "09000000002", "09000000003", "09000000004", "09000000005"} {
+		users = append(users, saveUser(t, d, phone).ID)
+		// register times must be different for a stable order
+		time.Sleep(time.Millisecond * 5)
+	}
+	// newest first
+	slices.Reverse(users)
+
+	tests := []struct {
+		page, limit int64
+		expected    []bson.ObjectID
+	}{
+		{1, 2, users[0:2]},
+		{2, 2, users[2:4]},
+		{3, 2, users[4:]},
+		{4, 2, []bson.ObjectID{}},
+		{1, 10, users},
+		// values less than 1 are treated as 1
+		{0, 0, users[0:1]},
+	}
+	for _, test := range tests {
+		result := ids(search(t, d, db.SearchUserByPagination(test.page, test.limit)))
+		if !slices.Equal(result, test.expected) {
+			t.Fatalf("page %d limit %d: expected %v but got %v", test.page, test.limit, test.expected, result)
+		}
+	}
+	// default is the first 10 users
+	if result := ids(search(t, d)); !slices.Equal(result, users) {
+		t.Fatalf("expected %v but got %v", users, result)
+	}
+}
+
+func testSearchCursor(t *testing.T, d db.Database) {
+	users := []bson.ObjectID{}
+	for _, phone := range []string{"09000000001", "09000000002", "09000000003", "09000000004", "09000000005"} {
+		users = append(users, saveUser(t, d, phone).ID)
+		time.Sleep(time.Millisecond * 5)
+	}
+	slices.Reverse(users)
+
+	first := searchPage(t, d, db.SearchUserByPagination(1, 2))
+	if !slices.Equal(ids(first.Users), users[0:2]) || first.NextCursor == "" {
+		t.Fatalf("expected the first two users and a cursor but got %v %q", ids(first.Users), first.NextCursor)
+	}
+	// users who register in the meantime don't shift the next pages
+	saveUser(t, d, "09000000006")
+
+	result := ids(first.Users)
+	cursor := first.NextCursor
+	for cursor != "" {
+		page := searchPage(t, d, db.SearchUserByPagination(1, 2), db.SearchUserAfter(cursor))
+		result = append(result, ids(page.Users)...)
+		cursor = page.NextCursor
+	}
+	if !slices.Equal(result, users) {
+		t.Fatalf("expected %v but got %v", users, result)
+	}
+
+	// total doesn't depend on the cursor
+	if page := searchPage(t, d, db.SearchUserAfter(first.NextCursor), db.SearchUserWithTotal()); page.Total == nil || *page.Total != 6 {
+		t.Fatalf("expected total of 6 users but got %v", page.Total)
+	}
+
+	// the page number is ignored with a cursor and filters are applied
+	page := searchPage(t, d, db.SearchUserByPagination(5, 10), db.SearchUserAfter(first.NextCursor),
+		db.SearchUserByPhone("09000000003"))
+	if !slices.Equal(ids(page.Users), users[2:3]) || page.NextCursor != "" {
+		t.Fatalf("expected only %v but got %v %q", users[2:3], ids(page.Users), page.NextCursor)
+	}
+	// the last page has no cursor
+	if page := searchPage(t, d, db.SearchUserByPagination(1, 10)); len(page.Users) != 6 || page.NextCursor != "" {
+		t.Fatalf("expected every user without cursor but got %d %q", len(page.Users), page.NextCursor)
+	}
+
+	for _, cursor := range []string{"invalid!", "aW52YWxpZA"} {
+		if _, err := d.SearchUser(db.SearchUserAfter(cursor)); !errors.Is(err, db.ErrInvalidCursor) {
+			t.Fatalf("expected ErrInvalidCursor for %s but got %v", cursor, err)
+		}
+	}
+}
+
+func testSearchSort(t *testing.T, d db.Database) {
+	// some databases keep times in milliseconds, so events are apart
+	wait := func() time.Time {
+		time.Sleep(time.Millisecond * 20)
+		return time.Now()
+	}
+	a := saveUser(t, d, "09000000001")
+	afterA := wait()
+	b := saveUser(t, d, "09000000002")
+	wait()
+	// users who are created by admins have never logged in
+	c, err := d.GrantRoleByPhone("09000000003", entity.RoleSupport)
+	if err != nil {
+		t.Fatalf("err when granting role by phone %s", err.Error())
+	}
+	beforeLogin := wait()
+	saveUser(t, d, "09000000001")
+
+	tests := []struct {
+		name     string
+		opts     []db.SearchUserOption
+		expected []bson.ObjectID
+	}{
+		{"register ascending", []db.SearchUserOption{db.SearchUserSortBy(db.SortByRegisterTime, false)}, []bson.ObjectID{a.ID, b.ID, c.ID}},
+		{"last login descending", []db.SearchUserOption{db.SearchUserSortBy(db.SortByLastLogin, true)}, []bson.ObjectID{a.ID, b.ID, c.ID}},
+		{"last login ascending", []db.SearchUserOption{db.SearchUserSortBy(db.SortByLastLogin, false)}, []bson.ObjectID{c.ID, b.ID, a.ID}},
+		{"register from", []db.SearchUserOption{db.SearchUserByRegisterTime(&afterA, nil)}, []bson.ObjectID{c.ID, b.ID}},
+		{"register to", []db.SearchUserOption{db.SearchUserByRegisterTime(nil, &afterA)}, []bson.ObjectID{a.ID}},
+		{"last login from", []db.SearchUserOption{db.SearchUserByLastLogin(&beforeLogin, nil)}, []bson.ObjectID{a.ID}},
+		{"last login to", []db.SearchUserOption{db.SearchUserByLastLogin(nil, &beforeLogin)}, []bson.ObjectID{b.ID}},
+		{"last login and register", []db.SearchUserOption{
+			db.SearchUserByLastLogin(&afterA, nil), db.SearchUserByRegisterTime(nil, &afterA),
+		}, []bson.ObjectID{a.ID}},
+	}
+	for _, test := range tests {
+		if result := ids(search(t, d, test.opts...)); !slices.Equal(result, test.expected) {
+			t.Fatalf("%s: expected %v but got %v", test.name, test.expected, result)
+		}
+		// cursors give the same order one user at a time
+		result := []bson.ObjectID{}
+		cursor := ""
+		for range len(test.expected) + 1 {
+			opts := append(slices.Clone(test.opts), db.SearchUserByPagination(1, 1))
+			if cursor != "" {
+				opts = append(opts, db.SearchUserAfter(cursor))
+			}
+			page := searchPage(t, d, opts...)
+			result = append(result, ids(page.Users)...)
+			if cursor = page.NextCursor; cursor == "" {
+				break
+			}
+		}
+		if !slices.Equal(result, test.expected) {
+			t.Fatalf("%s: expected %v with cursors but got %v", test.name, test.expected, result)
+		}
+	}
+
+	// cursors belong to a sort
+	page := searchPage(t, d, db.SearchUserByPagination(1, 1))
+	if _, err := d.SearchUser(db.SearchUserAfter(page.NextCursor), db.SearchUserSortBy(db.SortByLastLogin, true)); !errors.Is(err, db.ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor but got %v", err)
+	}
+	if _, err := d.SearchUser(db.SearchUserSortBy("phone", true)); err == nil {
+		t.Fatal("expected an error for unsupported sort field")
+	}
+}
+
+func testSearchPhonePattern(t *testing.T, d db.Database) {
+	users := map[string]bson.ObjectID{}
+	for _, phone := range []string{"09120001234", "09120002234", "09120005678", "09120010000", "09120090000", "09120100000"} {
+		users[phone] = saveUser(t, d, phone).ID
+		time.Sleep(time.Millisecond * 5)
+	}
+	expect := func(phones ...string) []bson.ObjectID {
+		result := []bson.ObjectID{}
+		for _, phone := range phones {
+			result = append(result, users[phone])
+		}
+		return result
+	}
+
+	tests := []struct {
+		name     string
+		opts     []db.SearchUserOption
+		expected []bson.ObjectID
+	}{
+		{"prefix", []db.SearchUserOption{db.SearchUserByPhonePrefix("0912000")}, expect("09120005678", "09120002234", "09120001234")},
+		{"longer prefix", []db.SearchUserOption{db.SearchUserByPhonePrefix("09120001")}, expect("09120001234")},
+		{"prefix ending with 9", []db.SearchUserOption{db.SearchUserByPhonePrefix("0912009")}, expect("09120090000")},
+		{"masked", []db.SearchUserOption{db.SearchUserByMaskedPhone("0912000*234")}, expect("09120002234", "09120001234")},
+		{"masked suffix", []db.SearchUserOption{db.SearchUserByMaskedPhone("0912000****")}, expect("09120005678", "09120002234", "09120001234")},
+		// the whole number must match
+		{"short masked", []db.SearchUserOption{db.SearchUserByMaskedPhone("0912000*")}, expect()},
+		{"no match", []db.SearchUserOption{db.SearchUserByPhonePrefix("0999999")}, expect()},
+		{"exact phone", []db.SearchUserOption{db.SearchUserByPhonePrefix("0913000"), db.SearchUserByPhone("09120001234")}, expect("09120001234")},
+	}
+	for _, test := range tests {
+		if result := ids(search(t, d, test.opts...)); !slices.Equal(result, test.expected) {
+			t.Fatalf("%s: expected %v but got %v", test.name, test.expected, result)
+		}
+		page := searchPage(t, d, append(test.opts, db.SearchUserWithTotal())...)
+		if page.Total == nil || *page.Total != int64(len(test.expected)) {
+			t.Fatalf("%s: expected total %d but got %v", test.name, len(test.expected), page.Total)
+		}
+	}
+
+	// short prefixes would read most of the users
+	invalid := []db.SearchUserOption{
+		db.SearchUserByPhonePrefix("091200"),
+		db.SearchUserByPhonePrefix("0912000*"),
+		db.SearchUserByPhonePrefix("0912000a"),
+		db.SearchUserByMaskedPhone("0912*******"),
+		db.SearchUserByMaskedPhone("*9120001234"),
+		db.SearchUserByMaskedPhone("0912000.*"),
+	}
+	for _, opt := range invalid {
+		if _, err := d.SearchUser(opt); !errors.Is(err, db.ErrInvalidPhonePattern) {
+			t.Fatalf("expected ErrInvalidPhonePattern but got %v", err)
+		}
+	}
+}
+
+func testSearchFields(t *testing.T, d db.Database) {
+	users := []*entity.User{}
+	for _, phone := range []string{"09000000001", "09000000002", "09000000003"} {
+		users = append(users, saveUser(t, d, phone))
+		// some databases keep times in milliseconds
+		time.Sleep(time.Millisecond * 5)
+	}
+	page := searchPage(t, d, db.SearchUserFields("phone"), db.SearchUserByPagination(1, 2))
+	if len(page.Users) != 2 || page.Users[0].Phone != users[2].Phone || page.Users[0].ID != users[2].ID {
+		t.Fatalf("expected the ID and phone of the newest users but got %+v", page.Users)
+	}
+	// the ID and the sort field are read for the cursor
+	next := search(t, d, db.SearchUserFields("phone"), db.SearchUserByPagination(1, 2), db.SearchUserAfter(page.NextCursor))
+	if len(next) != 1 || next[0].ID != users[0].ID {
+		t.Fatalf("expected the oldest user but got %+v", next)
+	}
+	if _, err := d.SearchUser(db.SearchUserFields("password")); err == nil {
+		t.Fatalf("expected an error for an unknown field")
+	}
+}
+
+func testSearchFilterExpression(t *testing.T, d db.Database) {
+	first := saveUser(t, d, "09000000011")
+	if _, err := d.AddUserRole(first.ID.Hex(), entity.RoleAdmin); err != nil {
+		t.Fatalf("err when adding role %s", err.Error())
+	}
+	name, email := "Ali Reza", "ali@example.com"
+	if _, err := d.UpdateUser(first.ID.Hex(), db.UserUpdate{DisplayName: &name, Email: &email}); err != nil {
+		t.Fatalf("err when updating user %s", err.Error())
+	}
+	time.Sleep(time.Millisecond * 5)
+	second := saveUser(t, d, "09000000012")
+	if _, err := d.SetUserStatus(second.ID.Hex(), entity.StatusSuspended); err != nil {
+		t.Fatalf("err when changing status %s", err.Error())
+	}
+	// imported users have never logged in
+	if _, err := d.ImportUsers([]db.UserImport{{Phone: "09000000013", RegisteredAt: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)}}); err != nil {
+		t.Fatalf("err when importing user %s", err.Error())
+	}
+	third := search(t, d, db.SearchUserByPhone("09000000013"))[0]
+
+	tests := []struct {
+		expr     string
+		expected []bson.ObjectID
+	}{
+		{"role:admin", []bson.ObjectID{first.ID}},
+		{"role:user", []bson.ObjectID{second.ID, first.ID, third.ID}},
+		{"status:active", []bson.ObjectID{first.ID, third.ID}},
+		{"status:suspended OR role:admin", []bson.ObjectID{second.ID, first.ID}},
+		{"NOT status:active", []bson.ObjectID{second.ID}},
+		{"phone:09000000012", []bson.ObjectID{second.ID}},
+		{"phone:0900000001*", []bson.ObjectID{second.ID, first.ID, third.ID}},
+		{`display_name:"Ali Reza" AND email:ali@example.com`, []bson.ObjectID{first.ID}},
+		{"locale:fa-IR", []bson.ObjectID{}},
+		{"register_at:2024-06-01", []bson.ObjectID{third.ID}},
+		{"register_at<=2024-05-31", []bson.ObjectID{}},
+		{"register_at>2024-06-01 AND NOT (role:admin OR status:banned)", []bson.ObjectID{second.ID}},
+		{"register_at>=2024-06-01T12:00:00Z AND register_at<2024-06-01T12:00:01Z", []bson.ObjectID{third.ID}},
+		{"last_login>=2000-01-01", []bson.ObjectID{second.ID, first.ID}},
+		// users who have never logged in don't have the field, so the negation matches them
+		{"NOT last_login>=2000-01-01", []bson.ObjectID{third.ID}},
+	}
+	for _, test := range tests {
+		expr, err := db.ParseUserFilter(test.expr, time.UTC)
+		if err != nil {
+			t.Fatalf("err when parsing %s: %s", test.expr, err.Error())
+		}
+		if result := ids(search(t, d, db.SearchUserByFilter(expr))); !slices.Equal(result, test.expected) {
+			t.Fatalf("%s: expected %v but got %v", test.expr, test.expected, result)
+		}
+		page := searchPage(t, d, db.SearchUserByFilter(expr), db.SearchUserWithTotal(), db.SearchUserByPagination(1, 1))
+		if page.Total == nil || *page.Total != int64(len(test.expected)) {
+			t.Fatalf("%s: expected total %d but got %v", test.expr, len(test.expected), page.Total)
+		}
+	}
+
+	// the expression is joined with the other filters
+	expr, _ := db.ParseUserFilter("role:user", time.UTC)
+	if result := ids(search(t, d, db.SearchUserByFilter(expr), db.SearchUserByStatus(entity.StatusSuspended))); !slices.Equal(result, []bson.ObjectID{second.ID}) {
+		t.Fatalf("expected the suspended user but got %v", result)
+	}
+	// expressions which aren't made by the parser are checked, since their fields are put in queries
+	for _, expr := range []db.FilterExpr{
+		&db.FilterCondition{Field: "phone = phone OR 1", Op: db.FilterEq, Value: "1"},
+		&db.FilterCondition{Field: "status", Op: db.FilterGt, Value: entity.StatusActive},
+		&db.FilterCondition{Field: "register_at", Op: db.FilterEq, Value: "2024-06-01"},
+		&db.FilterOr{},
+	} {
+		if _, err := d.SearchUser(db.SearchUserByFilter(expr)); err == nil {
+			t.Fatalf("expected an error for %+v", expr)
+		}
+	}
+}
+
+func testClients(t *testing.T, d db.Database) {
+	client := &entity.Client{
+		ID:           "client",
+		SecretHash:   "hash",
+		Name:         "Client",
+		RedirectURIs: []string{"https://example.com/callback", "https://example.com/a,b"},
+		CreatedAt:    time.Now().Truncate(time.Millisecond),
+	}
+	if err := d.SaveClient(client); err != nil {
+		t.Fatalf("err when saving client %s", err.Error())
+	}
+	found, err := d.FindClient("client")
+	if err != nil {
+		t.Fatalf("err when finding client %s", err.Error())
+	}
+	if found.ID != client.ID || found.SecretHash != client.SecretHash || found.Name != client.Name ||
+		!slices.Equal(found.RedirectURIs, client.RedirectURIs) || !found.CreatedAt.Equal(client.CreatedAt) {
+		t.Fatalf("expected %+v but got %+v", client, found)
+	}
+	if _, err := d.FindClient("missing"); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound but got %v", err)
+	}
+}
+
+func testSessions(t *testing.T, d db.Database) {
+	user := saveUser(t, d, "09000000001")
+	other := saveUser(t, d, "09000000002")
+	now := time.Now()
+	newSession := func(userID bson.ObjectID, lastSeen time.Time, ttl time.Duration) string {
+		t.Helper()
+		id, err := d.CreateSession(&entity.Session{
+			UserID:     userID,
+			DeviceName: "device",
+			CreatedAt:  now,
+			LastSeenAt: lastSeen,
+			ExpiresAt:  now.Add(ttl),
+		})
+		if err != nil {
+			t.Fatalf("err when creating session %s", err.Error())
+		}
+		return id
+	}
+	older := newSession(user.ID, now.Add(-time.Minute), time.Hour)
+	newer := newSession(user.ID, now, time.Hour)
+	expired := newSession(user.ID, now, -time.Second)
+	otherSession := newSession(other.ID, now, time.Hour)
+
+	session, err := d.FindSession(newer)
+	if err != nil {
+		t.Fatalf("err when finding session %s", err.Error())
+	}
+	if session.UserID != user.ID || session.DeviceName != "device" {
+		t.Fatalf("unexpected session %+v", session)
+	}
+	for _, id := range []string{expired, bson.NewObjectID().Hex(), "invalid"} {
+		if _, err := d.FindSession(id); !errors.Is(err, db.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound for %s but got %v", id, err)
+		}
+	}
+
+	// expired sessions are not listed and the last seen is first
+	list, err := d.ListSessions(user.ID.Hex())
+	if err != nil {
+		t.Fatalf("err when listing sessions %s", err.Error())
+	}
+	if len(list) != 2 || list[0].ID.Hex() != newer || list[1].ID.Hex() != older {
+		t.Fatalf("expected the two active sessions but got %+v", list)
+	}
+
+	if err := d.TouchSession(older, user.ID.Hex()); err != nil {
+		t.Fatalf("err when touching session %s", err.Error())
+	}
+	list, _ = d.ListSessions(user.ID.Hex())
+	if len(list) != 2 || list[0].ID.Hex() != older {
+		t.Fatalf("expected the touched session to be first but got %+v", list)
+	}
+	if err := d.TouchSession(otherSession, user.ID.Hex()); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for session of another user but got %v", err)
+	}
+	if err := d.TouchSession(expired, user.ID.Hex()); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for expired session but got %v", err)
+	}
+
+	if err := d.DeleteSession(otherSession, user.ID.Hex()); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for session of another user but got %v", err)
+	}
+	if err := d.DeleteSession(older, user.ID.Hex()); err != nil {
+		t.Fatalf("err when deleting session %s", err.Error())
+	}
+	if err := d.DeleteSession(older, user.ID.Hex()); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound but got %v", err)
+	}
+
+	if err := d.DeleteUserSessions(user.ID.Hex()); err != nil {
+		t.Fatalf("err when deleting user sessions %s", err.Error())
+	}
+	if list, _ := d.ListSessions(user.ID.Hex()); len(list) != 0 {
+		t.Fatalf("expected no sessions but got %+v", list)
+	}
+	// sessions of other users are kept
+	if _, err := d.FindSession(otherSession); err != nil {
+		t.Fatalf("expected session of another user to exist but got %v", err)
+	}
+}
+
+func testLoginEvents(t *testing.T, d db.Database) {
+	user := saveUser(t, d, "09000000001")
+	other := bson.NewObjectID()
+	now := time.Now()
+	events := []*entity.LoginEvent{
+		// before the user is known
+		{Phone: "09000000001", Type: entity.EventOTPRequest, Outcome: entity.OutcomeSuccess},
+		{UserID: user.ID, Phone: "09000000001", Type: entity.EventOTPVerify, Outcome: entity.OutcomeSuccess},
+		{UserID: user.ID, Phone: "09000000001", Type: entity.EventTokenIssued, Outcome: entity.OutcomeSuccess},
+		// another user which had the number before
+		{UserID: other, Phone: "09000000001", Type: entity.EventTokenIssued, Outcome: entity.OutcomeSuccess},
+		{Phone: "09000000002", Type: entity.EventOTPRequest, Outcome: entity.OutcomeSuccess},
+	}
+	for i, event := range events {
+		event.Channel = entity.ChannelAPI
+		event.IP = "127.0.0.1"
+		event.LatencyMS = int64(i)
+		event.CreatedAt = now.Add(time.Millisecond * time.Duration(i))
+		event.ExpiresAt = now.Add(time.Hour)
+		if err := d.SaveLoginEvent(event); err != nil {
+			t.Fatalf("err when saving login event %s", err.Error())
+		}
+	}
+	// an OTP request of the previous owner of the number
+	if err := d.SaveLoginEvent(&entity.LoginEvent{
+		Phone:     "09000000001",
+		Type:      entity.EventOTPRequest,
+		Channel:   entity.ChannelAPI,
+		Outcome:   entity.OutcomeSuccess,
+		CreatedAt: now.Add(-time.Hour),
+		ExpiresAt: now.Add(time.Hour),
+	}); err != nil {
+		t.Fatalf("err when saving login event %s", err.Error())
+	}
+	since := now.Add(-time.Minute)
+
+	list, err := d.ListLoginEvents(user.ID.Hex(), "09000000001", since, 0)
+	if err != nil {
+		t.Fatalf("err when listing login events %s", err.Error())
+	}
+	types := []string{}
+	for _, event := range list {
+		types = append(types, event.Type)
+	}
+	// newest first
+	expected := []string{entity.EventTokenIssued, entity.EventOTPVerify, entity.EventOTPRequest}
+	if !slices.Equal(types, expected) {
+		t.Fatalf("expected %v but got %v", expected, types)
+	}
+	if list[0].UserID != user.ID || list[0].IP != "127.0.0.1" || list[0].LatencyMS != 2 || !list[2].UserID.IsZero() {
+		t.Fatalf("unexpected events %+v", list)
+	}
+
+	list, _ = d.ListLoginEvents(user.ID.Hex(), "09000000001", since, 2)
+	if len(list) != 2 {
+		t.Fatalf("expected 2 events but got %d", len(list))
+	}
+
+	if err := d.DeleteLoginEvents(user.ID.Hex(), "09000000001", since); err != nil {
+		t.Fatalf("err when deleting login events %s", err.Error())
+	}
+	if list, _ := d.ListLoginEvents(user.ID.Hex(), "09000000001", since, 0); len(list) != 0 {
+		t.Fatalf("expected no events but got %+v", list)
+	}
+	// events of the previous owner are kept
+	if list, _ := d.ListLoginEvents(user.ID.Hex(), "09000000001", time.Time{}, 0); len(list) != 1 {
+		t.Fatalf("expected the event of the previous owner but got %+v", list)
+	}
+	// events of the other user and the other number are kept
+	if list, _ := d.ListLoginEvents(other.Hex(), "09000000002", time.Time{}, 0); len(list) != 2 {
+		t.Fatalf("expected 2 events but got %+v", list)
+	}
+}
+
+func testExportUsers(t *testing.T, d db.Database) {
+	users := []*entity.User{}
+	for _, phone := range []string{"09000000001", "09000000002", "09000000003"} {
+		users = append(users, saveUser(t, d, phone))
+		// some databases keep times in milliseconds
+		time.Sleep(time.Millisecond * 5)
+	}
+	if _, err := d.SetUserStatus(users[1].ID.Hex(), entity.StatusSuspended); err != nil {
+		t.Fatalf("err when setting user status %s", err.Error())
+	}
+	export := func(opts ...db.SearchUserOption) []bson.ObjectID {
+		t.Helper()
+		result := []bson.ObjectID{}
+		err := d.ExportUsers(context.Background(), func(user entity.User) error {
+			result = append(result, user.ID)
+			return nil
+		}, opts...)
+		if err != nil {
+			t.Fatalf("err when exporting users %s", err.Error())
+		}
+		return result
+	}
+
+	// pagination is ignored
+	expected := []bson.ObjectID{users[2].ID, users[1].ID, users[0].ID}
+	if result := export(db.SearchUserByPagination(1, 1)); !slices.Equal(result, expected) {
+		t.Fatalf("expected %v but got %v", expected, result)
+	}
+	expected = []bson.ObjectID{users[0].ID, users[2].ID}
+	result := export(db.SearchUserByStatus(entity.StatusActive), db.SearchUserSortBy(db.SortByRegisterTime, false))
+	if !slices.Equal(result, expected) {
+		t.Fatalf("expected %v but got %v", expected, result)
+	}
+
+	// an error of the function stops the export
+	stop := errors.New("stop")
+	calls := 0
+	err := d.ExportUsers(context.Background(), func(entity.User) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Fatalf("expected the export to stop after one user but got %v after %d", err, calls)
+	}
+	err = d.ExportUsers(context.Background(), func(entity.User) error { return nil }, db.SearchUserByPhonePrefix("09"))
+	if !errors.Is(err, db.ErrInvalidPhonePattern) {
+		t.Fatalf("expected ErrInvalidPhonePattern but got %v", err)
+	}
+}
+
+func testExportUsersBatches(t *testing.T, d db.Database) {
+	// users with the same register time are ordered by ID across batches
+	registeredAt := time.Now().Add(-time.Hour)
+	users := []db.UserImport{}
+	for i := range 2500 {
+		users = append(users, db.UserImport{Phone: fmt.Sprintf("0910%07d", i), RegisteredAt: registeredAt})
+	}
+	if _, err := d.ImportUsers(users); err != nil {
+		t.Fatalf("err when importing users %s", err.Error())
+	}
+	seen := map[bson.ObjectID]bool{}
+	var previous bson.ObjectID
+	err := d.ExportUsers(context.Background(), func(user entity.User) error {
+		if seen[user.ID] || user.ID.Hex() < previous.Hex() {
+			return fmt.Errorf("user %s is out of order", user.ID.Hex())
+		}
+		seen[user.ID] = true
+		previous = user.ID
+		// other queries can run while the export is written
+		_, err := d.FindUser(user.ID.Hex())
+		return err
+	}, db.SearchUserSortBy(db.SortByRegisterTime, false))
+	if err != nil {
+		t.Fatalf("err when exporting users %s", err.Error())
+	}
+	if len(seen) != len(users) {
+		t.Fatalf("expected %d users but got %d", len(users), len(seen))
+	}
+}
+
+func testAuditRecords(t *testing.T, d db.Database) {
+	actor := bson.NewObjectID()
+	now := time.Now()
+	records := []*entity.AuditRecord{
+		{ActorID: actor, Action: entity.AuditUserExport, Details: map[string]string{"format": "csv", "status": "active"}},
+		{ActorID: actor, Action: entity.AuditUserExport, TargetID: bson.NewObjectID().Hex(), IP: "127.0.0.1"},
+	}
+	for i, record := range records {
+		record.CreatedAt = now.Add(time.Millisecond * time.Duration(i))
+		if err := d.SaveAuditRecord(record); err != nil {
+			t.Fatalf("err when saving audit record %s", err.Error())
+		}
+	}
+
+	list, err := d.ListAuditRecords(0)
+	if err != nil {
+		t.Fatalf("err when listing audit records %s", err.Error())
+	}
+	// newest first
+	if len(list) != 2 || list[0].TargetID != records[1].TargetID || list[0].IP != "127.0.0.1" || list[0].Details != nil {
+		t.Fatalf("unexpected audit records %+v", list)
+	}
+	if list[1].ActorID != actor || list[1].ID.IsZero() || list[1].Details["format"] != "csv" || list[1].Details["status"] != "active" {
+		t.Fatalf("unexpected audit record %+v", list[1])
+	}
+	if list, _ := d.ListAuditRecords(1); len(list) != 1 {
+		t.Fatalf("expected one audit record but got %d", len(list))
+	}
+}
+
+func testUserStats(t *testing.T, d db.Database) {
+	saveUser(t, d, "09000000001")
+	saveUser(t, d, "09000000002")
+	saveUser(t, d, "09000000003")
+	// users who are created by admins have never logged in
+	if _, err := d.GrantRoleByPhone("09000000004", entity.RoleSupport); err != nil {
+		t.Fatalf("err when granting role by phone %s", err.Error())
+	}
+	now := time.Now()
+	tehran, err := time.LoadLocation("Asia/Tehran")
+	if err != nil {
+		t.Fatalf("err when loading location %s", err.Error())
+	}
+
+	tests := []struct {
+		interval string
+		from     time.Time
+		location *time.Location
+		buckets  int
+		start    func(time.Time) bool
+	}{
+		{db.IntervalDay, now.AddDate(0, 0, -2), time.UTC, 3, func(s time.Time) bool { return s.Hour() == 0 && s.Minute() == 0 }},
+		{db.IntervalDay, now.AddDate(0, 0, -2), tehran, 3, func(s time.Time) bool { return s.In(tehran).Hour() == 0 && s.In(tehran).Minute() == 0 }},
+		{db.IntervalWeek, now.AddDate(0, 0, -14), time.UTC, 3, func(s time.Time) bool { return s.Weekday() == time.Monday && s.Hour() == 0 }},
+		// the last day of the previous month
+		{db.IntervalMonth, now.UTC().AddDate(0, 0, -now.UTC().Day()), time.UTC, 2, func(s time.Time) bool { return s.Day() == 1 && s.Hour() == 0 }},
+	}
+	for _, test := range tests {
+		buckets, err := d.UserStats(test.interval, test.from, now, test.location)
+		if err != nil {
+			t.Fatalf("err when counting users by %s %s", test.interval, err.Error())
+		}
+		if len(buckets) != test.buckets {
+			t.Fatalf("%s: expected %d buckets but got %+v", test.interval, test.buckets, buckets)
+		}
+		var registrations, activeUsers int64
+		for i, bucket := range buckets {
+			if !test.start(bucket.Start) || (i > 0 && !bucket.Start.After(buckets[i-1].Start)) {
+				t.Fatalf("%s: unexpected bucket start %s", test.interval, bucket.Start.In(test.location))
+			}
+			registrations += bucket.Registrations
+			activeUsers += bucket.ActiveUsers
+		}
+		if registrations != 4 || activeUsers != 3 {
+			t.Fatalf("%s: expected 4 registrations and 3 active users but got %d and %d", test.interval, registrations, activeUsers)
+		}
+	}
+
+	// users are counted only in their range
+	buckets, err := d.UserStats(db.IntervalDay, now.AddDate(0, 0, -10), now.AddDate(0, 0, -5), time.UTC)
+	if err != nil {
+		t.Fatalf("err when counting users %s", err.Error())
+	}
+	for _, bucket := range buckets {
+		if bucket.Registrations != 0 || bucket.ActiveUsers != 0 {
+			t.Fatalf("expected empty buckets but got %+v", buckets)
+		}
+	}
+
+	for _, test := range []struct {
+		interval string
+		from, to time.Time
+	}{
+		{"year", now, now},
+		{db.IntervalDay, now, now.AddDate(0, 0, -1)},
+		{db.IntervalDay, now.AddDate(-10, 0, 0), now},
+	} {
+		if _, err := d.UserStats(test.interval, test.from, test.to, time.UTC); !errors.Is(err, db.ErrInvalidStatsRange) {
+			t.Fatalf("expected ErrInvalidStatsRange for %s from %s to %s but got %v", test.interval, test.from, test.to, err)
+		}
+	}
+}
@@ -0,0 +1,459 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aph138/dekamond/internal/entity"
+)
+
+// limits of filter expressions, so they can't make huge queries
+const (
+	MaxFilterLength = 1000
+	MaxFilterDepth  = 20
+)
+
+// FilterOp is a comparison operator of filter expressions
+type FilterOp string
+
+// comparison operators, only time fields are compared by order
+const (
+	FilterEq  FilterOp = ":"
+	FilterGt  FilterOp = ">"
+	FilterGte FilterOp = ">="
+	FilterLt  FilterOp = "<"
+	FilterLte FilterOp = "<="
+)
+
+// filterOps lists every operator, longer ones first so they are matched before their prefixes
+var filterOps = []FilterOp{FilterGte, FilterLte, FilterEq, FilterGt, FilterLt}
+
+// kinds of fields, which decide the valid operators and values
+type filterKind int
+
+const (
+	filterText filterKind = iota
+	filterEnum
+	filterPhone
+	filterTime
+)
+
+// filterField is a field of users which filter expressions can compare
+type filterField struct {
+	kind filterKind
+	// column is the name of the field in documents and tables
+	column string
+	// values are the valid values of enum fields
+	values []string
+}
+
+// filterFields is the whitelist of filter expressions, only these columns are put in queries
+var filterFields = map[string]filterField{
+	"phone":        {kind: filterPhone, column: "phone"},
+	"register_at":  {kind: filterTime, column: "register_at"},
+	"last_login":   {kind: filterTime, column: "last_login"},
+	"status":       {kind: filterEnum, column: "status", values: entity.Statuses},
+	"role":         {kind: filterEnum, column: "roles", values: entity.Roles},
+	"display_name": {kind: filterText, column: "display_name"},
+	"email":        {kind: filterText, column: "email"},
+	"locale":       {kind: filterText, column: "locale"},
+}
+
+// FilterExpr is a node of the syntax tree of a filter expression,
+// which is one of *FilterAnd, *FilterOr, *FilterNot and *FilterCondition
+type FilterExpr interface {
+	filterExpr()
+}
+
+// FilterAnd matches users who match every expression
+type FilterAnd struct {
+	Exprs []FilterExpr
+}
+
+// FilterOr matches users who match at least one of the expressions
+type FilterOr struct {
+	Exprs []FilterExpr
+}
+
+// FilterNot matches users who don't match the expression
+type FilterNot struct {
+	Expr FilterExpr
+}
+
+// FilterCondition compares a field of users with a value.
+// Users who don't have the field don't match, except that users without a status are active
+// and users without roles have the user role.
+type FilterCondition struct {
+	Field string
+	Op    FilterOp
+	// Value is a time.Time for register_at and last_login and a string for the other fields
+	Value any
+	// Pos is the position of the field in the expression, starting from 1
+	Pos int
+}
+
+func (*FilterAnd) filterExpr()       {}
+func (*FilterOr) filterExpr()        {}
+func (*FilterNot) filterExpr()       {}
+func (*FilterCondition) filterExpr() {}
+
+// FilterError is a syntax error of a filter expression or a condition which isn't allowed
+type FilterError struct {
+	// Pos is the position of the character which the error is found at, starting from 1
+	Pos     int
+	Message string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Message, e.Pos)
+}
+
+// ParseUserFilter parses a filter expression of users such as
+// status:active AND (role:admin OR register_at>=2025-01-01) AND NOT locale:fa-IR
+//
+// Conditions are a field, an operator and a value. The fields are phone, register_at, last_login,
+// status, role, display_name, email and locale. Every field is compared by : which means equal,
+// register_at and last_login are compared by >, >=, < and <= as well. Values which have spaces or
+// parentheses are quoted with ", in which \" and \\ are escaped. Times are either dates in YYYY-MM-DD
+// format in the location, which are the whole day, or RFC 3339 datetimes. Unknown digits of phone
+// numbers can be masked by *, then the number must start with at least MinPhonePrefix digits.
+//
+// Conditions are joined by AND and OR, which are case insensitive, negated by NOT and grouped by
+// parentheses. NOT binds tighter than AND and AND binds tighter than OR.
+// It returns a *FilterError which points to the position of the first error.
+func ParseUserFilter(expr string, location *time.Location) (FilterExpr, error) {
+	if location == nil {
+		location = time.UTC
+	}
+	p := &filterParser{input: expr, location: location}
+	if len(expr) > MaxFilterLength {
+		return nil, p.errorAt(MaxFilterLength, fmt.Sprintf("expression is longer than %d bytes", MaxFilterLength))
+	}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.done() {
+		if p.input[p.pos] == ')' {
+			return nil, p.errorAt(p.pos, "unexpected )")
+		}
+		return nil, p.errorAt(p.pos, "expected AND or OR")
+	}
+	return node, nil
+}
+
+// filterParser is a recursive descent parser of filter expressions
+type filterParser struct {
+	input    string
+	location *time.Location
+	// pos is the offset of the next byte
+	pos int
+	// depth is the number of parentheses and NOTs which the parser is in
+	depth int
+}
+
+// errorAt returns an error at the byte offset, which is reported as a character position
+func (p *filterParser) errorAt(offset int, message string) *FilterError {
+	return &FilterError{Pos: p.position(offset), Message: message}
+}
+
+// position returns the character position of the byte offset, starting from 1
+func (p *filterParser) position(offset int) int {
+	return utf8.RuneCountInString(p.input[:offset]) + 1
+}
+
+func (p *filterParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func isFilterSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isFilterName(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+func (p *filterParser) skipSpace() {
+	for !p.done() && isFilterSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+// keyword consumes the keyword if it's the next word
+func (p *filterParser) keyword(word string) bool {
+	end := p.pos + len(word)
+	if end > len(p.input) || !strings.EqualFold(p.input[p.pos:end], word) {
+		return false
+	}
+	if end < len(p.input) && !isFilterSpace(p.input[end]) && p.input[end] != '(' {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+// next consumes the spaces and the keyword if the keyword is the next word
+func (p *filterParser) next(word string) bool {
+	start := p.pos
+	p.skipSpace()
+	if p.keyword(word) {
+		return true
+	}
+	p.pos = start
+	return false
+}
+
+// enter goes one level deeper into the expression at the byte offset
+func (p *filterParser) enter(offset int) error {
+	p.depth++
+	if p.depth > MaxFilterDepth {
+		return p.errorAt(offset, fmt.Sprintf("expression is nested deeper than %d levels", MaxFilterDepth))
+	}
+	return nil
+}
+
+// parseOr parses expressions which are joined by OR
+func (p *filterParser) parseOr() (FilterExpr, error) {
+	expr, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	exprs := []FilterExpr{expr}
+	for p.next("OR") {
+		if expr, err = p.parseAnd(); err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return &FilterOr{Exprs: exprs}, nil
+}
+
+// parseAnd parses expressions which are joined by AND
+func (p *filterParser) parseAnd() (FilterExpr, error) {
+	expr, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	exprs := []FilterExpr{expr}
+	for p.next("AND") {
+		if expr, err = p.parseUnary(); err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return &FilterAnd{Exprs: exprs}, nil
+}
+
+// parseUnary parses an expression in parentheses, a negated expression or a condition
+func (p *filterParser) parseUnary() (FilterExpr, error) {
+	p.skipSpace()
+	if p.done() {
+		return nil, p.errorAt(p.pos, "expected a condition")
+	}
+	start := p.pos
+	if p.input[p.pos] == '(' {
+		if err := p.enter(start); err != nil {
+			return nil, err
+		}
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.done() || p.input[p.pos] != ')' {
+			return nil, p.errorAt(p.pos, "expected )")
+		}
+		p.pos++
+		p.depth--
+		return expr, nil
+	}
+	if p.keyword("NOT") {
+		if err := p.enter(start); err != nil {
+			return nil, err
+		}
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		p.depth--
+		return &FilterNot{Expr: expr}, nil
+	}
+	return p.parseCondition()
+}
+
+// parseCondition parses a field, an operator and a value, and checks them against filterFields
+func (p *filterParser) parseCondition() (FilterExpr, error) {
+	start := p.pos
+	for !p.done() && isFilterName(p.input[p.pos]) {
+		p.pos++
+	}
+	name := p.input[start:p.pos]
+	if len(name) == 0 {
+		return nil, p.errorAt(start, "expected a field")
+	}
+	field, ok := filterFields[name]
+	if !ok {
+		return nil, p.errorAt(start, fmt.Sprintf("unknown field %q", name))
+	}
+
+	opStart := p.pos
+	op, ok := p.operator()
+	if !ok {
+		return nil, p.errorAt(opStart, "expected one of : > >= < <= after "+name)
+	}
+	if op != FilterEq && field.kind != filterTime {
+		return nil, p.errorAt(opStart, fmt.Sprintf("%s can only be compared by :", name))
+	}
+
+	valueStart := p.pos
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if len(value) == 0 {
+		return nil, p.errorAt(valueStart, "expected a value")
+	}
+	condition := &FilterCondition{Field: name, Op: op, Value: value, Pos: p.position(start)}
+	switch field.kind {
+	case filterEnum:
+		if !slices.Contains(field.values, value) {
+			last := len(field.values) - 1
+			return nil, p.errorAt(valueStart, fmt.Sprintf("%s must be one of %s and %s", name, strings.Join(field.values[:last], ", "), field.values[last]))
+		}
+	case filterPhone:
+		if _, err := newPhonePattern(value, false); err != nil {
+			return nil, p.errorAt(valueStart, fmt.Sprintf("phone must be digits which start with at least %d digits, unknown digits can be masked by *", MinPhonePrefix))
+		}
+	case filterTime:
+		if date, err := time.ParseInLocation("2006-01-02", value, p.location); err == nil {
+			return dateCondition(condition, date), nil
+		}
+		datetime, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, p.errorAt(valueStart, name+" must be a YYYY-MM-DD date or an RFC 3339 datetime")
+		}
+		condition.Value = datetime
+	}
+	return condition, nil
+}
+
+// operator consumes the next operator
+func (p *filterParser) operator() (FilterOp, bool) {
+	for _, op := range filterOps {
+		if strings.HasPrefix(p.input[p.pos:], string(op)) {
+			p.pos += len(op)
+			return op, true
+		}
+	}
+	return "", false
+}
+
+// value consumes a quoted string or the bytes before the next space or parenthesis
+func (p *filterParser) value() (string, error) {
+	start := p.pos
+	if p.done() || p.input[p.pos] != '"' {
+		for !p.done() && !isFilterSpace(p.input[p.pos]) && p.input[p.pos] != '(' && p.input[p.pos] != ')' {
+			p.pos++
+		}
+		return p.input[start:p.pos], nil
+	}
+	var value strings.Builder
+	for p.pos++; !p.done(); p.pos++ {
+		c := p.input[p.pos]
+		if c == '"' {
+			p.pos++
+			return value.String(), nil
+		}
+		// only quotes and backslashes are escaped
+		if c == '\\' && p.pos+1 < len(p.input) && (p.input[p.pos+1] == '"' || p.input[p.pos+1] == '\\') {
+			p.pos++
+			c = p.input[p.pos]
+		}
+		value.WriteByte(c)
+	}
+	return "", p.errorAt(start, "missing closing \"")
+}
+
+// dateCondition returns the condition of a date, which is the whole day in its location
+func dateCondition(condition *FilterCondition, date time.Time) FilterExpr {
+	next := date.AddDate(0, 0, 1)
+	compare := func(op FilterOp, value time.Time) *FilterCondition {
+		return &FilterCondition{Field: condition.Field, Op: op, Value: value, Pos: condition.Pos}
+	}
+	switch condition.Op {
+	case FilterGt:
+		return compare(FilterGte, next)
+	case FilterGte:
+		return compare(FilterGte, date)
+	case FilterLt:
+		return compare(FilterLt, date)
+	case FilterLte:
+		return compare(FilterLt, next)
+	}
+	return &FilterAnd{Exprs: []FilterExpr{compare(FilterGte, date), compare(FilterLt, next)}}
+}
+
+// checkFilter returns an error if the expression isn't one which ParseUserFilter could return,
+// since the fields and operators are put in queries
+func checkFilter(expr FilterExpr) error {
+	var exprs []FilterExpr
+	switch e := expr.(type) {
+	case *FilterAnd:
+		exprs = e.Exprs
+	case *FilterOr:
+		exprs = e.Exprs
+	case *FilterNot:
+		exprs = []FilterExpr{e.Expr}
+	case *FilterCondition:
+		field, ok := filterFields[e.Field]
+		if !ok {
+			return fmt.Errorf("unsupported filter field %q", e.Field)
+		}
+		if !slices.Contains(filterOps, e.Op) || (e.Op != FilterEq && field.kind != filterTime) {
+			return fmt.Errorf("unsupported filter operator %q of %s", e.Op, e.Field)
+		}
+		_, isTime := e.Value.(time.Time)
+		value, isString := e.Value.(string)
+		if isTime != (field.kind == filterTime) || isString == isTime {
+			return fmt.Errorf("invalid filter value of %s", e.Field)
+		}
+		if field.kind == filterPhone {
+			if _, err := newPhonePattern(value, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return errors.New("unsupported filter expression")
+	}
+	if len(exprs) == 0 {
+		return errors.New("empty filter expression")
+	}
+	for _, e := range exprs {
+		if err := checkFilter(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SearchUserByFilter filters users by an expression of ParseUserFilter,
+// which is joined with the other filters by AND
+func SearchUserByFilter(expr FilterExpr) SearchUserOption {
+	return func(suo *searchUserOption) {
+		suo.filter = expr
+	}
+}
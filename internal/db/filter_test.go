@@ -0,0 +1,74 @@
+package db_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aph138/dekamond/internal/db"
+)
+
+func TestParseUserFilter(t *testing.T) {
+	tehran, err := time.LoadLocation("Asia/Tehran")
+	if err != nil {
+		t.Fatalf("err when loading location %s", err.Error())
+	}
+	expr, err := db.ParseUserFilter(`status:active and (role:admin OR display_name:"Ali \"R\"") AND NOT register_at>2025-01-01`, tehran)
+	if err != nil {
+		t.Fatalf("err when parsing filter %s", err.Error())
+	}
+	and, ok := expr.(*db.FilterAnd)
+	if !ok || len(and.Exprs) != 3 {
+		t.Fatalf("expected AND of 3 expressions but got %#v", expr)
+	}
+	if status, ok := and.Exprs[0].(*db.FilterCondition); !ok || status.Field != "status" || status.Value != "active" || status.Pos != 1 {
+		t.Fatalf("unexpected status condition %#v", and.Exprs[0])
+	}
+	or, ok := and.Exprs[1].(*db.FilterOr)
+	if !ok || len(or.Exprs) != 2 {
+		t.Fatalf("expected OR of 2 expressions but got %#v", and.Exprs[1])
+	}
+	if name, ok := or.Exprs[1].(*db.FilterCondition); !ok || name.Value != `Ali "R"` || name.Pos != 34 {
+		t.Fatalf("unexpected display_name condition %#v", or.Exprs[1])
+	}
+	// a date is the whole day in the location, so after the date is from the next day
+	not, ok := and.Exprs[2].(*db.FilterNot)
+	if !ok {
+		t.Fatalf("expected NOT but got %#v", and.Exprs[2])
+	}
+	register, ok := not.Expr.(*db.FilterCondition)
+	if !ok || register.Op != db.FilterGte || register.Value != time.Date(2025, 1, 2, 0, 0, 0, 0, tehran) {
+		t.Fatalf("unexpected register_at condition %#v", not.Expr)
+	}
+
+	tests := []struct {
+		expr    string
+		message string
+		pos     int
+	}{
+		{"", "expected a condition", 1},
+		{"status:active AND", "expected a condition", 18},
+		{"status:active role:admin", "expected AND or OR", 15},
+		{"(status:active", "expected )", 15},
+		{"status:active)", "unexpected )", 14},
+		{"roles:admin", `unknown field "roles"`, 1},
+		{"status=active", "expected one of : > >= < <= after status", 7},
+		{"status>=active", "status can only be compared by :", 7},
+		{"status:", "expected a value", 8},
+		{"status:gone", "status must be one of active, suspended, banned and deleted", 8},
+		{"role:admin AND register_at>=yesterday", "register_at must be a YYYY-MM-DD date or an RFC 3339 datetime", 29},
+		{"phone:09*", "phone must be digits which start with at least 7 digits, unknown digits can be masked by *", 7},
+		{`display_name:"Ali`, `missing closing "`, 14},
+		// positions count characters, not bytes
+		{`display_name:"علی" OR :x`, "expected a field", 23},
+		{strings.Repeat("NOT ", db.MaxFilterDepth+1) + "role:admin", "expression is nested deeper than 20 levels", 81},
+		{strings.Repeat("x", db.MaxFilterLength+1), "expression is longer than 1000 bytes", 1001},
+	}
+	for _, test := range tests {
+		_, err := db.ParseUserFilter(test.expr, time.UTC)
+		filterErr, ok := err.(*db.FilterError)
+		if !ok || filterErr.Message != test.message || filterErr.Pos != test.pos {
+			t.Fatalf("%s: expected %q at %d but got %v", test.expr, test.message, test.pos, err)
+		}
+	}
+}
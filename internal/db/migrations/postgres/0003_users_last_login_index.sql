@@ -0,0 +1,5 @@
+-- search results put NULL values first in ascending order and last in descending order like mongodb,
+-- so indexes are created with NULLS FIRST to be scanned in both directions
+DROP INDEX users_register_at_id_idx;
+CREATE INDEX users_register_at_id_idx ON users (register_at NULLS FIRST, id);
+CREATE INDEX users_last_login_id_idx ON users (last_login NULLS FIRST, id);
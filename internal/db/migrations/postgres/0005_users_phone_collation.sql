@@ -0,0 +1,4 @@
+-- partial phone searches compare ranges such as phone < '0912345:', which only match the digits
+-- in byte order, so the column doesn't depend on the collation of the database.
+-- The phone index is rebuilt with the column.
+ALTER TABLE users ALTER COLUMN phone TYPE TEXT COLLATE "C";
@@ -0,0 +1,56 @@
+-- times are stored as UTC text in the same format, so they are compared correctly as strings
+CREATE TABLE users (
+    id CHAR(24) PRIMARY KEY,
+    phone TEXT NOT NULL,
+    register_at TIMESTAMP NOT NULL,
+    last_login TIMESTAMP,
+    display_name TEXT NOT NULL DEFAULT '',
+    email TEXT NOT NULL DEFAULT '',
+    locale TEXT NOT NULL DEFAULT '',
+    avatar_url TEXT NOT NULL DEFAULT '',
+    -- JSON array of role names
+    roles TEXT NOT NULL DEFAULT '["user"]',
+    status TEXT NOT NULL DEFAULT 'active'
+);
+CREATE UNIQUE INDEX users_phone_idx ON users (phone);
+CREATE INDEX users_register_at_idx ON users (register_at);
+CREATE INDEX users_status_idx ON users (status);
+
+CREATE TABLE oauth_clients (
+    id TEXT PRIMARY KEY,
+    secret_hash TEXT NOT NULL DEFAULT '',
+    name TEXT NOT NULL,
+    -- JSON array of redirect URIs
+    redirect_uris TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE sessions (
+    id CHAR(24) PRIMARY KEY,
+    user_id CHAR(24) NOT NULL,
+    device_name TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
+CREATE INDEX sessions_expires_at_idx ON sessions (expires_at);
+
+CREATE TABLE login_events (
+    id CHAR(24) PRIMARY KEY,
+    user_id CHAR(24),
+    phone TEXT NOT NULL,
+    type TEXT NOT NULL,
+    channel TEXT NOT NULL,
+    outcome TEXT NOT NULL,
+    ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    latency_ms INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+CREATE INDEX login_events_user_id_idx ON login_events (user_id, created_at DESC);
+CREATE INDEX login_events_phone_idx ON login_events (phone, created_at DESC);
+CREATE INDEX login_events_expires_at_idx ON login_events (expires_at);
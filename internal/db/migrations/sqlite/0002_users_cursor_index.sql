@@ -0,0 +1,3 @@
+-- search results are sorted by register time and ID and paginated with a cursor on both
+DROP INDEX users_register_at_idx;
+CREATE INDEX users_register_at_id_idx ON users (register_at, id);
@@ -0,0 +1,2 @@
+-- NULL values are the smallest in sqlite, so the index is scanned in both directions
+CREATE INDEX users_last_login_id_idx ON users (last_login, id);
@@ -0,0 +1,11 @@
+CREATE TABLE audit_log (
+    id CHAR(24) PRIMARY KEY,
+    actor_id CHAR(24) NOT NULL,
+    action TEXT NOT NULL,
+    target_id TEXT NOT NULL DEFAULT '',
+    -- JSON object of strings
+    details TEXT NOT NULL DEFAULT '{}',
+    ip TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX audit_log_created_at_idx ON audit_log (created_at DESC, id DESC);
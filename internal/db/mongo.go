@@ -2,7 +2,9 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aph138/dekamond/internal/entity"
@@ -13,7 +15,11 @@ import (
 )
 
 const (
-	UserCollection = "user"
+	UserCollection       = "user"
+	ClientCollection     = "oauth_client"
+	SessionCollection    = "session"
+	LoginEventCollection = "login_events"
+	AuditCollection      = "audit_log"
 )
 
 // MyMongo defines a helper struct for connecting to mongodb database
@@ -22,9 +28,27 @@ type MyMongo struct {
 	timeout time.Duration
 }
 
+type mongoSettings struct {
+	autoMigrate bool
+}
+
+type MongoOption func(*mongoSettings)
+
+// WithAutoMigrate decides whether pending migrations are applied when connecting, it's enabled by default.
+// If it's disabled, migrations should be applied by Migrate before the database is used.
+func WithAutoMigrate(enabled bool) MongoOption {
+	return func(s *mongoSettings) {
+		s.autoMigrate = enabled
+	}
+}
+
 // Timeout is used as a global timeout for all of the operations for more convenience.
 // In a real world scenario each operation should have its own timeout.
-func NewMongo(address, name string, timeout time.Duration, opt *options.ClientOptions) (*MyMongo, error) {
+func NewMongo(address, name string, timeout time.Duration, opt *options.ClientOptions, mongoOpts ...MongoOption) (*MyMongo, error) {
+	settings := mongoSettings{autoMigrate: true}
+	for _, o := range mongoOpts {
+		o(&settings)
+	}
 	if opt == nil {
 		opt = options.Client().ApplyURI(address)
 	} else {
@@ -41,36 +65,19 @@ func NewMongo(address, name string, timeout time.Duration, opt *options.ClientOp
 	if err := client.Ping(ctx, readpref.Primary()); err != nil {
 		return nil, fmt.Errorf("err when pinging db: %w", err)
 	}
-	db := client.Database(name)
-	if err := createIndices(db); err != nil {
-		return nil, fmt.Errorf("err when creating indices: %w", err)
-	}
-	return &MyMongo{
-		db:      db,
+	d := &MyMongo{
+		db:      client.Database(name),
 		timeout: timeout,
-	}, nil
-}
-
-// create index for phone and register_at field to improving performance when searching
-func createIndices(db *mongo.Database) error {
-	userPhoneIndexModel := mongo.IndexModel{
-		Keys:    bson.D{{Key: "phone", Value: 1}},
-		Options: options.Index().SetUnique(true),
-	}
-	_, err := db.Collection(UserCollection).Indexes().CreateOne(context.Background(), userPhoneIndexModel)
-	if err != nil {
-		return fmt.Errorf("err when creating user phone index: %w", err)
 	}
-	userRegisterIndexModel := mongo.IndexModel{
-		Keys:    bson.D{{Key: "register_at", Value: 1}},
-		Options: options.Index(),
-	}
-	_, err = db.Collection(UserCollection).Indexes().CreateOne(context.Background(), userRegisterIndexModel)
-	if err != nil {
-		return fmt.Errorf("err when creating user register index: %w", err)
+	if settings.autoMigrate {
+		// migrations may take longer than timeout
+		if _, err := d.Migrate(context.Background()); err != nil {
+			return nil, fmt.Errorf("err when migrating db: %w", err)
+		}
 	}
-	return nil
+	return d, nil
 }
+
 func (d *MyMongo) InsertOne(col string, doc any, opts ...options.Lister[options.InsertOneOptions]) (*bson.ObjectID, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
 	defer cancel()
@@ -78,8 +85,11 @@ func (d *MyMongo) InsertOne(col string, doc any, opts ...options.Lister[options.
 	if err != nil {
 		return nil, fmt.Errorf("err when inserting one to %s: %w", col, err)
 	}
-	id := result.InsertedID.(*bson.ObjectID)
-	return id, nil
+	id, ok := result.InsertedID.(bson.ObjectID)
+	if !ok {
+		return nil, fmt.Errorf("err when inserting one to %s: _id is not an ObjectID", col)
+	}
+	return &id, nil
 }
 func (d *MyMongo) FindOne(col string, filter, output any, opts ...options.Lister[options.FindOneOptions]) error {
 	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
@@ -106,56 +116,389 @@ func (d *MyMongo) UpdateOne(col string, filter, query any, opts ...options.Liste
 
 }
 
-func (d *MyMongo) SaveUser(phone string) (string, error) {
+func (d *MyMongo) SaveUser(phone string) (*entity.User, error) {
 	filter := bson.M{"phone": phone}
 	upsertQuery := bson.M{
-		"$setOnInsert": entity.User{Phone: phone, RegisteredAt: time.Now()},
+		"$setOnInsert": entity.User{
+			Phone:        phone,
+			RegisteredAt: time.Now(),
+			Roles:        []string{entity.RoleUser},
+			Status:       entity.StatusActive,
+		},
 		"$set": bson.M{
 			"last_login": time.Now(),
 		},
 	}
-	result, err := d.UpdateOne(UserCollection, filter, upsertQuery, options.UpdateOne().SetUpsert(true))
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	var user entity.User
+	err := d.db.Collection(UserCollection).
+		FindOneAndUpdate(ctx, filter, upsertQuery, options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).
+		Decode(&user)
 	if err != nil {
-		return "", fmt.Errorf("err when upserting user with mongodb: %w", err)
+		return nil, fmt.Errorf("err when upserting user with mongodb: %w", err)
 	}
-	if result.UpsertedCount > 0 {
-		return result.UpsertedID.(bson.ObjectID).Hex(), nil
-	} else {
-		var user entity.User
-		err := d.FindOne(UserCollection, bson.M{"phone": phone}, &user)
-		if err != nil {
-			return "", fmt.Errorf("err when finding user in save method with mongodb: %w", err)
+	return &user, nil
+}
+
+func (d *MyMongo) ImportUsers(users []UserImport) ([]bool, error) {
+	created := make([]bool, len(users))
+	if len(users) == 0 {
+		return created, nil
+	}
+	now := time.Now()
+	models := make([]mongo.WriteModel, 0, len(users))
+	for _, user := range users {
+		registeredAt := user.RegisteredAt
+		if registeredAt.IsZero() {
+			registeredAt = now
 		}
-		return user.ID.Hex(), nil
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"phone": user.Phone}).
+			SetUpdate(bson.M{"$setOnInsert": entity.User{
+				Phone:        user.Phone,
+				RegisteredAt: registeredAt,
+				Roles:        []string{entity.RoleUser},
+				Status:       entity.StatusActive,
+			}}).
+			SetUpsert(true))
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	// unordered writes don't stop at the first failure
+	result, err := d.db.Collection(UserCollection).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil && !onlyDuplicateKeyErrors(err) {
+		return nil, fmt.Errorf("err when importing users with mongodb: %w", err)
+	}
+	for i := range result.UpsertedIDs {
+		created[i] = true
+	}
+	return created, nil
 }
 
-func (d *MyMongo) SearchUser(opts ...SearchUserOption) ([]entity.User, error) {
-	var result []entity.User
-	option := &searchUserOption{
-		pagination: searchUserPagination{
-			limit: 10,
-			page:  1,
-		},
+// onlyDuplicateKeyErrors reports whether every write of a bulk write which failed, failed because of a unique index.
+// Two upserts of the same phone number may run at the same time, then one of them fails and the user exists.
+func onlyDuplicateKeyErrors(err error) bool {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil || len(bulkErr.WriteErrors) == 0 {
+		return false
 	}
-	// apply options
-	for _, opt := range opts {
-		opt(option)
+	for _, writeErr := range bulkErr.WriteErrors {
+		if !writeErr.HasErrorCode(11000) {
+			return false
+		}
 	}
-	findOption := options.Find().
-		SetSkip(option.pagination.limit * (option.pagination.page - 1)).
-		SetLimit(option.pagination.limit).
-		SetSort(bson.D{bson.E{Key: "register_at", Value: -1}})
+	return true
+}
+
+// findOneAndUpdateUser applies the query to the user with the ID and returns the updated user
+func (d *MyMongo) findOneAndUpdateUser(id string, query any) (*entity.User, error) {
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	var user entity.User
+	err = d.db.Collection(UserCollection).
+		FindOneAndUpdate(ctx, bson.M{"_id": objectID}, query, options.FindOneAndUpdate().SetReturnDocument(options.After)).
+		Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("err when updating user with mongodb: %w", err)
+	}
+	return &user, nil
+}
+
+func (d *MyMongo) FindUser(id string) (*entity.User, error) {
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	var user entity.User
+	if err := d.FindOne(UserCollection, bson.M{"_id": objectID}, &user); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("err when finding user with mongodb: %w", err)
+	}
+	return &user, nil
+}
+
+func (d *MyMongo) UpdateUser(id string, update UserUpdate) (*entity.User, error) {
+	set := bson.M{}
+	unset := bson.M{}
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{"display_name", update.DisplayName},
+		{"email", update.Email},
+		{"locale", update.Locale},
+		{"avatar_url", update.AvatarURL},
+	}
+	for _, f := range fields {
+		if f.value == nil {
+			continue
+		}
+		if len(*f.value) == 0 {
+			unset[f.name] = ""
+		} else {
+			set[f.name] = *f.value
+		}
+	}
+	if update.Phone != nil && len(*update.Phone) > 0 {
+		set["phone"] = *update.Phone
+	}
+	if len(set) == 0 && len(unset) == 0 {
+		return d.FindUser(id)
+	}
+	query := bson.M{}
+	if len(set) > 0 {
+		query["$set"] = set
+	}
+	if len(unset) > 0 {
+		query["$unset"] = unset
+	}
+	// the unique phone index rejects the change if the number is taken
+	user, err := d.findOneAndUpdateUser(id, query)
+	if err != nil && mongo.IsDuplicateKeyError(err) {
+		return nil, ErrDuplicate
+	}
+	return user, err
+}
+
+func (d *MyMongo) UpdateUserPhone(id, phone string) (*entity.User, error) {
+	// the unique phone index rejects the change if the number is taken
+	user, err := d.findOneAndUpdateUser(id, bson.M{"$set": bson.M{"phone": phone}})
+	if err != nil && mongo.IsDuplicateKeyError(err) {
+		return nil, ErrDuplicate
+	}
+	return user, err
+}
+
+func (d *MyMongo) AddUserRole(id, role string) (*entity.User, error) {
+	// users without roles field have the user role implicitly
+	query := bson.A{
+		bson.M{"$set": bson.M{"roles": bson.M{"$setUnion": bson.A{
+			bson.M{"$ifNull": bson.A{"$roles", bson.A{entity.RoleUser}}},
+			bson.A{role},
+		}}}},
+	}
+	return d.findOneAndUpdateUser(id, query)
+}
+
+func (d *MyMongo) RemoveUserRole(id, role string) (*entity.User, error) {
+	query := bson.A{
+		bson.M{"$set": bson.M{"roles": bson.M{"$setDifference": bson.A{
+			bson.M{"$ifNull": bson.A{"$roles", bson.A{entity.RoleUser}}},
+			bson.A{role},
+		}}}},
+	}
+	return d.findOneAndUpdateUser(id, query)
+}
+
+func (d *MyMongo) GrantRoleByPhone(phone, role string) (*entity.User, error) {
+	filter := bson.M{"phone": phone}
+	query := bson.M{
+		"$setOnInsert": bson.M{"register_at": time.Now()},
+		"$addToSet":    bson.M{"roles": bson.M{"$each": bson.A{entity.RoleUser, role}}},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	var user entity.User
+	err := d.db.Collection(UserCollection).
+		FindOneAndUpdate(ctx, filter, query, options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).
+		Decode(&user)
+	if err != nil {
+		return nil, fmt.Errorf("err when granting role by phone with mongodb: %w", err)
+	}
+	return &user, nil
+}
+
+func (d *MyMongo) SetUserStatus(id, status string) (*entity.User, error) {
+	return d.findOneAndUpdateUser(id, bson.M{"$set": bson.M{"status": status}})
+}
+
+func (d *MyMongo) DeleteUser(id string) error {
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	result, err := d.db.Collection(UserCollection).DeleteOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		return fmt.Errorf("err when deleting user with mongodb: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// timeRange returns the filter of a time range, a nil bound leaves the range open on that side
+func timeRange(from, to *time.Time) bson.M {
+	filter := bson.M{}
+	if from != nil {
+		filter["$gte"] = from
+	}
+	if to != nil {
+		filter["$lte"] = to
+	}
+	return filter
+}
+
+// userFilter returns the filter of the search options, without the cursor
+func userFilter(option *searchUserOption) bson.M {
 	filter := bson.M{}
 	if len(option.phone) > 0 {
 		filter["phone"] = option.phone
+	} else if pattern := option.phonePattern; len(pattern.value) > 0 {
+		filter["phone"] = phonePatternFilter(pattern)
 	}
 	if option.registerFrom != nil || option.registerTO != nil {
-		filter["register_at"] = bson.M{
-			"$gte": option.registerFrom,
-			"$lte": option.registerTO,
+		filter["register_at"] = timeRange(option.registerFrom, option.registerTO)
+	}
+	if option.lastLoginFrom != nil || option.lastLoginTo != nil {
+		filter["last_login"] = timeRange(option.lastLoginFrom, option.lastLoginTo)
+	}
+	if len(option.status) > 0 {
+		if option.status == entity.StatusActive {
+			// users which are registered before statuses were introduced are active
+			filter["status"] = bson.M{"$in": bson.A{entity.StatusActive, nil}}
+		} else {
+			filter["status"] = option.status
 		}
 	}
+	if option.filter != nil {
+		// the expression may have its own $or
+		filter["$and"] = bson.A{filterQuery(option.filter)}
+	}
+	return filter
+}
+
+// phonePatternFilter returns the filter of the phone field of a phone pattern
+func phonePatternFilter(pattern phonePattern) bson.M {
+	// the range is found by the phone index and the regex only checks the numbers in it
+	phone := bson.M{"$gte": pattern.from, "$lt": pattern.to}
+	if !pattern.prefix {
+		phone["$regex"] = "^" + strings.ReplaceAll(pattern.value, "*", "[0-9]") + "$"
+	}
+	return phone
+}
+
+// mongoFilterOps maps the operators of filter expressions to query operators
+var mongoFilterOps = map[FilterOp]string{
+	FilterEq:  "$eq",
+	FilterGt:  "$gt",
+	FilterGte: "$gte",
+	FilterLt:  "$lt",
+	FilterLte: "$lte",
+}
+
+// filterQuery returns the filter of an expression which is checked by checkFilter.
+// Comparisons don't match missing fields, so negated conditions match them.
+func filterQuery(expr FilterExpr) bson.M {
+	switch e := expr.(type) {
+	case *FilterAnd:
+		return bson.M{"$and": filterQueries(e.Exprs)}
+	case *FilterOr:
+		return bson.M{"$or": filterQueries(e.Exprs)}
+	case *FilterNot:
+		return bson.M{"$nor": bson.A{filterQuery(e.Expr)}}
+	}
+	condition := expr.(*FilterCondition)
+	field := filterFields[condition.Field]
+	if field.kind == filterTime {
+		return bson.M{field.column: bson.M{mongoFilterOps[condition.Op]: condition.Value}}
+	}
+	value := condition.Value.(string)
+	switch {
+	case field.kind == filterPhone && strings.Contains(value, "*"):
+		pattern, _ := newPhonePattern(value, false)
+		return bson.M{"phone": phonePatternFilter(pattern)}
+	case condition.Field == "status" && value == entity.StatusActive:
+		// users which are registered before statuses were introduced are active
+		return bson.M{"status": bson.M{"$in": bson.A{entity.StatusActive, nil}}}
+	case condition.Field == "role" && value == entity.RoleUser:
+		// users which are registered before roles were introduced have only the user role
+		return bson.M{"roles": bson.M{"$in": bson.A{entity.RoleUser, nil, bson.A{}}}}
+	}
+	// arrays of roles match if they have the role
+	return bson.M{field.column: value}
+}
+
+func filterQueries(exprs []FilterExpr) bson.A {
+	filters := bson.A{}
+	for _, expr := range exprs {
+		filters = append(filters, filterQuery(expr))
+	}
+	return filters
+}
+
+// cursorFilter returns the filter of the users after the cursor.
+// Missing values are less than any other value in mongodb, so they are the last in descending order.
+func cursorFilter(c *userCursor) bson.M {
+	field := c.sort.field
+	op := "$gt"
+	if c.sort.desc {
+		op = "$lt"
+	}
+	afterID := bson.M{op: c.id}
+	if c.value == nil {
+		if c.sort.desc {
+			return bson.M{field: nil, "_id": afterID}
+		}
+		return bson.M{"$or": bson.A{
+			bson.M{field: nil, "_id": afterID},
+			bson.M{field: bson.M{"$ne": nil}},
+		}}
+	}
+	after := bson.A{
+		bson.M{field: bson.M{op: *c.value}},
+		bson.M{field: *c.value, "_id": afterID},
+	}
+	if c.sort.desc {
+		after = append(after, bson.M{field: nil})
+	}
+	return bson.M{"$or": after}
+}
+
+func (d *MyMongo) SearchUser(opts ...SearchUserOption) (*UserPage, error) {
+	var result []entity.User
+	option, err := newSearchUserOption(opts)
+	if err != nil {
+		return nil, err
+	}
+	filter := userFilter(option)
+	var total *int64
+	if option.total {
+		count, err := d.Count(UserCollection, filter)
+		if err != nil {
+			return nil, err
+		}
+		total = &count
+	}
+	// one more user is fetched to know if there is a next page
+	findOption := options.Find().
+		SetLimit(option.pagination.limit + 1).
+		SetSort(userSort(option.sort))
+	if len(option.fields) > 0 {
+		findOption.SetProjection(userProjection(option))
+	}
+	if len(option.cursor) > 0 {
+		cursor, err := decodeUserCursor(option.cursor, option.sort)
+		if err != nil {
+			return nil, err
+		}
+		// filters may have their own $or
+		filter = bson.M{"$and": bson.A{filter, cursorFilter(cursor)}}
+	} else {
+		findOption.SetSkip(option.pagination.limit * (option.pagination.page - 1))
+	}
 	cursor, err := d.db.Collection(UserCollection).Find(context.Background(), filter, findOption)
 	if err != nil {
 		return nil, fmt.Errorf("err when finding from db %w", err)
@@ -168,6 +511,320 @@ func (d *MyMongo) SearchUser(opts ...SearchUserOption) ([]entity.User, error) {
 		}
 		result = append(result, user)
 	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("err when reading result %w", err)
+	}
+	page := newUserPage(result, option)
+	page.Total = total
+	return page, nil
+}
+
+// userProjection returns the projection of the fields of the search options
+func userProjection(option *searchUserOption) bson.M {
+	// cursors need the ID and the sort field
+	projection := bson.M{"_id": 1, option.sort.field: 1}
+	for _, field := range option.fields {
+		// bson names are the same as JSON names except the ID
+		if field != "id" {
+			projection[field] = 1
+		}
+	}
+	return projection
+}
+
+// userSort returns the sort document of search results, users with the same value are sorted by ID
+func userSort(sort searchUserSort) bson.D {
+	direction := 1
+	if sort.desc {
+		direction = -1
+	}
+	return bson.D{bson.E{Key: sort.field, Value: direction}, bson.E{Key: "_id", Value: direction}}
+}
+
+// exportBatchSize is the number of users which ExportUsers reads from the database at once
+const exportBatchSize = 1000
+
+func (d *MyMongo) ExportUsers(ctx context.Context, fn func(entity.User) error, opts ...SearchUserOption) error {
+	option, err := newSearchUserOption(opts)
+	if err != nil {
+		return err
+	}
+	findOption := options.Find().SetSort(userSort(option.sort)).SetBatchSize(exportBatchSize)
+	cursor, err := d.db.Collection(UserCollection).Find(ctx, userFilter(option), findOption)
+	if err != nil {
+		return fmt.Errorf("err when finding from db %w", err)
+	}
+	defer cursor.Close(context.Background())
+	for cursor.Next(ctx) {
+		var user entity.User
+		if err := cursor.Decode(&user); err != nil {
+			return fmt.Errorf("err when decoding result %w", err)
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("err when reading result %w", err)
+	}
+	return nil
+}
+
+// intervalCount is the number of users in the bucket which starts at Start
+type intervalCount struct {
+	Start time.Time `bson:"_id"`
+	Count int64     `bson:"count"`
+}
+
+func (d *MyMongo) UserStats(interval string, from, to time.Time, location *time.Location) ([]UserStatsBucket, error) {
+	stats, err := newUserStats(interval, from, to, location)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	registrations, err := d.countUsersByInterval(ctx, stats, "register_at")
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range registrations {
+		if bucket := stats.bucket(c.Start); bucket != nil {
+			bucket.Registrations = c.Count
+		}
+	}
+	activeUsers, err := d.countUsersByInterval(ctx, stats, "last_login")
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range activeUsers {
+		if bucket := stats.bucket(c.Start); bucket != nil {
+			bucket.ActiveUsers = c.Count
+		}
+	}
+	return stats.buckets, nil
+}
+
+// countUsersByInterval groups the users whose time field is in the range of the buckets by the interval of the field
+func (d *MyMongo) countUsersByInterval(ctx context.Context, stats *userStats, field string) ([]intervalCount, error) {
+	trunc := bson.M{"date": "$" + field, "unit": stats.interval, "timezone": stats.location.String()}
+	if stats.interval == IntervalWeek {
+		trunc["startOfWeek"] = "monday"
+	}
+	pipeline := bson.A{
+		// the range is found by the index of the field
+		bson.M{"$match": bson.M{field: bson.M{"$gte": stats.start, "$lt": stats.end}}},
+		bson.M{"$group": bson.M{"_id": bson.M{"$dateTrunc": trunc}, "count": bson.M{"$sum": 1}}},
+	}
+	cursor, err := d.db.Collection(UserCollection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("err when counting users by %s with mongodb: %w", field, err)
+	}
+	result := []intervalCount{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, fmt.Errorf("err when decoding user counts %w", err)
+	}
+	return result, nil
+}
+
+func (d *MyMongo) SaveClient(client *entity.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	if _, err := d.db.Collection(ClientCollection).InsertOne(ctx, client); err != nil {
+		return fmt.Errorf("err when inserting client with mongodb: %w", err)
+	}
+	return nil
+}
+
+func (d *MyMongo) FindClient(id string) (*entity.Client, error) {
+	var client entity.Client
+	if err := d.FindOne(ClientCollection, bson.M{"_id": id}, &client); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("err when finding client with mongodb: %w", err)
+	}
+	return &client, nil
+}
+
+func (d *MyMongo) CreateSession(session *entity.Session) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	result, err := d.db.Collection(SessionCollection).InsertOne(ctx, session)
+	if err != nil {
+		return "", fmt.Errorf("err when inserting session with mongodb: %w", err)
+	}
+	return result.InsertedID.(bson.ObjectID).Hex(), nil
+}
+
+func (d *MyMongo) FindSession(id string) (*entity.Session, error) {
+	sessionID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	// TTL monitor runs periodically, so expired sessions may still exist
+	filter := bson.M{"_id": sessionID, "expires_at": bson.M{"$gt": time.Now()}}
+	var session entity.Session
+	if err := d.FindOne(SessionCollection, filter, &session); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("err when finding session with mongodb: %w", err)
+	}
+	return &session, nil
+}
+
+func (d *MyMongo) ListSessions(userID string) ([]entity.Session, error) {
+	objectID, err := bson.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	filter := bson.M{"user_id": objectID, "expires_at": bson.M{"$gt": time.Now()}}
+	findOption := options.Find().SetSort(bson.D{bson.E{Key: "last_seen_at", Value: -1}})
+	cursor, err := d.db.Collection(SessionCollection).Find(ctx, filter, findOption)
+	if err != nil {
+		return nil, fmt.Errorf("err when finding sessions from db %w", err)
+	}
+	result := []entity.Session{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, fmt.Errorf("err when decoding sessions %w", err)
+	}
+	return result, nil
+}
+
+func (d *MyMongo) TouchSession(id, userID string) error {
+	sessionID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrNotFound
+	}
+	userObjectID, err := bson.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrNotFound
+	}
+	filter := bson.M{"_id": sessionID, "user_id": userObjectID, "expires_at": bson.M{"$gt": time.Now()}}
+	result, err := d.UpdateOne(SessionCollection, filter, bson.M{"$set": bson.M{"last_seen_at": time.Now()}})
+	if err != nil {
+		return fmt.Errorf("err when updating session with mongodb: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (d *MyMongo) DeleteSession(id, userID string) error {
+	sessionID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrNotFound
+	}
+	userObjectID, err := bson.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	result, err := d.db.Collection(SessionCollection).DeleteOne(ctx, bson.M{"_id": sessionID, "user_id": userObjectID})
+	if err != nil {
+		return fmt.Errorf("err when deleting session with mongodb: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (d *MyMongo) DeleteUserSessions(userID string) error {
+	objectID, err := bson.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	if _, err := d.db.Collection(SessionCollection).DeleteMany(ctx, bson.M{"user_id": objectID}); err != nil {
+		return fmt.Errorf("err when deleting user sessions with mongodb: %w", err)
+	}
+	return nil
+}
+
+func (d *MyMongo) SaveLoginEvent(event *entity.LoginEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	if _, err := d.db.Collection(LoginEventCollection).InsertOne(ctx, event); err != nil {
+		return fmt.Errorf("err when inserting login event with mongodb: %w", err)
+	}
+	return nil
+}
+
+// loginEventFilter matches the events of either the user ID or the phone number since the time
+func loginEventFilter(userID, phone string, since time.Time) bson.M {
+	or := bson.A{}
+	if objectID, err := bson.ObjectIDFromHex(userID); err == nil {
+		or = append(or, bson.M{"user_id": objectID})
+	}
+	if len(phone) > 0 {
+		// events of the number before it belonged to the user, such as OTP requests
+		or = append(or, bson.M{"phone": phone, "user_id": bson.M{"$exists": false}, "created_at": bson.M{"$gte": since}})
+	}
+	if len(or) == 0 {
+		// nothing can match
+		return bson.M{"_id": bson.M{"$exists": false}}
+	}
+	return bson.M{"$or": or}
+}
+
+func (d *MyMongo) ListLoginEvents(userID, phone string, since time.Time, limit int64) ([]entity.LoginEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	// events of one request may have the same time
+	findOption := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+	if limit > 0 {
+		findOption.SetLimit(limit)
+	}
+	cursor, err := d.db.Collection(LoginEventCollection).Find(ctx, loginEventFilter(userID, phone, since), findOption)
+	if err != nil {
+		return nil, fmt.Errorf("err when finding login events from db %w", err)
+	}
+	result := []entity.LoginEvent{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, fmt.Errorf("err when decoding login events %w", err)
+	}
+	return result, nil
+}
+
+func (d *MyMongo) DeleteLoginEvents(userID, phone string, since time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	if _, err := d.db.Collection(LoginEventCollection).DeleteMany(ctx, loginEventFilter(userID, phone, since)); err != nil {
+		return fmt.Errorf("err when deleting login events with mongodb: %w", err)
+	}
+	return nil
+}
+
+func (d *MyMongo) SaveAuditRecord(record *entity.AuditRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	if _, err := d.db.Collection(AuditCollection).InsertOne(ctx, record); err != nil {
+		return fmt.Errorf("err when inserting audit record with mongodb: %w", err)
+	}
+	return nil
+}
+
+func (d *MyMongo) ListAuditRecords(limit int64) ([]entity.AuditRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	findOption := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+	if limit > 0 {
+		findOption.SetLimit(limit)
+	}
+	cursor, err := d.db.Collection(AuditCollection).Find(ctx, bson.M{}, findOption)
+	if err != nil {
+		return nil, fmt.Errorf("err when finding audit records from db %w", err)
+	}
+	result := []entity.AuditRecord{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, fmt.Errorf("err when decoding audit records %w", err)
+	}
 	return result, nil
 }
 
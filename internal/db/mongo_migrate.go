@@ -0,0 +1,300 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aph138/dekamond/internal/entity"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	MigrationCollection     = "schema_migrations"
+	MigrationLockCollection = "schema_migrations_lock"
+
+	// a lock which isn't refreshed for this period belongs to a crashed process and is taken over
+	migrationLockTTL = time.Minute * 5
+	// time between attempts when another process holds the lock
+	migrationLockRetry = time.Millisecond * 500
+	// time between refreshes of the lock while the steps are running
+	migrationLockRefresh = migrationLockTTL / 5
+)
+
+// mongoMigration is a versioned step which changes the schema or the data.
+// Steps are recorded after they succeed, but a step which fails halfway is run again,
+// so every step must be safe to run more than once.
+type mongoMigration struct {
+	version int
+	name    string
+	up      func(context.Context, *mongo.Database) error
+}
+
+func (m mongoMigration) String() string {
+	return fmt.Sprintf("%04d_%s", m.version, m.name)
+}
+
+// mongoMigrations lists every step in order. Released steps must never be changed or reordered,
+// new steps are appended with the next version.
+var mongoMigrations = []mongoMigration{
+	{version: 1, name: "create_indices", up: createIndices},
+	{version: 2, name: "backfill_user_status", up: backfillUserStatus},
+	{version: 3, name: "backfill_user_roles", up: backfillUserRoles},
+	{version: 4, name: "user_cursor_index", up: createUserCursorIndex},
+	{version: 5, name: "user_last_login_index", up: createUserLastLoginIndex},
+	{version: 6, name: "audit_log_index", up: createAuditLogIndex},
+}
+
+// appliedMigration is the document which is stored in schema_migrations for every applied step
+type appliedMigration struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+type migrationLock struct {
+	ID        string    `bson:"_id"`
+	Owner     string    `bson:"owner"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// Migrate applies the pending migrations in order and returns the names of the applied ones.
+// It waits for other processes which are migrating the same database, so only one of them runs the steps.
+func (d *MyMongo) Migrate(ctx context.Context) ([]string, error) {
+	owner, err := d.lockMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer d.unlockMigrations(owner)
+
+	// long running steps must not lose the lock, the steps are canceled if refreshing it fails
+	ctx, cancel := context.WithCancelCause(ctx)
+	heartbeat := make(chan struct{})
+	go func() {
+		defer close(heartbeat)
+		d.keepMigrationLock(ctx, cancel, owner)
+	}()
+	defer func() {
+		cancel(nil)
+		<-heartbeat
+	}()
+
+	pending, err := d.pendingMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applied := []string{}
+	for _, m := range pending {
+		if err := m.up(ctx, d.db); err != nil {
+			if cause := context.Cause(ctx); cause != nil {
+				err = cause
+			}
+			return applied, fmt.Errorf("err when applying migration %s: %w", m, err)
+		}
+		record := appliedMigration{Version: m.version, Name: m.name, AppliedAt: time.Now()}
+		if _, err := d.db.Collection(MigrationCollection).InsertOne(ctx, record); err != nil {
+			return applied, fmt.Errorf("err when recording migration %s: %w", m, err)
+		}
+		applied = append(applied, m.String())
+	}
+	return applied, nil
+}
+
+// keepMigrationLock refreshes the lock until the context is done.
+// If refreshing fails, the context is canceled with the error.
+func (d *MyMongo) keepMigrationLock(ctx context.Context, cancel context.CancelCauseFunc, owner string) {
+	ticker := time.NewTicker(migrationLockRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.refreshMigrationLock(ctx, owner); err != nil {
+				cancel(err)
+				return
+			}
+		}
+	}
+}
+
+// PendingMigrations returns the names of the migrations which aren't applied yet
+func (d *MyMongo) PendingMigrations(ctx context.Context) ([]string, error) {
+	pending, err := d.pendingMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, m := range pending {
+		names = append(names, m.String())
+	}
+	return names, nil
+}
+
+func (d *MyMongo) pendingMigrations(ctx context.Context) ([]mongoMigration, error) {
+	cursor, err := d.db.Collection(MigrationCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("err when finding applied migrations: %w", err)
+	}
+	var records []appliedMigration
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("err when decoding applied migrations: %w", err)
+	}
+	applied := map[int]bool{}
+	for _, record := range records {
+		applied[record.Version] = true
+	}
+	pending := []mongoMigration{}
+	for _, m := range mongoMigrations {
+		if !applied[m.version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// lockMigrations blocks until the migration lock is acquired and returns the owner of the lock
+func (d *MyMongo) lockMigrations(ctx context.Context) (string, error) {
+	owner := rand.Text()
+	locks := d.db.Collection(MigrationLockCollection)
+	for {
+		now := time.Now()
+		lock := migrationLock{ID: MigrationCollection, Owner: owner, ExpiresAt: now.Add(migrationLockTTL)}
+		_, err := locks.InsertOne(ctx, lock)
+		if err == nil {
+			return owner, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return "", fmt.Errorf("err when acquiring migration lock: %w", err)
+		}
+		// take over the lock if its owner is gone
+		result, err := locks.ReplaceOne(ctx, bson.M{"_id": MigrationCollection, "expires_at": bson.M{"$lt": now}}, lock)
+		if err != nil {
+			return "", fmt.Errorf("err when taking over migration lock: %w", err)
+		}
+		if result.MatchedCount == 1 {
+			return owner, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("err when waiting for migration lock: %w", ctx.Err())
+		case <-time.After(migrationLockRetry):
+		}
+	}
+}
+
+func (d *MyMongo) refreshMigrationLock(ctx context.Context, owner string) error {
+	result, err := d.db.Collection(MigrationLockCollection).UpdateOne(ctx,
+		bson.M{"_id": MigrationCollection, "owner": owner},
+		bson.M{"$set": bson.M{"expires_at": time.Now().Add(migrationLockTTL)}},
+	)
+	if err != nil {
+		return fmt.Errorf("err when refreshing migration lock: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("migration lock is taken over by another process")
+	}
+	return nil
+}
+
+func (d *MyMongo) unlockMigrations(owner string) {
+	// the lock is released even if the context of the migration is canceled
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	d.db.Collection(MigrationLockCollection).DeleteOne(ctx, bson.M{"_id": MigrationCollection, "owner": owner})
+}
+
+// createIndices creates the indexes of every collection
+func createIndices(ctx context.Context, db *mongo.Database) error {
+	indexes := map[string][]mongo.IndexModel{
+		UserCollection: {
+			{Keys: bson.D{{Key: "phone", Value: 1}}, Options: options.Index().SetUnique(true)},
+			// improving performance when searching
+			{Keys: bson.D{{Key: "register_at", Value: 1}}},
+		},
+		SessionCollection: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+			// expired sessions are removed by mongodb
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		LoginEventCollection: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "phone", Value: 1}, {Key: "created_at", Value: -1}}},
+			// events are removed by mongodb at the end of the retention period
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+	}
+	for col, models := range indexes {
+		if _, err := db.Collection(col).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("err when creating %s indices: %w", col, err)
+		}
+	}
+	return nil
+}
+
+// backfillUserStatus sets the status of users which are registered before statuses were introduced
+func backfillUserStatus(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(UserCollection).UpdateMany(ctx,
+		bson.M{"status": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"status": entity.StatusActive}},
+	)
+	if err != nil {
+		return fmt.Errorf("err when setting user status: %w", err)
+	}
+	return nil
+}
+
+// backfillUserRoles sets the roles of users which are registered before roles were introduced
+func backfillUserRoles(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(UserCollection).UpdateMany(ctx,
+		bson.M{"$or": bson.A{bson.M{"roles": bson.M{"$exists": false}}, bson.M{"roles": bson.A{}}}},
+		bson.M{"$set": bson.M{"roles": bson.A{entity.RoleUser}}},
+	)
+	if err != nil {
+		return fmt.Errorf("err when setting user roles: %w", err)
+	}
+	return nil
+}
+
+// createUserCursorIndex replaces the register index with an index which matches
+// the sort order of search results, so pages after a cursor are found without scanning
+func createUserCursorIndex(ctx context.Context, db *mongo.Database) error {
+	model := mongo.IndexModel{Keys: bson.D{{Key: "register_at", Value: -1}, {Key: "_id", Value: -1}}}
+	if _, err := db.Collection(UserCollection).Indexes().CreateOne(ctx, model); err != nil {
+		return fmt.Errorf("err when creating user cursor index: %w", err)
+	}
+	// the index doesn't exist if the step is run again after a failure
+	err := db.Collection(UserCollection).Indexes().DropOne(ctx, "register_at_1")
+	if err != nil && !isIndexNotFound(err) {
+		return fmt.Errorf("err when dropping user register index: %w", err)
+	}
+	return nil
+}
+
+// createUserLastLoginIndex creates an index for searching and sorting by last login
+func createUserLastLoginIndex(ctx context.Context, db *mongo.Database) error {
+	model := mongo.IndexModel{Keys: bson.D{{Key: "last_login", Value: -1}, {Key: "_id", Value: -1}}}
+	if _, err := db.Collection(UserCollection).Indexes().CreateOne(ctx, model); err != nil {
+		return fmt.Errorf("err when creating user last login index: %w", err)
+	}
+	return nil
+}
+
+// createAuditLogIndex creates an index for listing the latest audit records
+func createAuditLogIndex(ctx context.Context, db *mongo.Database) error {
+	model := mongo.IndexModel{Keys: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}}
+	if _, err := db.Collection(AuditCollection).Indexes().CreateOne(ctx, model); err != nil {
+		return fmt.Errorf("err when creating audit log index: %w", err)
+	}
+	return nil
+}
+
+func isIndexNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	// IndexNotFound
+	return errors.As(err, &cmdErr) && cmdErr.Code == 27
+}
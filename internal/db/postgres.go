@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+// postgresMigrationLock is the key of the advisory lock which is held while migrating,
+// so only one replica runs the migrations
+const postgresMigrationLock = 8_138_001
+
+// Postgres implements Database with PostgreSQL
+type Postgres struct {
+	*sqlDatabase
+}
+
+// NewPostgres connects to the database at address, which is a postgres:// URL, and applies migrations.
+// Username and password are set on the URL if they are not empty.
+// Timeout is used as a global timeout for all of the operations like MyMongo.
+func NewPostgres(address, username, password string, timeout time.Duration) (*Postgres, error) {
+	if len(username) > 0 {
+		u, err := url.Parse(address)
+		if err != nil {
+			return nil, fmt.Errorf("err when parsing postgres address: %w", err)
+		}
+		u.User = url.UserPassword(username, password)
+		address = u.String()
+	}
+	conn, err := sql.Open("pgx", address)
+	if err != nil {
+		return nil, fmt.Errorf("err when connecting to postgres: %w", err)
+	}
+	d := &sqlDatabase{
+		db:      conn,
+		timeout: timeout,
+		dialect: sqlDialect{
+			rebind:            postgresRebind,
+			isUniqueViolation: postgresIsUniqueViolation,
+			forUpdate:         " FOR UPDATE",
+			timestampType:     "TIMESTAMPTZ",
+		},
+	}
+
+	// check for connection
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("err when pinging postgres: %w", err)
+	}
+	if err := migratePostgres(d); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("err when migrating postgres: %w", err)
+	}
+	return &Postgres{sqlDatabase: d}, nil
+}
+
+func migratePostgres(d *sqlDatabase) error {
+	// migrations may take longer than the other operations
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
+	defer cancel()
+	migrations, err := fs.Sub(postgresMigrations, "migrations/postgres")
+	if err != nil {
+		return err
+	}
+	// advisory locks belong to a connection, so the same connection is used for all of the steps
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("err when getting connection: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", postgresMigrationLock); err != nil {
+		return fmt.Errorf("err when locking migrations: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", postgresMigrationLock)
+	return d.migrate(ctx, conn, migrations)
+}
+
+// postgresRebind replaces ? placeholders with $1, $2 and so on
+func postgresRebind(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func postgresIsUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
@@ -0,0 +1,922 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aph138/dekamond/internal/entity"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// sqlDialect holds the differences between SQL databases
+type sqlDialect struct {
+	// rebind converts ? placeholders of a query to the placeholders of the database
+	rebind func(string) string
+	// isUniqueViolation reports whether the error is caused by a unique index
+	isUniqueViolation func(error) bool
+	// forUpdate is appended to a SELECT query for locking the selected rows
+	forUpdate string
+	// timestampType is the column type for times
+	timestampType string
+}
+
+// sqlDatabase implements Database on top of database/sql.
+// IDs are ObjectIDs in hex, so they look the same as the ones of MyMongo.
+// There is no TTL index in SQL, so expired sessions and login events are removed when new ones are inserted.
+type sqlDatabase struct {
+	db      *sql.DB
+	dialect sqlDialect
+	timeout time.Duration
+}
+
+const userColumns = "id, phone, register_at, last_login, display_name, email, locale, avatar_url, roles, status"
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(...any) error
+}
+
+func scanUser(row rowScanner) (*entity.User, error) {
+	var (
+		user      entity.User
+		id        string
+		lastLogin sql.NullTime
+		roles     string
+	)
+	err := row.Scan(&id, &user.Phone, &user.RegisteredAt, &lastLogin,
+		&user.DisplayName, &user.Email, &user.Locale, &user.AvatarURL, &roles, &user.Status)
+	if err != nil {
+		return nil, err
+	}
+	if user.ID, err = bson.ObjectIDFromHex(id); err != nil {
+		return nil, fmt.Errorf("err when parsing user id: %w", err)
+	}
+	user.RegisteredAt = user.RegisteredAt.UTC()
+	if lastLogin.Valid {
+		user.LastLogin = lastLogin.Time.UTC()
+	}
+	if err := json.Unmarshal([]byte(roles), &user.Roles); err != nil {
+		return nil, fmt.Errorf("err when decoding user roles: %w", err)
+	}
+	return &user, nil
+}
+
+func encodeList(list []string) string {
+	if list == nil {
+		list = []string{}
+	}
+	data, _ := json.Marshal(list)
+	return string(data)
+}
+
+// migrate applies the SQL files of migrations which are not applied yet, in order of their version.
+// File names start with the version followed by an underscore, such as 0001_init.sql.
+// Applied versions are recorded in schema_migrations table.
+func (d *sqlDatabase) migrate(ctx context.Context, conn *sql.Conn, migrations fs.FS) error {
+	createTable := "CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT PRIMARY KEY, name TEXT NOT NULL, applied_at " +
+		d.dialect.timestampType + " NOT NULL)"
+	if _, err := conn.ExecContext(ctx, createTable); err != nil {
+		return fmt.Errorf("err when creating schema_migrations table: %w", err)
+	}
+	files, err := fs.Glob(migrations, "*.sql")
+	if err != nil {
+		return fmt.Errorf("err when listing migrations: %w", err)
+	}
+	type migration struct {
+		version int64
+		name    string
+	}
+	list := make([]migration, 0, len(files))
+	for _, name := range files {
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid migration file name %s", name)
+		}
+		list = append(list, migration{version: version, name: name})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].version < list[j].version })
+
+	for _, m := range list {
+		var applied int
+		err := conn.QueryRowContext(ctx, d.dialect.rebind("SELECT COUNT(*) FROM schema_migrations WHERE version = ?"), m.version).
+			Scan(&applied)
+		if err != nil {
+			return fmt.Errorf("err when checking migration %s: %w", m.name, err)
+		}
+		if applied > 0 {
+			continue
+		}
+		query, err := fs.ReadFile(migrations, m.name)
+		if err != nil {
+			return fmt.Errorf("err when reading migration %s: %w", m.name, err)
+		}
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("err when beginning migration %s: %w", m.name, err)
+		}
+		if _, err := tx.ExecContext(ctx, string(query)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("err when applying migration %s: %w", m.name, err)
+		}
+		_, err = tx.ExecContext(ctx, d.dialect.rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"),
+			m.version, m.name, time.Now().UTC())
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("err when recording migration %s: %w", m.name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("err when committing migration %s: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+func (d *sqlDatabase) Close(ctx context.Context) error {
+	return d.db.Close()
+}
+
+func (d *sqlDatabase) SaveUser(phone string) (*entity.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	now := time.Now().UTC()
+	// only last_login is changed if the user already exists
+	query := "INSERT INTO users (id, phone, register_at, last_login, roles, status) VALUES (?, ?, ?, ?, ?, ?) " +
+		"ON CONFLICT (phone) DO UPDATE SET last_login = excluded.last_login RETURNING " + userColumns
+	row := d.db.QueryRowContext(ctx, d.dialect.rebind(query),
+		bson.NewObjectID().Hex(), phone, now, now, encodeList([]string{entity.RoleUser}), entity.StatusActive)
+	user, err := scanUser(row)
+	if err != nil {
+		return nil, fmt.Errorf("err when upserting user with sql: %w", err)
+	}
+	return user, nil
+}
+
+func (d *sqlDatabase) ImportUsers(users []UserImport) ([]bool, error) {
+	created := make([]bool, len(users))
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("err when beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	query := d.dialect.rebind("INSERT INTO users (id, phone, register_at, roles, status) VALUES (?, ?, ?, ?, ?) ON CONFLICT (phone) DO NOTHING")
+	for i, user := range users {
+		registeredAt := user.RegisteredAt.UTC()
+		if user.RegisteredAt.IsZero() {
+			registeredAt = now
+		}
+		result, err := tx.ExecContext(ctx, query,
+			bson.NewObjectID().Hex(), user.Phone, registeredAt, encodeList([]string{entity.RoleUser}), entity.StatusActive)
+		if err != nil {
+			return nil, fmt.Errorf("err when importing user with sql: %w", err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return nil, fmt.Errorf("err when getting affected rows: %w", err)
+		}
+		created[i] = affected == 1
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("err when committing transaction: %w", err)
+	}
+	return created, nil
+}
+
+// queryUser runs a query which returns one user
+func (d *sqlDatabase) queryUser(query string, args ...any) (*entity.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	user, err := scanUser(d.db.QueryRowContext(ctx, d.dialect.rebind(query), args...))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		if d.dialect.isUniqueViolation(err) {
+			return nil, ErrDuplicate
+		}
+		return nil, fmt.Errorf("err when querying user with sql: %w", err)
+	}
+	return user, nil
+}
+
+func (d *sqlDatabase) FindUser(id string) (*entity.User, error) {
+	return d.queryUser("SELECT "+userColumns+" FROM users WHERE id = ?", id)
+}
+
+func (d *sqlDatabase) UpdateUser(id string, update UserUpdate) (*entity.User, error) {
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{"display_name", update.DisplayName},
+		{"email", update.Email},
+		{"locale", update.Locale},
+		{"avatar_url", update.AvatarURL},
+	}
+	set := []string{}
+	args := []any{}
+	for _, f := range fields {
+		// an empty string removes the field
+		if f.value != nil {
+			set = append(set, f.name+" = ?")
+			args = append(args, *f.value)
+		}
+	}
+	if update.Phone != nil && len(*update.Phone) > 0 {
+		set = append(set, "phone = ?")
+		args = append(args, *update.Phone)
+	}
+	if len(set) == 0 {
+		return d.FindUser(id)
+	}
+	args = append(args, id)
+	return d.queryUser("UPDATE users SET "+strings.Join(set, ", ")+" WHERE id = ? RETURNING "+userColumns, args...)
+}
+
+func (d *sqlDatabase) UpdateUserPhone(id, phone string) (*entity.User, error) {
+	// the unique phone index rejects the change if the number is taken
+	return d.queryUser("UPDATE users SET phone = ? WHERE id = ? RETURNING "+userColumns, phone, id)
+}
+
+// changeRoles applies change to the roles of the user which matches the column in a transaction
+func (d *sqlDatabase) changeRoles(ctx context.Context, tx *sql.Tx, column, value string, change func([]string) []string) (*entity.User, error) {
+	var roles string
+	query := "SELECT roles FROM users WHERE " + column + " = ?" + d.dialect.forUpdate
+	if err := tx.QueryRowContext(ctx, d.dialect.rebind(query), value).Scan(&roles); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("err when finding roles with sql: %w", err)
+	}
+	var list []string
+	if err := json.Unmarshal([]byte(roles), &list); err != nil {
+		return nil, fmt.Errorf("err when decoding user roles: %w", err)
+	}
+	query = "UPDATE users SET roles = ? WHERE " + column + " = ? RETURNING " + userColumns
+	user, err := scanUser(tx.QueryRowContext(ctx, d.dialect.rebind(query), encodeList(change(list)), value))
+	if err != nil {
+		return nil, fmt.Errorf("err when updating roles with sql: %w", err)
+	}
+	return user, nil
+}
+
+// withTx runs fn in a transaction which is committed if fn doesn't return an error
+func (d *sqlDatabase) withTx(fn func(context.Context, *sql.Tx) (*entity.User, error)) (*entity.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("err when beginning transaction: %w", err)
+	}
+	user, err := fn(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("err when committing transaction: %w", err)
+	}
+	return user, nil
+}
+
+// unionRoles returns roles with the added roles which it doesn't have
+func unionRoles(roles []string, added ...string) []string {
+	for _, role := range added {
+		if !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+func (d *sqlDatabase) AddUserRole(id, role string) (*entity.User, error) {
+	return d.withTx(func(ctx context.Context, tx *sql.Tx) (*entity.User, error) {
+		return d.changeRoles(ctx, tx, "id", id, func(roles []string) []string {
+			// users without roles have the user role implicitly
+			if len(roles) == 0 {
+				roles = []string{entity.RoleUser}
+			}
+			return unionRoles(roles, role)
+		})
+	})
+}
+
+func (d *sqlDatabase) RemoveUserRole(id, role string) (*entity.User, error) {
+	return d.withTx(func(ctx context.Context, tx *sql.Tx) (*entity.User, error) {
+		return d.changeRoles(ctx, tx, "id", id, func(roles []string) []string {
+			if len(roles) == 0 {
+				roles = []string{entity.RoleUser}
+			}
+			return slices.DeleteFunc(roles, func(r string) bool { return r == role })
+		})
+	})
+}
+
+func (d *sqlDatabase) GrantRoleByPhone(phone, role string) (*entity.User, error) {
+	return d.withTx(func(ctx context.Context, tx *sql.Tx) (*entity.User, error) {
+		query := "INSERT INTO users (id, phone, register_at, roles, status) VALUES (?, ?, ?, ?, ?) ON CONFLICT (phone) DO NOTHING"
+		_, err := tx.ExecContext(ctx, d.dialect.rebind(query),
+			bson.NewObjectID().Hex(), phone, time.Now().UTC(), encodeList(nil), entity.StatusActive)
+		if err != nil {
+			return nil, fmt.Errorf("err when inserting user with sql: %w", err)
+		}
+		return d.changeRoles(ctx, tx, "phone", phone, func(roles []string) []string {
+			return unionRoles(roles, entity.RoleUser, role)
+		})
+	})
+}
+
+func (d *sqlDatabase) SetUserStatus(id, status string) (*entity.User, error) {
+	return d.queryUser("UPDATE users SET status = ? WHERE id = ? RETURNING "+userColumns, status, id)
+}
+
+// execAffected runs the query and returns ErrNotFound if no row is affected
+func (d *sqlDatabase) execAffected(query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	result, err := d.db.ExecContext(ctx, d.dialect.rebind(query), args...)
+	if err != nil {
+		return fmt.Errorf("err when executing query with sql: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("err when getting affected rows: %w", err)
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// exec runs the query which doesn't return anything
+func (d *sqlDatabase) exec(query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	if _, err := d.db.ExecContext(ctx, d.dialect.rebind(query), args...); err != nil {
+		return fmt.Errorf("err when executing query with sql: %w", err)
+	}
+	return nil
+}
+
+func (d *sqlDatabase) DeleteUser(id string) error {
+	return d.execAffected("DELETE FROM users WHERE id = ?", id)
+}
+
+// userWhere returns the conditions of the search options and their arguments, without the cursor
+func userWhere(option *searchUserOption) ([]string, []any) {
+	where := []string{}
+	args := []any{}
+	if len(option.phone) > 0 {
+		where = append(where, "phone = ?")
+		args = append(args, option.phone)
+	} else if pattern := option.phonePattern; len(pattern.value) > 0 {
+		patternWhere, patternArgs := phonePatternWhere(pattern)
+		where = append(where, patternWhere...)
+		args = append(args, patternArgs...)
+	}
+	ranges := []struct {
+		column   string
+		from, to *time.Time
+	}{
+		{"register_at", option.registerFrom, option.registerTO},
+		{"last_login", option.lastLoginFrom, option.lastLoginTo},
+	}
+	for _, r := range ranges {
+		if r.from != nil {
+			where = append(where, r.column+" >= ?")
+			args = append(args, r.from.UTC())
+		}
+		if r.to != nil {
+			where = append(where, r.column+" <= ?")
+			args = append(args, r.to.UTC())
+		}
+	}
+	if len(option.status) > 0 {
+		where = append(where, "status = ?")
+		args = append(args, option.status)
+	}
+	if option.filter != nil {
+		condition, filterArgs := filterWhere(option.filter)
+		where = append(where, condition)
+		args = append(args, filterArgs...)
+	}
+	return where, args
+}
+
+// phonePatternWhere returns the conditions of a phone pattern and their arguments
+func phonePatternWhere(pattern phonePattern) ([]string, []any) {
+	// the range is found by the phone index and LIKE only checks the numbers in it
+	where := []string{"phone >= ?", "phone < ?"}
+	args := []any{pattern.from, pattern.to}
+	if !pattern.prefix {
+		where = append(where, "phone LIKE ?")
+		args = append(args, strings.ReplaceAll(pattern.value, "*", "_"))
+	}
+	return where, args
+}
+
+// sqlFilterOps maps the operators of filter expressions to SQL operators
+var sqlFilterOps = map[FilterOp]string{
+	FilterEq:  "=",
+	FilterGt:  ">",
+	FilterGte: ">=",
+	FilterLt:  "<",
+	FilterLte: "<=",
+}
+
+// filterWhere returns the condition of an expression which is checked by checkFilter and its arguments.
+// Conditions are false for NULL values like missing fields in mongodb, so negated conditions match them.
+func filterWhere(expr FilterExpr) (string, []any) {
+	switch e := expr.(type) {
+	case *FilterAnd:
+		return joinFilterWhere(e.Exprs, " AND ")
+	case *FilterOr:
+		return joinFilterWhere(e.Exprs, " OR ")
+	case *FilterNot:
+		condition, args := filterWhere(e.Expr)
+		return "(NOT " + condition + ")", args
+	}
+	condition := expr.(*FilterCondition)
+	field := filterFields[condition.Field]
+	if field.kind == filterTime {
+		return fmt.Sprintf("(%[1]s IS NOT NULL AND %[1]s %[2]s ?)", field.column, sqlFilterOps[condition.Op]),
+			[]any{condition.Value.(time.Time).UTC()}
+	}
+	value := condition.Value.(string)
+	switch {
+	case field.kind == filterPhone && strings.Contains(value, "*"):
+		pattern, _ := newPhonePattern(value, false)
+		where, args := phonePatternWhere(pattern)
+		return "(" + strings.Join(where, " AND ") + ")", args
+	case condition.Field == "role":
+		// roles are stored as a JSON array of names, which don't have wildcards
+		args := []any{`%"` + value + `"%`}
+		if value == entity.RoleUser {
+			return "(roles LIKE ? OR roles = '[]')", args
+		}
+		return "(roles LIKE ?)", args
+	}
+	return "(" + field.column + " = ?)", []any{value}
+}
+
+func joinFilterWhere(exprs []FilterExpr, op string) (string, []any) {
+	conditions := make([]string, 0, len(exprs))
+	args := []any{}
+	for _, expr := range exprs {
+		condition, exprArgs := filterWhere(expr)
+		conditions = append(conditions, condition)
+		args = append(args, exprArgs...)
+	}
+	return "(" + strings.Join(conditions, op) + ")", args
+}
+
+// cursorWhere returns the condition of the users after the cursor and its arguments.
+// NULL values are sorted like mongodb, they are the last in descending order.
+func cursorWhere(c *userCursor) (string, []any) {
+	column := c.sort.field
+	op := ">"
+	if c.sort.desc {
+		op = "<"
+	}
+	id := c.id.Hex()
+	if c.value == nil {
+		if c.sort.desc {
+			return fmt.Sprintf("(%s IS NULL AND id %s ?)", column, op), []any{id}
+		}
+		return fmt.Sprintf("((%s IS NULL AND id %s ?) OR %s IS NOT NULL)", column, op, column), []any{id}
+	}
+	value := c.value.UTC()
+	condition := fmt.Sprintf("%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?)", column, op)
+	if c.sort.desc {
+		condition += fmt.Sprintf(" OR %s IS NULL", column)
+	}
+	return "(" + condition + ")", []any{value, value, id}
+}
+
+// orderBy returns the ORDER BY clause of the sort
+func orderBy(sort searchUserSort) string {
+	if sort.desc {
+		return fmt.Sprintf(" ORDER BY %s DESC NULLS LAST, id DESC", sort.field)
+	}
+	return fmt.Sprintf(" ORDER BY %s ASC NULLS FIRST, id ASC", sort.field)
+}
+
+func whereClause(where []string) string {
+	if len(where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(where, " AND ")
+}
+
+func (d *sqlDatabase) SearchUser(opts ...SearchUserOption) (*UserPage, error) {
+	option, err := newSearchUserOption(opts)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	where, args := userWhere(option)
+	var total *int64
+	if option.total {
+		var count int64
+		query := "SELECT COUNT(*) FROM users" + whereClause(where)
+		if err := d.db.QueryRowContext(ctx, d.dialect.rebind(query), args...).Scan(&count); err != nil {
+			return nil, fmt.Errorf("err when counting users %w", err)
+		}
+		total = &count
+	}
+	offset := option.pagination.limit * (option.pagination.page - 1)
+	if len(option.cursor) > 0 {
+		cursor, err := decodeUserCursor(option.cursor, option.sort)
+		if err != nil {
+			return nil, err
+		}
+		condition, cursorArgs := cursorWhere(cursor)
+		where = append(where, condition)
+		args = append(args, cursorArgs...)
+		offset = 0
+	}
+	// one more user is fetched to know if there is a next page
+	query := "SELECT " + userColumns + " FROM users" + whereClause(where) + orderBy(option.sort) + " LIMIT ? OFFSET ?"
+	args = append(args, option.pagination.limit+1, offset)
+	result, err := d.findUsers(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	page := newUserPage(result, option)
+	page.Total = total
+	return page, nil
+}
+
+func (d *sqlDatabase) ExportUsers(ctx context.Context, fn func(entity.User) error, opts ...SearchUserOption) error {
+	option, err := newSearchUserOption(opts)
+	if err != nil {
+		return err
+	}
+	where, args := userWhere(option)
+	// users are read in batches after the last user of the previous batch instead of streaming one query,
+	// so the connection isn't held while the function writes them. sqlite has only one connection and
+	// every other query would wait for the whole export.
+	var cursor *userCursor
+	for {
+		batchWhere, batchArgs := slices.Clone(where), slices.Clone(args)
+		if cursor != nil {
+			condition, cursorArgs := cursorWhere(cursor)
+			batchWhere = append(batchWhere, condition)
+			batchArgs = append(batchArgs, cursorArgs...)
+		}
+		query := "SELECT " + userColumns + " FROM users" + whereClause(batchWhere) + orderBy(option.sort) + " LIMIT ?"
+		batch, err := d.findUsers(ctx, query, append(batchArgs, exportBatchSize)...)
+		if err != nil {
+			return err
+		}
+		for _, user := range batch {
+			if err := fn(user); err != nil {
+				return err
+			}
+		}
+		if len(batch) < exportBatchSize {
+			return nil
+		}
+		last := batch[len(batch)-1]
+		cursor = &userCursor{sort: option.sort, id: last.ID}
+		if value := sortValue(last, option.sort.field); !value.IsZero() {
+			cursor.value = &value
+		}
+	}
+}
+
+// findUsers runs the query and returns every user of the result
+func (d *sqlDatabase) findUsers(ctx context.Context, query string, args ...any) ([]entity.User, error) {
+	rows, err := d.db.QueryContext(ctx, d.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("err when finding from db %w", err)
+	}
+	defer rows.Close()
+	result := []entity.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("err when decoding result %w", err)
+		}
+		result = append(result, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("err when reading result %w", err)
+	}
+	return result, nil
+}
+
+func (d *sqlDatabase) UserStats(interval string, from, to time.Time, location *time.Location) ([]UserStatsBucket, error) {
+	stats, err := newUserStats(interval, from, to, location)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	// sqlite has no time zones, so the times are grouped here instead of by the database
+	err = d.forEachTime(ctx, stats, "register_at", func(bucket *UserStatsBucket) { bucket.Registrations++ })
+	if err != nil {
+		return nil, err
+	}
+	err = d.forEachTime(ctx, stats, "last_login", func(bucket *UserStatsBucket) { bucket.ActiveUsers++ })
+	if err != nil {
+		return nil, err
+	}
+	return stats.buckets, nil
+}
+
+// forEachTime calls the function with the bucket of every user whose time column is in the range of the buckets
+func (d *sqlDatabase) forEachTime(ctx context.Context, stats *userStats, column string, fn func(*UserStatsBucket)) error {
+	query := fmt.Sprintf("SELECT %s FROM users WHERE %s >= ? AND %s < ?", column, column, column)
+	rows, err := d.db.QueryContext(ctx, d.dialect.rebind(query), stats.start.UTC(), stats.end.UTC())
+	if err != nil {
+		return fmt.Errorf("err when counting users by %s %w", column, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t time.Time
+		if err := rows.Scan(&t); err != nil {
+			return fmt.Errorf("err when decoding %s %w", column, err)
+		}
+		if bucket := stats.bucket(stats.truncate(t)); bucket != nil {
+			fn(bucket)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("err when reading %s %w", column, err)
+	}
+	return nil
+}
+
+func (d *sqlDatabase) SaveClient(client *entity.Client) error {
+	query := "INSERT INTO oauth_clients (id, secret_hash, name, redirect_uris, created_at) VALUES (?, ?, ?, ?, ?)"
+	err := d.exec(query, client.ID, client.SecretHash, client.Name, encodeList(client.RedirectURIs), client.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("err when inserting client: %w", err)
+	}
+	return nil
+}
+
+func (d *sqlDatabase) FindClient(id string) (*entity.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	var (
+		client       entity.Client
+		redirectURIs string
+	)
+	query := "SELECT id, secret_hash, name, redirect_uris, created_at FROM oauth_clients WHERE id = ?"
+	err := d.db.QueryRowContext(ctx, d.dialect.rebind(query), id).
+		Scan(&client.ID, &client.SecretHash, &client.Name, &redirectURIs, &client.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("err when finding client with sql: %w", err)
+	}
+	if err := json.Unmarshal([]byte(redirectURIs), &client.RedirectURIs); err != nil {
+		return nil, fmt.Errorf("err when decoding redirect uris: %w", err)
+	}
+	client.CreatedAt = client.CreatedAt.UTC()
+	return &client, nil
+}
+
+const sessionColumns = "id, user_id, device_name, ip, user_agent, created_at, last_seen_at, expires_at"
+
+func scanSession(row rowScanner) (*entity.Session, error) {
+	var (
+		session    entity.Session
+		id, userID string
+	)
+	err := row.Scan(&id, &userID, &session.DeviceName, &session.IP, &session.UserAgent,
+		&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if session.ID, err = bson.ObjectIDFromHex(id); err != nil {
+		return nil, fmt.Errorf("err when parsing session id: %w", err)
+	}
+	if session.UserID, err = bson.ObjectIDFromHex(userID); err != nil {
+		return nil, fmt.Errorf("err when parsing user id: %w", err)
+	}
+	session.CreatedAt = session.CreatedAt.UTC()
+	session.LastSeenAt = session.LastSeenAt.UTC()
+	session.ExpiresAt = session.ExpiresAt.UTC()
+	return &session, nil
+}
+
+func (d *sqlDatabase) CreateSession(session *entity.Session) (string, error) {
+	if err := d.exec("DELETE FROM sessions WHERE expires_at <= ?", time.Now().UTC()); err != nil {
+		return "", fmt.Errorf("err when removing expired sessions: %w", err)
+	}
+	id := session.ID
+	if id.IsZero() {
+		id = bson.NewObjectID()
+	}
+	query := "INSERT INTO sessions (" + sessionColumns + ") VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	err := d.exec(query, id.Hex(), session.UserID.Hex(), session.DeviceName, session.IP, session.UserAgent,
+		session.CreatedAt.UTC(), session.LastSeenAt.UTC(), session.ExpiresAt.UTC())
+	if err != nil {
+		return "", fmt.Errorf("err when inserting session: %w", err)
+	}
+	return id.Hex(), nil
+}
+
+func (d *sqlDatabase) FindSession(id string) (*entity.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	query := "SELECT " + sessionColumns + " FROM sessions WHERE id = ? AND expires_at > ?"
+	session, err := scanSession(d.db.QueryRowContext(ctx, d.dialect.rebind(query), id, time.Now().UTC()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("err when finding session with sql: %w", err)
+	}
+	return session, nil
+}
+
+func (d *sqlDatabase) ListSessions(userID string) ([]entity.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	query := "SELECT " + sessionColumns + " FROM sessions WHERE user_id = ? AND expires_at > ? ORDER BY last_seen_at DESC"
+	rows, err := d.db.QueryContext(ctx, d.dialect.rebind(query), userID, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("err when finding sessions from db %w", err)
+	}
+	defer rows.Close()
+	result := []entity.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("err when decoding sessions %w", err)
+		}
+		result = append(result, *session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("err when reading sessions %w", err)
+	}
+	return result, nil
+}
+
+func (d *sqlDatabase) TouchSession(id, userID string) error {
+	now := time.Now().UTC()
+	return d.execAffected("UPDATE sessions SET last_seen_at = ? WHERE id = ? AND user_id = ? AND expires_at > ?",
+		now, id, userID, now)
+}
+
+func (d *sqlDatabase) DeleteSession(id, userID string) error {
+	return d.execAffected("DELETE FROM sessions WHERE id = ? AND user_id = ?", id, userID)
+}
+
+func (d *sqlDatabase) DeleteUserSessions(userID string) error {
+	return d.exec("DELETE FROM sessions WHERE user_id = ?", userID)
+}
+
+const loginEventColumns = "id, user_id, phone, type, channel, outcome, ip, user_agent, latency_ms, created_at, expires_at"
+
+// loginEventWhere matches the events of the user ID and the events of the phone number
+// which aren't linked to any user and are created since the time
+const loginEventWhere = "(user_id = ? OR (phone = ? AND user_id IS NULL AND created_at >= ?))"
+
+func (d *sqlDatabase) SaveLoginEvent(event *entity.LoginEvent) error {
+	if err := d.exec("DELETE FROM login_events WHERE expires_at <= ?", time.Now().UTC()); err != nil {
+		return fmt.Errorf("err when removing expired login events: %w", err)
+	}
+	var userID sql.NullString
+	if !event.UserID.IsZero() {
+		userID = sql.NullString{String: event.UserID.Hex(), Valid: true}
+	}
+	query := "INSERT INTO login_events (" + loginEventColumns + ") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	err := d.exec(query, bson.NewObjectID().Hex(), userID, event.Phone, event.Type, event.Channel, event.Outcome,
+		event.IP, event.UserAgent, event.LatencyMS, event.CreatedAt.UTC(), event.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("err when inserting login event: %w", err)
+	}
+	return nil
+}
+
+func (d *sqlDatabase) ListLoginEvents(userID, phone string, since time.Time, limit int64) ([]entity.LoginEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	// events of one request may have the same time
+	query := "SELECT " + loginEventColumns + " FROM login_events WHERE " + loginEventWhere + " ORDER BY created_at DESC, id DESC"
+	args := []any{userID, phone, since.UTC()}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := d.db.QueryContext(ctx, d.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("err when finding login events from db %w", err)
+	}
+	defer rows.Close()
+	result := []entity.LoginEvent{}
+	for rows.Next() {
+		var (
+			event  entity.LoginEvent
+			id     string
+			userID sql.NullString
+		)
+		err := rows.Scan(&id, &userID, &event.Phone, &event.Type, &event.Channel, &event.Outcome,
+			&event.IP, &event.UserAgent, &event.LatencyMS, &event.CreatedAt, &event.ExpiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("err when decoding login events %w", err)
+		}
+		if event.ID, err = bson.ObjectIDFromHex(id); err != nil {
+			return nil, fmt.Errorf("err when parsing login event id: %w", err)
+		}
+		if userID.Valid {
+			if event.UserID, err = bson.ObjectIDFromHex(userID.String); err != nil {
+				return nil, fmt.Errorf("err when parsing user id: %w", err)
+			}
+		}
+		event.CreatedAt, event.ExpiresAt = event.CreatedAt.UTC(), event.ExpiresAt.UTC()
+		result = append(result, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("err when reading login events %w", err)
+	}
+	return result, nil
+}
+
+func (d *sqlDatabase) DeleteLoginEvents(userID, phone string, since time.Time) error {
+	return d.exec("DELETE FROM login_events WHERE "+loginEventWhere, userID, phone, since.UTC())
+}
+
+const auditColumns = "id, actor_id, action, target_id, details, ip, created_at"
+
+func (d *sqlDatabase) SaveAuditRecord(record *entity.AuditRecord) error {
+	details, err := json.Marshal(record.Details)
+	if err != nil {
+		return fmt.Errorf("err when encoding audit details: %w", err)
+	}
+	if record.Details == nil {
+		details = []byte("{}")
+	}
+	query := "INSERT INTO audit_log (" + auditColumns + ") VALUES (?, ?, ?, ?, ?, ?, ?)"
+	err = d.exec(query, bson.NewObjectID().Hex(), record.ActorID.Hex(), record.Action, record.TargetID,
+		string(details), record.IP, record.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("err when inserting audit record: %w", err)
+	}
+	return nil
+}
+
+func (d *sqlDatabase) ListAuditRecords(limit int64) ([]entity.AuditRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	query := "SELECT " + auditColumns + " FROM audit_log ORDER BY created_at DESC, id DESC"
+	args := []any{}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := d.db.QueryContext(ctx, d.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("err when finding audit records from db %w", err)
+	}
+	defer rows.Close()
+	result := []entity.AuditRecord{}
+	for rows.Next() {
+		var (
+			record      entity.AuditRecord
+			id, actorID string
+			details     string
+		)
+		err := rows.Scan(&id, &actorID, &record.Action, &record.TargetID, &details, &record.IP, &record.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("err when decoding audit records %w", err)
+		}
+		if record.ID, err = bson.ObjectIDFromHex(id); err != nil {
+			return nil, fmt.Errorf("err when parsing audit record id: %w", err)
+		}
+		if record.ActorID, err = bson.ObjectIDFromHex(actorID); err != nil {
+			return nil, fmt.Errorf("err when parsing actor id: %w", err)
+		}
+		if err := json.Unmarshal([]byte(details), &record.Details); err != nil {
+			return nil, fmt.Errorf("err when decoding audit details: %w", err)
+		}
+		if len(record.Details) == 0 {
+			record.Details = nil
+		}
+		record.CreatedAt = record.CreatedAt.UTC()
+		result = append(result, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("err when reading audit records %w", err)
+	}
+	return result, nil
+}
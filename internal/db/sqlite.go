@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrations embed.FS
+
+// SQLite implements Database with an SQLite file, for single node deployments and local development.
+// It doesn't need cgo.
+type SQLite struct {
+	*sqlDatabase
+}
+
+// NewSQLite opens the SQLite database at address and applies migrations.
+// Address is either a file path or a sqlite:// URL such as sqlite:///var/lib/dekamond/dekamond.db.
+// The file is created if it doesn't exist.
+func NewSQLite(address string, timeout time.Duration) (*SQLite, error) {
+	path := strings.TrimPrefix(address, "sqlite://")
+	params := url.Values{}
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	// times are written in a format which is sorted correctly as text
+	params.Set("_time_format", "sqlite")
+	conn, err := sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("err when opening sqlite database: %w", err)
+	}
+	// sqlite allows only one writer, using one connection serializes the operations
+	conn.SetMaxOpenConns(1)
+	d := &sqlDatabase{
+		db:      conn,
+		timeout: timeout,
+		dialect: sqlDialect{
+			rebind:            func(query string) string { return query },
+			isUniqueViolation: sqliteIsUniqueViolation,
+			timestampType:     "TIMESTAMP",
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
+	defer cancel()
+	if err := conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("err when opening sqlite database at %s: %w", path, err)
+	}
+	if err := migrateSQLite(ctx, d); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("err when migrating sqlite: %w", err)
+	}
+	return &SQLite{sqlDatabase: d}, nil
+}
+
+func migrateSQLite(ctx context.Context, d *sqlDatabase) error {
+	migrations, err := fs.Sub(sqliteMigrations, "migrations/sqlite")
+	if err != nil {
+		return err
+	}
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("err when getting connection: %w", err)
+	}
+	defer conn.Close()
+	return d.migrate(ctx, conn, migrations)
+}
+
+func sqliteIsUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) &&
+		(sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY)
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+var ErrInvalidStatsRange = errors.New("invalid stats range")
+
+// intervals which user statistics are counted in
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+)
+
+// Intervals lists every interval of user statistics
+var Intervals = []string{IntervalDay, IntervalWeek, IntervalMonth}
+
+// MaxStatsBuckets is the most intervals which UserStats counts at once
+const MaxStatsBuckets = 1000
+
+// UserStatsBucket holds the counts of users in one interval
+type UserStatsBucket struct {
+	// Start is the beginning of the interval
+	Start time.Time `json:"start"`
+	// Registrations is the number of users who registered in the interval
+	Registrations int64 `json:"registrations"`
+	// ActiveUsers is the number of users whose last login is in the interval.
+	// Only the last login of users is stored, so users who logged in later are counted in a later interval.
+	ActiveUsers int64 `json:"active_users"`
+}
+
+// userStats holds the buckets of a UserStats call and finds the bucket of a time
+type userStats struct {
+	interval string
+	location *time.Location
+	buckets  []UserStatsBucket
+	// index maps the Unix time of the start of every bucket to its position
+	index map[int64]int
+	// start and end are the range of every bucket, end is exclusive
+	start, end time.Time
+}
+
+// newUserStats returns empty buckets of the interval which cover from and to in the location.
+// The first and last buckets are whole, so they may start before from and end after to.
+// It returns ErrInvalidStatsRange if the interval is unknown, to is before from or there are more than MaxStatsBuckets buckets.
+func newUserStats(interval string, from, to time.Time, location *time.Location) (*userStats, error) {
+	if !slices.Contains(Intervals, interval) || to.Before(from) {
+		return nil, ErrInvalidStatsRange
+	}
+	stats := &userStats{interval: interval, location: location, index: map[int64]int{}}
+	stats.start = stats.truncate(from)
+	start := stats.start
+	for ; !start.After(to); start = stats.next(start) {
+		if len(stats.buckets) == MaxStatsBuckets {
+			return nil, ErrInvalidStatsRange
+		}
+		stats.index[start.Unix()] = len(stats.buckets)
+		stats.buckets = append(stats.buckets, UserStatsBucket{Start: start})
+	}
+	stats.end = start
+	return stats, nil
+}
+
+// truncate returns the start of the bucket which t is in. Weeks start on Monday.
+func (s *userStats) truncate(t time.Time) time.Time {
+	year, month, day := t.In(s.location).Date()
+	switch s.interval {
+	case IntervalMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, s.location)
+	case IntervalWeek:
+		offset := (int(t.In(s.location).Weekday()) + 6) % 7
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, s.location)
+	default:
+		return time.Date(year, month, day, 0, 0, 0, 0, s.location)
+	}
+}
+
+// next returns the start of the bucket after the bucket which starts at start
+func (s *userStats) next(start time.Time) time.Time {
+	switch s.interval {
+	case IntervalMonth:
+		return start.AddDate(0, 1, 0)
+	case IntervalWeek:
+		return start.AddDate(0, 0, 7)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// bucket returns the bucket which starts at start, or nil if it isn't in the range
+func (s *userStats) bucket(start time.Time) *UserStatsBucket {
+	i, ok := s.index[start.Unix()]
+	if !ok {
+		return nil
+	}
+	return &s.buckets[i]
+}
@@ -0,0 +1,31 @@
+package entity
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// AuditRecord records an action of support staff or an admin which reads or changes the data of other users
+type AuditRecord struct {
+	ID      bson.ObjectID `json:"id" bson:"_id,omitempty"`
+	ActorID bson.ObjectID `json:"actor_id" bson:"actor_id"`
+	Action  string        `json:"action" bson:"action"`
+	// TargetID is the ID of the user which the action is done on, it's empty for actions on many users
+	TargetID string `json:"target_id,omitempty" bson:"target_id,omitempty"`
+	// Details describes what the action is done with, such as the filters of an export
+	Details   map[string]string `json:"details,omitempty" bson:"details,omitempty"`
+	IP        string            `json:"ip,omitempty" bson:"ip,omitempty"`
+	CreatedAt time.Time         `json:"created_at" bson:"created_at"`
+}
+
+// actions of audit records
+const (
+	AuditUserExport = "user_export"
+	AuditUserImport = "user_import"
+	AuditUserUpdate = "user_update"
+	AuditUserDelete = "user_delete"
+	AuditUserStatus = "user_status"
+	AuditRoleGrant  = "role_grant"
+	AuditRoleRevoke = "role_revoke"
+)
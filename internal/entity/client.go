@@ -0,0 +1,19 @@
+package entity
+
+import "time"
+
+// Client defines an application which uses dekamond as its OpenID Connect provider
+type Client struct {
+	ID string `json:"client_id" bson:"_id"`
+	// SecretHash is hex encoded SHA-256 of the client secret.
+	// It's empty for public clients which authenticate only by PKCE.
+	SecretHash   string    `json:"-" bson:"secret_hash,omitempty"`
+	Name         string    `json:"name" bson:"name"`
+	RedirectURIs []string  `json:"redirect_uris" bson:"redirect_uris"`
+	CreatedAt    time.Time `json:"created_at" bson:"created_at"`
+}
+
+// IsPublic reports whether the client has no secret
+func (c *Client) IsPublic() bool {
+	return c.SecretHash == ""
+}
@@ -0,0 +1,50 @@
+package entity
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// LoginEvent records a step of logging in, such as requesting or verifying an OTP code
+type LoginEvent struct {
+	ID bson.ObjectID `json:"id" bson:"_id,omitempty"`
+	// UserID is empty for events which happen before the user is known, such as OTP requests
+	UserID    bson.ObjectID `json:"user_id,omitzero" bson:"user_id,omitempty"`
+	Phone     string        `json:"phone" bson:"phone"`
+	Type      string        `json:"type" bson:"type"`
+	Channel   string        `json:"channel" bson:"channel"`
+	Outcome   string        `json:"outcome" bson:"outcome"`
+	IP        string        `json:"ip,omitempty" bson:"ip,omitempty"`
+	UserAgent string        `json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+	// LatencyMS is the time which handling the request took in milliseconds
+	LatencyMS int64     `json:"latency_ms" bson:"latency_ms"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	// ExpiresAt is the end of the retention period, expired events are removed automatically
+	ExpiresAt time.Time `json:"-" bson:"expires_at"`
+}
+
+// types of login events
+const (
+	EventOTPRequest  = "otp_request"
+	EventOTPVerify   = "otp_verify"
+	EventTokenIssued = "token_issued"
+)
+
+// channels which a user logs in through
+const (
+	// ChannelAPI is logging in directly by /login and /check
+	ChannelAPI = "api"
+	// ChannelOIDC is logging in to an OpenID Connect client
+	ChannelOIDC = "oidc"
+)
+
+// outcomes of login events
+const (
+	OutcomeSuccess     = "success"
+	OutcomeFailure     = "failure"
+	OutcomeRateLimited = "rate_limited"
+	// OutcomeBlocked is a rejection because of the account status or a held phone number
+	OutcomeBlocked = "blocked"
+	OutcomeError   = "error"
+)
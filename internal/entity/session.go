@@ -0,0 +1,21 @@
+package entity
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Session defines a device which a user is logged in with.
+// Every issued token is linked to a session by its sid value.
+type Session struct {
+	ID         bson.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID     bson.ObjectID `json:"-" bson:"user_id"`
+	DeviceName string        `json:"device_name,omitempty" bson:"device_name,omitempty"`
+	IP         string        `json:"ip,omitempty" bson:"ip,omitempty"`
+	UserAgent  string        `json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+	CreatedAt  time.Time     `json:"created_at" bson:"created_at"`
+	LastSeenAt time.Time     `json:"last_seen_at" bson:"last_seen_at"`
+	// ExpiresAt is the expiration time of the token, expired sessions are removed automatically
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+}
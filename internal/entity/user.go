@@ -8,8 +8,55 @@ import (
 
 // User defines user structure
 type User struct {
-	ID           bson.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	ID           bson.ObjectID `json:"id,omitzero" bson:"_id,omitempty"`
 	Phone        string        `json:"phone,omitempty" bson:"phone,omitempty"`
-	RegisteredAt time.Time     `json:"register_at,omitempty" bson:"register_at,omitempty"`
-	LastLogin    time.Time     `json:"last_login,omitempty" bson:"last_login,omitempty"`
+	RegisteredAt time.Time     `json:"register_at,omitzero" bson:"register_at,omitempty"`
+	LastLogin    time.Time     `json:"last_login,omitzero" bson:"last_login,omitempty"`
+	// profile fields which are set by the user
+	DisplayName string   `json:"display_name,omitempty" bson:"display_name,omitempty"`
+	Email       string   `json:"email,omitempty" bson:"email,omitempty"`
+	Locale      string   `json:"locale,omitempty" bson:"locale,omitempty"`
+	AvatarURL   string   `json:"avatar_url,omitempty" bson:"avatar_url,omitempty"`
+	Roles       []string `json:"roles,omitempty" bson:"roles,omitempty"`
+	Status      string   `json:"status,omitempty" bson:"status,omitempty"`
+}
+
+// available user roles
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+// Roles lists every valid role
+var Roles = []string{RoleUser, RoleSupport, RoleAdmin}
+
+// GetRoles returns the roles of the user.
+// Users which are registered before roles were introduced have only the user role.
+func (u *User) GetRoles() []string {
+	if len(u.Roles) == 0 {
+		return []string{RoleUser}
+	}
+	return u.Roles
+}
+
+// available account statuses
+const (
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+	StatusBanned    = "banned"
+	// StatusDeleted marks a soft deleted account, the document is kept but the user can't log in
+	StatusDeleted = "deleted"
+)
+
+// Statuses lists every valid account status
+var Statuses = []string{StatusActive, StatusSuspended, StatusBanned, StatusDeleted}
+
+// GetStatus returns the account status of the user.
+// Users which are registered before statuses were introduced are active.
+func (u *User) GetStatus() string {
+	if len(u.Status) == 0 {
+		return StatusActive
+	}
+	return u.Status
 }
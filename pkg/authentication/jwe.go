@@ -0,0 +1,113 @@
+package authentication
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// supported JWE key management algorithms
+const (
+	EncryptionDirect  = "dir"
+	EncryptionRSAOAEP = "RSA-OAEP-256"
+)
+
+// jwe holds the keys which are used for encrypting tokens.
+// Signed tokens are wrapped as the payload of a JWE (nested JWT).
+type jwe struct {
+	algorithm jose.KeyAlgorithm
+	// encryptKey is either the shared key or the RSA public key
+	encryptKey any
+	// decryptKey is either the shared key or the RSA private key
+	decryptKey any
+}
+
+// WithDirectEncryption enables dir+A256GCM encryption for every token.
+// The key must be 32 bytes.
+func WithDirectEncryption(key []byte) JWTOption {
+	return func(j *JWT) error {
+		if len(key) != 32 {
+			return fmt.Errorf("direct encryption key must be 32 bytes, got %d", len(key))
+		}
+		j.jwe = &jwe{
+			algorithm:  jose.DIRECT,
+			encryptKey: key,
+			decryptKey: key,
+		}
+		return nil
+	}
+}
+
+// WithRSAEncryption enables RSA-OAEP-256+A256GCM encryption for every token.
+func WithRSAEncryption(key *rsa.PrivateKey) JWTOption {
+	return func(j *JWT) error {
+		if key == nil {
+			return errors.New("rsa encryption key is nil")
+		}
+		j.jwe = &jwe{
+			algorithm:  jose.RSA_OAEP_256,
+			encryptKey: &key.PublicKey,
+			decryptKey: key,
+		}
+		return nil
+	}
+}
+
+func (e *jwe) encrypt(signed string) (string, error) {
+	opts := (&jose.EncrypterOptions{}).WithContentType("JWT").WithType("JWT")
+	encrypter, err := jose.NewEncrypter(jose.A256GCM, jose.Recipient{Algorithm: e.algorithm, Key: e.encryptKey}, opts)
+	if err != nil {
+		return "", errors.Join(errors.New("err when creating encrypter"), err)
+	}
+	object, err := encrypter.Encrypt([]byte(signed))
+	if err != nil {
+		return "", errors.Join(errors.New("err when encrypting the token"), err)
+	}
+	result, err := object.CompactSerialize()
+	if err != nil {
+		return "", errors.Join(errors.New("err when serializing the encrypted token"), err)
+	}
+	return result, nil
+}
+
+func (e *jwe) decrypt(input string) (string, error) {
+	object, err := jose.ParseEncryptedCompact(input, []jose.KeyAlgorithm{e.algorithm}, []jose.ContentEncryption{jose.A256GCM})
+	if err != nil {
+		return "", errors.Join(errors.New("err when parsing encrypted token"), err)
+	}
+	signed, err := object.Decrypt(e.decryptKey)
+	if err != nil {
+		return "", errors.Join(errors.New("err when decrypting the token"), err)
+	}
+	return string(signed), nil
+}
+
+// isEncrypted reports whether the input is a compact JWE which has five parts instead of three
+func isEncrypted(input string) bool {
+	return strings.Count(input, ".") == 4
+}
+
+// ParseRSAPrivateKey parses a PEM encoded RSA private key in either PKCS#1 or PKCS#8 format
+func ParseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Join(errors.New("err when parsing private key"), err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not RSA")
+	}
+	return key, nil
+}
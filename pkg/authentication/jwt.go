@@ -3,6 +3,7 @@ package authentication
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"time"
 
@@ -16,12 +17,22 @@ type CustomClaim struct {
 
 type JWT struct {
 	secret []byte
+	// jwe is nil when encryption is disabled
+	jwe *jwe
 }
 
-func NewJWT(key string) (*JWT, error) {
-	return &JWT{
+type JWTOption func(*JWT) error
+
+func NewJWT(key string, opts ...JWTOption) (*JWT, error) {
+	j := &JWT{
 		secret: []byte(key),
-	}, nil
+	}
+	for _, opt := range opts {
+		if err := opt(j); err != nil {
+			return nil, errors.Join(errors.New("err when applying JWT option"), err)
+		}
+	}
+	return j, nil
 }
 func GenerateKey(length int) (string, error) {
 	key := make([]byte, length)
@@ -32,10 +43,41 @@ func GenerateKey(length int) (string, error) {
 	return base64.StdEncoding.EncodeToString(key), nil
 }
 
-func (j *JWT) NewToken(value map[string]string, d time.Duration) (string, error) {
+// generateTokenID returns a random identifier which is used as jti claim
+func generateTokenID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", errors.Join(errors.New("err when generating token id"), err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+type tokenOption struct {
+	audience []string
+}
+type TokenOption func(*tokenOption)
+
+// WithAudience sets aud claim of the token.
+func WithAudience(audience ...string) TokenOption {
+	return func(to *tokenOption) {
+		to.audience = audience
+	}
+}
+
+func (j *JWT) NewToken(value map[string]string, d time.Duration, opts ...TokenOption) (string, error) {
+	option := &tokenOption{}
+	for _, opt := range opts {
+		opt(option)
+	}
+	tokenID, err := generateTokenID()
+	if err != nil {
+		return "", err
+	}
 	claims := CustomClaim{
 		Value: value,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Audience:  option.audience,
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(d)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
@@ -45,9 +87,35 @@ func (j *JWT) NewToken(value map[string]string, d time.Duration) (string, error)
 	if err != nil {
 		return "", errors.Join(errors.New("err when signing the token"), err)
 	}
+	if j.jwe != nil {
+		return j.jwe.encrypt(result)
+	}
 	return result, nil
 }
+
+// Parse validates the token and returns its custom values
 func (j *JWT) Parse(input string) (map[string]string, error) {
+	claims, err := j.ParseClaims(input)
+	if err != nil {
+		return nil, err
+	}
+	return claims.Value, nil
+}
+
+// ParseClaims validates the token and returns all of its claims,
+// including registered ones such as jti and exp.
+// Encrypted tokens are decrypted before validation.
+func (j *JWT) ParseClaims(input string) (*CustomClaim, error) {
+	if isEncrypted(input) {
+		if j.jwe == nil {
+			return nil, errors.New("encrypted tokens are not supported")
+		}
+		signed, err := j.jwe.decrypt(input)
+		if err != nil {
+			return nil, err
+		}
+		input = signed
+	}
 	token, err := jwt.ParseWithClaims(input, &CustomClaim{}, func(t *jwt.Token) (interface{}, error) {
 		//check if the token signature is valid
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -59,7 +127,7 @@ func (j *JWT) Parse(input string) (map[string]string, error) {
 		return nil, errors.Join(errors.New("err when parsing token"), err)
 	}
 	if claims, ok := token.Claims.(*CustomClaim); ok {
-		return claims.Value, nil
+		return claims, nil
 	} else {
 		return nil, errors.New("invalid claim")
 	}
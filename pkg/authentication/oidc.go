@@ -0,0 +1,76 @@
+package authentication
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// IDTokenClaims defines the claims of an OpenID Connect ID token
+type IDTokenClaims struct {
+	Nonce               string `json:"nonce,omitempty"`
+	AuthTime            int64  `json:"auth_time,omitempty"`
+	PhoneNumber         string `json:"phone_number,omitempty"`
+	PhoneNumberVerified bool   `json:"phone_number_verified,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// IDTokenSigner signs ID tokens with RS256 so relying parties can verify them by the published JWKS
+type IDTokenSigner struct {
+	key   *rsa.PrivateKey
+	keyID string
+}
+
+func NewIDTokenSigner(key *rsa.PrivateKey) (*IDTokenSigner, error) {
+	if key == nil {
+		return nil, errors.New("id token signing key is nil")
+	}
+	// key ID is derived from the public key so it changes only when the key changes
+	jwk := jose.JSONWebKey{Key: &key.PublicKey}
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, errors.Join(errors.New("err when computing key thumbprint"), err)
+	}
+	return &IDTokenSigner{
+		key:   key,
+		keyID: base64.RawURLEncoding.EncodeToString(thumbprint),
+	}, nil
+}
+
+// Sign issues an ID token for the subject and audience which is valid for d
+func (s *IDTokenSigner) Sign(claims IDTokenClaims, d time.Duration) (string, error) {
+	now := time.Now()
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(d))
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.keyID
+	result, err := token.SignedString(s.key)
+	if err != nil {
+		return "", errors.Join(errors.New("err when signing the id token"), err)
+	}
+	return result, nil
+}
+
+// JWKS returns the public key set which is used for verifying ID tokens
+func (s *IDTokenSigner) JWKS() jose.JSONWebKeySet {
+	return jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{{
+			Key:       &s.key.PublicKey,
+			KeyID:     s.keyID,
+			Algorithm: string(jose.RS256),
+			Use:       "sig",
+		}},
+	}
+}
+
+// VerifyPKCE checks an RFC 7636 code verifier against an S256 code challenge
+func VerifyPKCE(verifier, challenge string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:]) == challenge
+}
@@ -0,0 +1,401 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aph138/dekamond/internal/app"
+	"github.com/aph138/dekamond/internal/cache"
+	"github.com/aph138/dekamond/internal/entity"
+)
+
+// newToken saves a user with the phone number and returns a token with a session for it
+func newToken(t *testing.T, phone string, roles ...string) (string, string) {
+	t.Helper()
+	user, err := myDB.SaveUser(phone)
+	if err != nil {
+		t.Fatalf("err when saving user %s", err.Error())
+	}
+	userID := user.ID.Hex()
+	sessionID, err := myDB.CreateSession(&entity.Session{
+		UserID:    user.ID,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("err when creating session %s", err.Error())
+	}
+	token, err := myJWT.NewToken(map[string]string{
+		"id":    userID,
+		"roles": strings.Join(roles, ","),
+		"scope": app.FirstPartyScope,
+		"sid":   sessionID,
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("err when generating token %s", err.Error())
+	}
+	return token, userID
+}
+
+func TestSearchRequiresAdmin(t *testing.T) {
+	userToken, _ := newToken(t, "09000000001", entity.RoleUser)
+	adminToken, _ := newToken(t, "09000000002", entity.RoleAdmin)
+
+	cases := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"malformed token", "Bearer invalid", http.StatusUnauthorized},
+		{"user token", "Bearer " + userToken, http.StatusForbidden},
+		{"admin token", "Bearer " + adminToken, http.StatusOK},
+	}
+	handler := myApp.Routes()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/search", nil)
+			if c.header != "" {
+				req.Header.Set("Authorization", c.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != c.status {
+				t.Fatalf("expected %d status code but got %d", c.status, w.Code)
+			}
+		})
+	}
+}
+
+func introspect(t *testing.T, handler http.Handler, clientSecret, token string) (*httptest.ResponseRecorder, app.IntrospectionResponse) {
+	t.Helper()
+	form := url.Values{"token": {token}}
+	req := httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(introspectionClientID, clientSecret)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var res app.IntrospectionResponse
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatalf("err when decoding introspection response %s", err.Error())
+		}
+	}
+	return w, res
+}
+
+func TestIntrospection(t *testing.T) {
+	handler := myApp.Routes()
+	token, userID := newToken(t, "09000000003", entity.RoleUser)
+
+	// wrong client secret
+	w, _ := introspect(t, handler, "wrong", token)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 status code but got %d", w.Code)
+	}
+
+	// valid token
+	w, res := introspect(t, handler, introspectionClientSecret, token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 status code but got %d", w.Code)
+	}
+	if !res.Active || res.Sub != userID || res.Exp == 0 || res.Scope != app.FirstPartyScope {
+		t.Fatalf("expected an active token for %s but got %+v", userID, res)
+	}
+
+	// revoke the token
+	req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	lw := httptest.NewRecorder()
+	handler.ServeHTTP(lw, req)
+	if lw.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 status code but got %d", lw.Code)
+	}
+
+	// revoked token
+	_, res = introspect(t, handler, introspectionClientSecret, token)
+	if res.Active {
+		t.Fatalf("expected revoked token to be inactive")
+	}
+
+	// garbage token
+	_, res = introspect(t, handler, introspectionClientSecret, "invalid")
+	if res.Active {
+		t.Fatalf("expected invalid token to be inactive")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	handler := myApp.Routes()
+	token, _ := newToken(t, "09000000004", entity.RoleUser)
+	otherToken, _ := newToken(t, "09000000004", entity.RoleUser)
+
+	// list sessions
+	req := httptest.NewRequest(http.MethodGet, "/me/sessions", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 status code but got %d", w.Code)
+	}
+	var res app.SessionsResponse
+	json.NewDecoder(w.Body).Decode(&res)
+	if len(res.Result) < 2 {
+		t.Fatalf("expected at least 2 sessions but got %d", len(res.Result))
+	}
+	var otherSessionID string
+	for _, s := range res.Result {
+		if !s.Current {
+			otherSessionID = s.ID.Hex()
+		}
+	}
+
+	// sign out the other device
+	req = httptest.NewRequest(http.MethodDelete, "/me/sessions/"+otherSessionID, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 status code but got %d", w.Code)
+	}
+
+	// token of the deleted session is rejected
+	req = httptest.NewRequest(http.MethodGet, "/me/sessions", nil)
+	req.Header.Set("Authorization", "Bearer "+otherToken)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 status code but got %d", w.Code)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	handler := myApp.Routes()
+	adminToken, adminID := newToken(t, "09000000006", entity.RoleAdmin)
+	userToken, userID := newToken(t, "09000000007", entity.RoleUser)
+
+	roleRequest := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := roleRequest(http.MethodPut, "/users/"+userID+"/roles/admin", userToken); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 status code but got %d", w.Code)
+	}
+	if w := roleRequest(http.MethodPut, "/users/"+userID+"/roles/owner", adminToken); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 status code but got %d", w.Code)
+	}
+	w := roleRequest(http.MethodPut, "/users/"+userID+"/roles/support", adminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 status code but got %d", w.Code)
+	}
+	var res app.UserResponse
+	json.NewDecoder(w.Body).Decode(&res)
+	if !slices.Contains(res.Result.Roles, entity.RoleSupport) || !slices.Contains(res.Result.Roles, entity.RoleUser) {
+		t.Fatalf("expected user and support roles but got %v", res.Result.Roles)
+	}
+
+	// new tokens carry the role
+	code, err := myCache.NewOTPCode("09000000007")
+	if err != nil {
+		t.Fatalf("err when generating otp code %s", err.Error())
+	}
+	body, _ := json.Marshal(app.CheckRequest{Phone: "09000000007", Code: code})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/check", strings.NewReader(string(body))))
+	value, err := myJWT.Parse(w.Body.String())
+	if err != nil {
+		t.Fatalf("err when parsing token %s", err.Error())
+	}
+	if !strings.Contains(value["roles"], entity.RoleSupport) {
+		t.Fatalf("expected support role in token but got %s", value["roles"])
+	}
+	if value["scope"] != app.FirstPartyScope {
+		t.Fatalf("expected first party scope in token but got %s", value["scope"])
+	}
+
+	// revoking signs out the user
+	if w := roleRequest(http.MethodDelete, "/users/"+userID+"/roles/support", adminToken); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 status code but got %d", w.Code)
+	}
+	if w := roleRequest(http.MethodGet, "/me", userToken); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 status code but got %d", w.Code)
+	}
+
+	// admins can't lock themselves out
+	if w := roleRequest(http.MethodDelete, "/users/"+adminID+"/roles/admin", adminToken); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 status code but got %d", w.Code)
+	}
+}
+
+func TestAccountStatus(t *testing.T) {
+	handler := myApp.Routes()
+	adminToken, adminID := newToken(t, "09000000008", entity.RoleAdmin)
+	userToken, userID := newToken(t, "09000000009", entity.RoleUser)
+
+	send := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send(http.MethodPut, "/users/"+userID+"/status", adminToken, `{"status":"frozen"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 status code but got %d", w.Code)
+	}
+	if w := send(http.MethodPut, "/users/"+adminID+"/status", adminToken, `{"status":"banned"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 status code but got %d", w.Code)
+	}
+	if w := send(http.MethodPut, "/users/"+userID+"/status", adminToken, `{"status":"suspended"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 status code but got %d", w.Code)
+	}
+
+	// existing tokens are rejected with the status
+	w := send(http.MethodGet, "/me", userToken, "")
+	var oauthErr app.OAuthError
+	json.NewDecoder(w.Body).Decode(&oauthErr)
+	if w.Code != http.StatusForbidden || oauthErr.Error != "account_suspended" {
+		t.Fatalf("expected account_suspended but got %d %s", w.Code, oauthErr.Error)
+	}
+
+	// the user can't log in and the last login isn't changed
+	before, err := myDB.FindUser(userID)
+	if err != nil {
+		t.Fatalf("err when finding user %s", err.Error())
+	}
+	code, err := myCache.NewOTPCode("09000000009")
+	if err != nil {
+		t.Fatalf("err when generating otp code %s", err.Error())
+	}
+	body, _ := json.Marshal(app.CheckRequest{Phone: "09000000009", Code: code})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/check", strings.NewReader(string(body))))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 status code but got %d", w.Code)
+	}
+	if after, err := myDB.FindUser(userID); err != nil || !after.LastLogin.Equal(before.LastLogin) {
+		t.Fatalf("expected last login %s but got %v %v", before.LastLogin, after, err)
+	}
+
+	// filtering by status
+	w = send(http.MethodGet, "/search?status=suspended", adminToken, "")
+	var res app.SearchResponse
+	json.NewDecoder(w.Body).Decode(&res)
+	if len(res.Result) != 1 || res.Result[0].ID.Hex() != userID {
+		t.Fatalf("expected only the suspended user but got %+v", res.Result)
+	}
+
+	// reactivated users can use their tokens again
+	if w := send(http.MethodPut, "/users/"+userID+"/status", adminToken, `{"status":"active"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 status code but got %d", w.Code)
+	}
+	if w := send(http.MethodGet, "/me", userToken, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 status code but got %d", w.Code)
+	}
+}
+
+func TestLoginEvents(t *testing.T) {
+	handler := myApp.Routes()
+	phone := "09000000010"
+	supportToken, _ := newToken(t, "09000000011", entity.RoleSupport)
+	userToken, _ := newToken(t, "09000000012", entity.RoleUser)
+
+	post := func(path string, v any) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(v)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(string(body))))
+		return w
+	}
+	if w := post("/login", app.LoginRequest{Phone: phone}); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 status code but got %d", w.Code)
+	}
+	if w := post("/check", app.CheckRequest{Phone: phone, Code: "wrong"}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 status code but got %d", w.Code)
+	}
+	w := post("/check", app.CheckRequest{Phone: phone, Code: otpCode(t, phone, cache.PurposeLogin)})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 status code but got %d", w.Code)
+	}
+	value, err := myJWT.Parse(w.Body.String())
+	if err != nil {
+		t.Fatalf("err when parsing token %s", err.Error())
+	}
+
+	getEvents := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/users/"+value["id"]+"/events", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	if w := getEvents(userToken); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 status code but got %d", w.Code)
+	}
+	w = getEvents(supportToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 status code but got %d", w.Code)
+	}
+	var res app.LoginEventsResponse
+	json.NewDecoder(w.Body).Decode(&res)
+
+	// newest first
+	expected := []struct{ eventType, outcome string }{
+		{entity.EventTokenIssued, entity.OutcomeSuccess},
+		{entity.EventOTPVerify, entity.OutcomeSuccess},
+		{entity.EventOTPVerify, entity.OutcomeFailure},
+		{entity.EventOTPRequest, entity.OutcomeSuccess},
+	}
+	if len(res.Result) != len(expected) {
+		t.Fatalf("expected %d events but got %d", len(expected), len(res.Result))
+	}
+	for i, e := range expected {
+		event := res.Result[i]
+		if event.Type != e.eventType || event.Outcome != e.outcome || event.Channel != entity.ChannelAPI || event.Phone != "0900***0010" {
+			t.Fatalf("expected %s %s event but got %+v", e.eventType, e.outcome, event)
+		}
+	}
+}
+
+func TestLoginEventPhoneMask(t *testing.T) {
+	handler, database, adminToken := searchApp(t)
+	supportToken := roleToken(t, database, "09000000013", entity.RoleSupport)
+	user, err := database.SaveUser("09000000014")
+	if err != nil {
+		t.Fatalf("err when saving user %s", err.Error())
+	}
+	now := time.Now()
+	if err := database.SaveLoginEvent(&entity.LoginEvent{
+		UserID:    user.ID,
+		Phone:     user.Phone,
+		Type:      entity.EventTokenIssued,
+		Channel:   entity.ChannelAPI,
+		Outcome:   entity.OutcomeSuccess,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}); err != nil {
+		t.Fatalf("err when saving login event %s", err.Error())
+	}
+
+	// support staff get the same masked number as /search
+	for token, phone := range map[string]string{adminToken: "09000000014", supportToken: "0900***0014"} {
+		req := httptest.NewRequest(http.MethodGet, "/users/"+user.ID.Hex()+"/events", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var res app.LoginEventsResponse
+		json.NewDecoder(w.Body).Decode(&res)
+		if w.Code != http.StatusOK || len(res.Result) != 1 || res.Result[0].Phone != phone {
+			t.Fatalf("expected one event with %s but got %d %+v", phone, w.Code, res.Result)
+		}
+	}
+}
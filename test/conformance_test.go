@@ -0,0 +1,111 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aph138/dekamond/internal/cache"
+	"github.com/aph138/dekamond/internal/cache/cachetest"
+	"github.com/aph138/dekamond/internal/db"
+	"github.com/aph138/dekamond/internal/db/dbtest"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func TestMongoConformance(t *testing.T) {
+	databases := 0
+	dbtest.RunDatabaseSuite(t, func(t *testing.T) db.Database {
+		// every test gets its own database
+		databases++
+		dbOpt := options.Client().SetAuth(options.Credential{
+			Username: DBUsername, Password: DBPassword,
+		})
+		mongo, err := db.NewMongo("mongodb://"+mongoEndpoint, fmt.Sprintf("dekamond_suite_%d", databases), time.Second*15, dbOpt)
+		if err != nil {
+			t.Fatalf("err when connecting to mongo %s", err.Error())
+		}
+		t.Cleanup(func() { mongo.Close(context.Background()) })
+		return mongo
+	})
+}
+
+func TestPostgresConformance(t *testing.T) {
+	address, err := setup_postgres()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	conn, err := sql.Open("pgx", address)
+	if err != nil {
+		t.Fatalf("err when connecting to postgres %s", err.Error())
+	}
+	defer conn.Close()
+
+	// migrations are applied only once
+	for range 2 {
+		pg, err := db.NewPostgres(address, "", "", time.Second*15)
+		if err != nil {
+			t.Fatalf("err when connecting to postgres %s", err.Error())
+		}
+		pg.Close(context.Background())
+	}
+	dbtest.RunDatabaseSuite(t, func(t *testing.T) db.Database {
+		if _, err := conn.Exec("TRUNCATE users, oauth_clients, sessions, login_events, audit_log"); err != nil {
+			t.Fatalf("err when emptying postgres %s", err.Error())
+		}
+		pg, err := db.NewPostgres(address, "", "", time.Second*15)
+		if err != nil {
+			t.Fatalf("err when connecting to postgres %s", err.Error())
+		}
+		t.Cleanup(func() { pg.Close(context.Background()) })
+		return pg
+	})
+}
+
+func TestSQLiteConformance(t *testing.T) {
+	path := "sqlite://" + t.TempDir() + "/dekamond.db"
+	// migrations are applied only once
+	for range 2 {
+		lite, err := db.NewSQLite(path, time.Second*15)
+		if err != nil {
+			t.Fatalf("err when opening sqlite %s", err.Error())
+		}
+		lite.Close(context.Background())
+	}
+	dbtest.RunDatabaseSuite(t, func(t *testing.T) db.Database {
+		lite, err := db.NewSQLite("sqlite://"+t.TempDir()+"/dekamond.db", time.Second*15)
+		if err != nil {
+			t.Fatalf("err when opening sqlite %s", err.Error())
+		}
+		t.Cleanup(func() { lite.Close(context.Background()) })
+		return lite
+	})
+}
+
+func TestRedisConformance(t *testing.T) {
+	cachetest.RunCacheSuite(t, func(t *testing.T, opts ...cache.Option) cache.Cache {
+		// DB 2 isn't used by the application under test
+		redisOpt := &redis.Options{Addr: redisEndpoint, DB: 2}
+		client := redis.NewClient(redisOpt)
+		defer client.Close()
+		if err := client.FlushDB(context.Background()).Err(); err != nil {
+			t.Fatalf("err when emptying redis %s", err.Error())
+		}
+		myRedis, err := cache.NewRedis(redisOpt, opts...)
+		if err != nil {
+			t.Fatalf("err when connecting to redis %s", err.Error())
+		}
+		t.Cleanup(func() { myRedis.Close(context.Background()) })
+		return myRedis
+	})
+}
+
+func TestMemoryConformance(t *testing.T) {
+	cachetest.RunCacheSuite(t, func(t *testing.T, opts ...cache.Option) cache.Cache {
+		memory := cache.NewMemory(opts...)
+		t.Cleanup(func() { memory.Close(context.Background()) })
+		return memory
+	})
+}
@@ -0,0 +1,111 @@
+package test
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aph138/dekamond/internal/db"
+	"github.com/aph138/dekamond/internal/entity"
+)
+
+func TestExportUsers(t *testing.T) {
+	handler, database, token := searchApp(t)
+	for _, phone := range []string{"09000000401", "09000000402", "09000000403"} {
+		if _, err := database.SaveUser(phone); err != nil {
+			t.Fatalf("err when saving user %s", err.Error())
+		}
+	}
+	suspended, _ := database.SaveUser("09000000404")
+	if _, err := database.SetUserStatus(suspended.ID.Hex(), entity.StatusSuspended); err != nil {
+		t.Fatalf("err when setting user status %s", err.Error())
+	}
+	display := "=HYPERLINK(\"x\")"
+	if _, err := database.UpdateUser(suspended.ID.Hex(), db.UserUpdate{DisplayName: &display}); err != nil {
+		t.Fatalf("err when updating user %s", err.Error())
+	}
+	export := func(token string, query url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/users/export?"+query.Encode(), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := export(token, url.Values{"sort": {"register_at"}})
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("expected a csv export but got %d %s", w.Code, w.Body.String())
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("err when reading csv %s", err.Error())
+	}
+	// the header, the admin and the users
+	if len(rows) != 6 || rows[0][1] != "phone" || rows[1][1] != "09000000400" || rows[5][1] != "09000000404" {
+		t.Fatalf("unexpected csv export %v", rows)
+	}
+	// formulas aren't run by spreadsheets
+	if rows[5][4] != "'"+display || rows[5][9] != entity.StatusSuspended {
+		t.Fatalf("unexpected csv row %v", rows[5])
+	}
+
+	w = export(token, url.Values{"format": {"ndjson"}, "status": {"active"}, "phone_prefix": {"0900000040"}})
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("expected an ndjson export but got %d %s", w.Code, w.Body.String())
+	}
+	phones := []string{}
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var user entity.User
+		if err := json.Unmarshal(scanner.Bytes(), &user); err != nil {
+			t.Fatalf("err when decoding ndjson line %s", err.Error())
+		}
+		phones = append(phones, user.Phone)
+	}
+	// newest first
+	if strings.Join(phones, ",") != "09000000403,09000000402,09000000401,09000000400" {
+		t.Fatalf("unexpected ndjson export %v", phones)
+	}
+
+	// only the header is written if no user matches
+	w = export(token, url.Values{"status": {"banned"}})
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != strings.Join([]string{
+		"id", "phone", "register_at", "last_login", "display_name", "email", "locale", "avatar_url", "roles", "status",
+	}, ",") {
+		t.Fatalf("expected only the csv header but got %d %s", w.Code, w.Body.String())
+	}
+
+	for _, query := range []url.Values{
+		{"format": {"xml"}},
+		{"status": {"unknown"}},
+		{"phone_prefix": {"09"}},
+	} {
+		if w := export(token, query); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %v but got %d", query, w.Code)
+		}
+	}
+	supportToken := roleToken(t, database, "09000000405", entity.RoleSupport)
+	if w := export(supportToken, url.Values{}); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for support but got %d", w.Code)
+	}
+
+	// rejected exports aren't audited
+	records, err := database.ListAuditRecords(0)
+	if err != nil {
+		t.Fatalf("err when listing audit records %s", err.Error())
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 audit records but got %+v", records)
+	}
+	ndjson := records[1]
+	if ndjson.Action != entity.AuditUserExport || ndjson.ActorID.IsZero() || ndjson.Details["format"] != "ndjson" ||
+		ndjson.Details["status"] != "active" || ndjson.Details["phone_prefix"] != "0900000040" {
+		t.Fatalf("unexpected audit record %+v", ndjson)
+	}
+}
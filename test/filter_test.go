@@ -0,0 +1,37 @@
+package test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aph138/dekamond/internal/entity"
+)
+
+func TestSearchFilterExpression(t *testing.T) {
+	handler, database, token := searchApp(t)
+	support := roleToken(t, database, "09000000701", entity.RoleSupport)
+	if _, err := database.SaveUser("09000000702"); err != nil {
+		t.Fatalf("err when saving user %s", err.Error())
+	}
+
+	w, res := search(t, handler, token, url.Values{"q": {"role:admin OR role:support"}, "sort": {"register_at"}})
+	if w.Code != http.StatusOK || len(res.Result) != 2 || res.Result[0].Phone != "09000000400" || res.Result[1].Phone != "09000000701" {
+		t.Fatalf("expected the admin and support but got %d %s", w.Code, w.Body.String())
+	}
+	// the expression is joined with the other filters
+	if w, res := search(t, handler, token, url.Values{"q": {"NOT role:admin"}, "phone_prefix": {"0900000070"}}); w.Code != http.StatusOK ||
+		len(res.Result) != 2 || *res.Total != 2 {
+		t.Fatalf("expected 2 users but got %d %s", w.Code, w.Body.String())
+	}
+	w, _ = search(t, handler, token, url.Values{"q": {"role:admin AND register_at>=2025-13-01"}})
+	if w.Code != http.StatusBadRequest ||
+		strings.TrimSpace(w.Body.String()) != "invalid value for q: register_at must be a YYYY-MM-DD date or an RFC 3339 datetime at position 29" {
+		t.Fatalf("expected 400 with the position but got %d %s", w.Code, w.Body.String())
+	}
+	// support staff could find hidden fields by filtering them
+	if w, _ := search(t, handler, support, url.Values{"q": {"email:ali@example.com"}}); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for support but got %d", w.Code)
+	}
+}
@@ -0,0 +1,157 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aph138/dekamond/internal/app"
+	"github.com/aph138/dekamond/internal/cache"
+	"github.com/aph138/dekamond/internal/db"
+	"github.com/aph138/dekamond/internal/entity"
+)
+
+func TestImportUsers(t *testing.T) {
+	handler, database, token := searchApp(t)
+	existing, err := database.SaveUser("09000000601")
+	if err != nil {
+		t.Fatalf("err when saving user %s", err.Error())
+	}
+	post := func(token, format, body string) (*httptest.ResponseRecorder, app.ImportReport) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/users/import?format="+format, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var res app.ImportResponse
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatalf("err when decoding import response %s", err.Error())
+			}
+		}
+		return w, res.Result
+	}
+
+	csvFile := strings.Join([]string{
+		"Phone,register_at,name",
+		"09000000601,2020-01-01,existing",
+		"+98 900 000 0602,2020-01-02,international",
+		"۰۹۰۰۰۰۰۰۶۰۳,,persian digits",
+		"0900000060,2020-01-01,short",
+		"09000000604,someday,bad date",
+		"0900-000-0602,,duplicate",
+		"09000000605,2999-01-01,future",
+	}, "\n")
+	w, report := post(token, "csv", csvFile)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 status code but got %d %s", w.Code, w.Body.String())
+	}
+	if report.Created != 2 || report.Existing != 1 || report.Invalid != 4 || len(report.Rows) != 7 {
+		t.Fatalf("expected 2 created, 1 existing and 4 invalid rows but got %+v", report)
+	}
+	expected := []app.ImportRow{
+		{Line: 2, Phone: "09000000601", Outcome: app.ImportExisting},
+		{Line: 3, Phone: "09000000602", Outcome: app.ImportCreated},
+		{Line: 4, Phone: "09000000603", Outcome: app.ImportCreated},
+		{Line: 5, Outcome: app.ImportInvalid, Error: "invalid phone number"},
+		{Line: 6, Phone: "09000000604", Outcome: app.ImportInvalid},
+		{Line: 7, Phone: "09000000602", Outcome: app.ImportInvalid, Error: "duplicate of line 3"},
+		{Line: 8, Phone: "09000000605", Outcome: app.ImportInvalid},
+	}
+	for i, row := range report.Rows {
+		if row.Line != expected[i].Line || row.Phone != expected[i].Phone || row.Outcome != expected[i].Outcome ||
+			(len(expected[i].Error) > 0 && row.Error != expected[i].Error) || (row.Outcome == app.ImportInvalid) != (len(row.Error) > 0) {
+			t.Fatalf("expected %+v but got %+v", expected[i], row)
+		}
+	}
+	page, err := database.SearchUser(db.SearchUserByPhone("09000000602"))
+	if err != nil || len(page.Users) != 1 || !page.Users[0].RegisteredAt.Equal(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected the imported user with its registration date but got %+v %v", page, err)
+	}
+	if user, _ := database.FindUser(existing.ID.Hex()); !user.RegisteredAt.Equal(existing.RegisteredAt) {
+		t.Fatalf("expected the existing user to be unchanged but got %+v", user)
+	}
+
+	// a malformed row is reported and the next rows are imported
+	w, report = post(token, "csv", "phone,name\n09000000609\"bad,quote\n09000000610,good")
+	if w.Code != http.StatusOK || report.Created != 1 || report.Invalid != 1 || len(report.Rows) != 2 ||
+		report.Rows[0].Line != 2 || report.Rows[0].Outcome != app.ImportInvalid || report.Rows[1].Line != 3 {
+		t.Fatalf("expected the malformed row to be invalid but got %d %+v", w.Code, report)
+	}
+
+	// exports can be imported
+	ndjson := `{"phone":"09000000606","register_at":"2021-05-06T07:08:09Z"}` + "\n\n" + `{"phone":"09000000602"}` + "\nnot json\n"
+	w, report = post(token, "ndjson", ndjson)
+	if w.Code != http.StatusOK || report.Created != 1 || report.Existing != 1 || report.Invalid != 1 || report.Rows[2].Line != 4 {
+		t.Fatalf("expected 1 created, 1 existing and 1 invalid rows but got %d %+v", w.Code, report)
+	}
+
+	for _, test := range []struct{ format, body string }{
+		{"xml", "phone\n09000000607"},
+		{"csv", "number\n09000000607"},
+		{"csv", ""},
+	} {
+		if w, _ := post(token, test.format, test.body); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 status code for %q but got %d", test.body, w.Code)
+		}
+	}
+	supportToken := roleToken(t, database, "09000000608", entity.RoleSupport)
+	if w, _ := post(supportToken, "csv", "phone\n09000000607"); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 status code for support but got %d", w.Code)
+	}
+
+	records, err := database.ListAuditRecords(0)
+	if err != nil {
+		t.Fatalf("err when listing audit records %s", err.Error())
+	}
+	if len(records) != 3 || records[2].Action != entity.AuditUserImport || records[2].Details["created"] != "2" || records[2].Details["invalid"] != "4" {
+		t.Fatalf("expected an audit record of every import but got %+v", records)
+	}
+}
+
+// failingImport is a database which can't import users
+type failingImport struct {
+	db.Database
+}
+
+func (failingImport) ImportUsers([]db.UserImport) ([]bool, error) {
+	return nil, errors.New("database is unavailable")
+}
+
+func TestImportUsersFailure(t *testing.T) {
+	lite, err := db.NewSQLite("sqlite://"+t.TempDir()+"/dekamond.db", time.Second*15)
+	if err != nil {
+		t.Fatalf("err when opening sqlite %s", err.Error())
+	}
+	memory := cache.NewMemory()
+	t.Cleanup(func() {
+		memory.Close(context.Background())
+		lite.Close(context.Background())
+	})
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// the rows which aren't written are left out of the report
+	failing := app.NewApplication(logger, myJWT, memory, failingImport{lite})
+	report, err := failing.ImportUsers(app.FormatCSV, strings.NewReader("phone\n09000000611\nbad\n09000000612"))
+	if err == nil || len(report.Rows) != 1 || report.Rows[0].Line != 3 || report.Rows[0].Outcome != app.ImportInvalid {
+		t.Fatalf("expected only the invalid row with an error but got %v %+v", err, report)
+	}
+
+	// the rows before a read error are imported
+	importer := app.NewApplication(logger, myJWT, memory, lite)
+	body := `{"phone":"09000000613"}` + "\n" + strings.Repeat("x", 64<<10+1)
+	report, err = importer.ImportUsers(app.FormatNDJSON, strings.NewReader(body))
+	if err == nil || report.Created != 1 || len(report.Rows) != 1 || report.Rows[0].Outcome != app.ImportCreated {
+		t.Fatalf("expected the first row to be imported with an error but got %v %+v", err, report)
+	}
+	if page, err := lite.SearchUser(db.SearchUserByPhone("09000000613"), db.SearchUserByPagination(1, 1)); err != nil || len(page.Users) != 1 {
+		t.Fatalf("expected the imported user but got %v %v", page, err)
+	}
+}
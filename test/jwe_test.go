@@ -0,0 +1,140 @@
+package test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aph138/dekamond/internal/app"
+	"github.com/aph138/dekamond/internal/cache"
+	"github.com/aph138/dekamond/internal/db"
+	"github.com/aph138/dekamond/internal/entity"
+	"github.com/aph138/dekamond/pkg/authentication"
+)
+
+func TestEncryptedToken(t *testing.T) {
+	directKey := make([]byte, 32)
+	if _, err := rand.Read(directKey); err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name   string
+		option authentication.JWTOption
+	}{
+		{"dir", authentication.WithDirectEncryption(directKey)},
+		{"rsa-oaep", authentication.WithRSAEncryption(rsaKey)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			j, err := authentication.NewJWT("secret", c.option)
+			if err != nil {
+				t.Fatalf("err when creating JWT instance %s", err.Error())
+			}
+
+			// every token is encrypted
+			token, err := j.NewToken(map[string]string{"id": "user-id"}, time.Minute, authentication.WithAudience("mobile-app"))
+			if err != nil {
+				t.Fatalf("err when generating token %s", err.Error())
+			}
+			if strings.Count(token, ".") != 4 {
+				t.Fatalf("expected a JWE token but got %s", token)
+			}
+			value, err := j.Parse(token)
+			if err != nil {
+				t.Fatalf("err when parsing encrypted token %s", err.Error())
+			}
+			if value["id"] != "user-id" {
+				t.Fatalf("expected user-id but got %s", value["id"])
+			}
+
+			token, err = j.NewToken(map[string]string{"id": "user-id"}, time.Minute)
+			if err != nil {
+				t.Fatalf("err when generating token %s", err.Error())
+			}
+			if strings.Count(token, ".") != 4 {
+				t.Fatalf("expected a JWE token without audience but got %s", token)
+			}
+		})
+	}
+
+	// encrypted tokens can't be parsed without the key
+	encrypter, _ := authentication.NewJWT("secret", authentication.WithDirectEncryption(directKey))
+	token, _ := encrypter.NewToken(map[string]string{"id": "user-id"}, time.Minute, authentication.WithAudience("mobile-app"))
+	plain, _ := authentication.NewJWT("secret")
+	if _, err := plain.Parse(token); err == nil {
+		t.Fatalf("expected an error when parsing encrypted token without key")
+	}
+}
+
+func TestTokenAudience(t *testing.T) {
+	lite, err := db.NewSQLite("sqlite://"+t.TempDir()+"/dekamond.db", time.Second*15)
+	if err != nil {
+		t.Fatalf("err when opening sqlite %s", err.Error())
+	}
+	memory := cache.NewMemory()
+	t.Cleanup(func() {
+		memory.Close(context.Background())
+		lite.Close(context.Background())
+	})
+	handler := app.NewApplication(slog.New(slog.NewTextHandler(io.Discard, nil)), myJWT, memory, lite,
+		app.WithTokenAudiences("mobile-app")).Routes()
+	phone := "09000000901"
+	code, err := memory.NewOTPCode(phone)
+	if err != nil {
+		t.Fatalf("err when generating otp code %s", err.Error())
+	}
+	check := func(audience string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(app.CheckRequest{Phone: phone, Code: code, Audience: audience})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/check", strings.NewReader(string(body))))
+		return w
+	}
+	if w := check("web"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown audience but got %d", w.Code)
+	}
+	// the code isn't used by a rejected request
+	w := check("mobile-app")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 status code but got %d %s", w.Code, w.Body.String())
+	}
+	claims, err := myJWT.ParseClaims(w.Body.String())
+	if err != nil || !slices.Equal(claims.Audience, []string{"mobile-app"}) {
+		t.Fatalf("expected mobile-app audience but got %v %v", claims, err)
+	}
+
+	// tokens with an audience which isn't configured are rejected
+	user, err := lite.GrantRoleByPhone(phone, entity.RoleUser)
+	if err != nil {
+		t.Fatalf("err when finding user %s", err.Error())
+	}
+	token, err := myJWT.NewToken(map[string]string{
+		"id":    user.ID.Hex(),
+		"scope": app.FirstPartyScope,
+		"sid":   claims.Value["sid"],
+	}, time.Minute, authentication.WithAudience("web"))
+	if err != nil {
+		t.Fatalf("err when generating token %s", err.Error())
+	}
+	for token, code := range map[string]int{w.Body.String(): http.StatusOK, token: http.StatusUnauthorized} {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != code {
+			t.Fatalf("expected %d status code but got %d", code, w.Code)
+		}
+	}
+}
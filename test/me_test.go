@@ -0,0 +1,175 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aph138/dekamond/internal/app"
+	"github.com/aph138/dekamond/internal/cache"
+	"github.com/aph138/dekamond/internal/db"
+	"github.com/aph138/dekamond/internal/entity"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestProfile(t *testing.T) {
+	handler := myApp.Routes()
+	token, userID := newToken(t, "09000000101", entity.RoleUser)
+
+	cases := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"invalid email", `{"email":"not-an-email"}`, http.StatusBadRequest},
+		{"invalid locale", `{"locale":"??"}`, http.StatusBadRequest},
+		{"insecure avatar", `{"avatar_url":"http://example.com/a.png"}`, http.StatusBadRequest},
+		{"unknown field", `{"phone":"09000000000"}`, http.StatusBadRequest},
+		{"valid", `{"display_name":" Ali ","email":"Ali@Example.com","locale":"fa-ir"}`, http.StatusOK},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, "/me", strings.NewReader(c.body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != c.status {
+				t.Fatalf("expected %d status code but got %d", c.status, w.Code)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var res app.UserResponse
+	json.NewDecoder(w.Body).Decode(&res)
+	user := res.Result
+	if user.ID.Hex() != userID || user.DisplayName != "Ali" || user.Email != "ali@example.com" || user.Locale != "fa-IR" {
+		t.Fatalf("unexpected profile %+v", user)
+	}
+
+	// empty string removes the field
+	req = httptest.NewRequest(http.MethodPatch, "/me", strings.NewReader(`{"email":""}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	// email is omitted when it's empty, so the response is decoded into a new value
+	var updated app.UserResponse
+	json.NewDecoder(w.Body).Decode(&updated)
+	if w.Code != http.StatusOK || updated.Result.Email != "" || updated.Result.DisplayName != "Ali" {
+		t.Fatalf("expected only email to be removed but got %d %+v", w.Code, updated.Result)
+	}
+}
+
+// otpCode reads an OTP code which is generated for the purpose directly from redis
+func otpCode(t *testing.T, phone, purpose string) string {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: redisEndpoint, DB: 1})
+	defer client.Close()
+	code, err := client.Get(context.Background(), "otp:"+phone+":"+purpose).Result()
+	if err != nil {
+		t.Fatalf("err when reading otp code %s", err.Error())
+	}
+	return code
+}
+
+func TestPhoneChange(t *testing.T) {
+	handler := myApp.Routes()
+	oldPhone, newPhone := "09000000102", "09000000103"
+	token, userID := newToken(t, oldPhone, entity.RoleUser)
+	takenToken, _ := newToken(t, "09000000104", entity.RoleUser)
+
+	send := func(path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// a number of another user is not available
+	if w := send("/me/phone", token, `{"phone":"09000000104"}`); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 status code but got %d", w.Code)
+	}
+	if w := send("/me/phone", token, `{"phone":"`+newPhone+`"}`); w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 status code but got %d", w.Code)
+	}
+
+	// both codes are required
+	newCode := otpCode(t, newPhone, cache.PurposePhoneChange)
+	oldCode := otpCode(t, oldPhone, cache.PurposePhoneChange)
+	w := send("/me/phone/verify", token, `{"code":"`+newCode+`","old_code":"`+oldCode+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 status code but got %d", w.Code)
+	}
+	var res app.UserResponse
+	json.NewDecoder(w.Body).Decode(&res)
+	if res.Result.ID.Hex() != userID || res.Result.Phone != newPhone {
+		t.Fatalf("expected phone to be changed but got %+v", res.Result)
+	}
+
+	// sessions are revoked
+	if w := send("/me/phone", token, `{"phone":"09000000105"}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 status code but got %d", w.Code)
+	}
+
+	// the old number is on hold for others
+	if w := send("/me/phone", takenToken, `{"phone":"`+oldPhone+`"}`); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 status code but got %d", w.Code)
+	}
+	body, _ := json.Marshal(app.LoginRequest{Phone: oldPhone})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(string(body))))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 status code but got %d", w.Code)
+	}
+}
+
+func TestAccountDeletion(t *testing.T) {
+	handler := myApp.Routes()
+	phone := "09000000106"
+	token, userID := newToken(t, phone, entity.RoleUser)
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send(http.MethodGet, "/me/export", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 status code but got %d", w.Code)
+	}
+	var export app.ExportResponse
+	json.NewDecoder(w.Body).Decode(&export)
+	if export.User.Phone != phone || len(export.Sessions) != 1 {
+		t.Fatalf("unexpected export %+v", export)
+	}
+
+	// a code is sent first
+	if w := send(http.MethodDelete, "/me", ""); w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 status code but got %d", w.Code)
+	}
+	if w := send(http.MethodDelete, "/me", `{"code":"wrong"}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 status code but got %d", w.Code)
+	}
+	code := otpCode(t, phone, cache.PurposeDeleteAccount)
+	if w := send(http.MethodDelete, "/me", `{"code":"`+code+`"}`); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 status code but got %d", w.Code)
+	}
+
+	if _, err := myDB.FindUser(userID); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("expected user to be deleted but got %v", err)
+	}
+	if w := send(http.MethodGet, "/me", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 status code but got %d", w.Code)
+	}
+}
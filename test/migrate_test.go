@@ -0,0 +1,87 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aph138/dekamond/internal/db"
+	"github.com/aph138/dekamond/internal/entity"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func TestMongoMigrations(t *testing.T) {
+	connect := func() *db.MyMongo {
+		dbOpt := options.Client().SetAuth(options.Credential{
+			Username: DBUsername, Password: DBPassword,
+		})
+		mongo, err := db.NewMongo("mongodb://"+mongoEndpoint, "dekamond_migrate", time.Second*15, dbOpt, db.WithAutoMigrate(false))
+		if err != nil {
+			t.Fatalf("err when connecting to mongo %s", err.Error())
+		}
+		t.Cleanup(func() { mongo.Close(context.Background()) })
+		return mongo
+	}
+	mongo := connect()
+	pending, err := mongo.PendingMigrations(context.Background())
+	if err != nil {
+		t.Fatalf("err when checking migrations %s", err.Error())
+	}
+	if len(pending) == 0 {
+		t.Fatal("expected pending migrations on an empty database")
+	}
+
+	// a user which is registered before roles and statuses were introduced
+	legacyID, err := mongo.InsertOne(db.UserCollection, bson.M{"phone": "09000000301", "register_at": time.Now()})
+	if err != nil {
+		t.Fatalf("err when inserting legacy user %s", err.Error())
+	}
+
+	// replicas which start together apply every migration only once
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	applied := []string{}
+	for range 3 {
+		replica := connect()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			names, err := replica.Migrate(context.Background())
+			if err != nil {
+				t.Errorf("err when migrating %s", err.Error())
+			}
+			mu.Lock()
+			applied = append(applied, names...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	slices.Sort(applied)
+	if !slices.Equal(applied, pending) {
+		t.Fatalf("expected %v to be applied once but got %v", pending, applied)
+	}
+	if pending, _ := mongo.PendingMigrations(context.Background()); len(pending) != 0 {
+		t.Fatalf("expected no pending migrations but got %v", pending)
+	}
+
+	legacy, err := mongo.FindUser(legacyID.Hex())
+	if err != nil {
+		t.Fatalf("err when finding legacy user %s", err.Error())
+	}
+	if legacy.Status != entity.StatusActive || !slices.Equal(legacy.Roles, []string{entity.RoleUser}) {
+		t.Fatalf("expected legacy user to be backfilled but got %+v", legacy)
+	}
+
+	// the unique phone index is created
+	other, err := mongo.SaveUser("09000000302")
+	if err != nil {
+		t.Fatalf("err when saving user %s", err.Error())
+	}
+	if _, err := mongo.UpdateUserPhone(other.ID.Hex(), "09000000301"); !errors.Is(err, db.ErrDuplicate) {
+		t.Fatalf("expected ErrDuplicate but got %v", err)
+	}
+}
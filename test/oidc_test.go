@@ -0,0 +1,154 @@
+package test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aph138/dekamond/internal/app"
+	"github.com/aph138/dekamond/internal/entity"
+	"github.com/aph138/dekamond/pkg/authentication"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestOpenIDConnectFlow(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := authentication.NewIDTokenSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oidcApp := app.NewApplication(slog.New(slog.NewTextHandler(os.Stdout, nil)), myJWT, myCache, myDB,
+		app.WithOpenIDProvider(app.OpenIDConfig{Issuer: "http://localhost:9000", Signer: signer}),
+	)
+	handler := oidcApp.Routes()
+	redirectURI := "https://client.example.com/callback"
+	phone := "09011112222"
+
+	// register a client as admin
+	adminToken, _ := newToken(t, "09000000005", entity.RoleAdmin)
+	req := httptest.NewRequest(http.MethodPost, "/clients",
+		strings.NewReader(`{"name":"test client","redirect_uris":["`+redirectURI+`"]}`))
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 status code but got %d", w.Code)
+	}
+	var client app.ClientResponse
+	json.NewDecoder(w.Body).Decode(&client)
+
+	// start authorization with PKCE
+	verifier := "a-long-enough-code-verifier-for-the-test-case-1234"
+	sum := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {"openid phone"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/authorize?"+query.Encode(), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 status code but got %d", w.Code)
+	}
+	var authorize app.AuthorizeResponse
+	json.NewDecoder(w.Body).Decode(&authorize)
+
+	// login with OTP
+	code, err := myCache.NewOTPCode(phone)
+	if err != nil {
+		t.Fatalf("err when generating otp code %s", err.Error())
+	}
+	body, _ := json.Marshal(app.CheckRequest{Phone: phone, Code: code, AuthRequest: authorize.AuthRequest})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/check", strings.NewReader(string(body))))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 status code but got %d", w.Code)
+	}
+	var check app.CheckAuthorizeResponse
+	json.NewDecoder(w.Body).Decode(&check)
+	redirected, err := url.Parse(check.RedirectTo)
+	if err != nil || redirected.Query().Get("state") != "xyz" {
+		t.Fatalf("unexpected redirect %s", check.RedirectTo)
+	}
+
+	// exchange the code
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {redirected.Query().Get("code")},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+	exchange := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(client.ID, client.ClientSecret)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	w = exchange()
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 status code but got %d: %s", w.Code, w.Body.String())
+	}
+	var tokens app.TokenResponse
+	json.NewDecoder(w.Body).Decode(&tokens)
+
+	// the code can be used only once
+	if w := exchange(); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 status code for reused code but got %d", w.Code)
+	}
+
+	// verify id token
+	var claims authentication.IDTokenClaims
+	_, err = jwt.ParseWithClaims(tokens.IDToken, &claims, func(t *jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	})
+	if err != nil {
+		t.Fatalf("err when verifying id token %s", err.Error())
+	}
+	if claims.Nonce != "n-0S6" || claims.PhoneNumber != "+989011112222" {
+		t.Fatalf("unexpected id token claims %+v", claims)
+	}
+
+	// userinfo
+	req = httptest.NewRequest(http.MethodGet, "/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var info app.UserInfoResponse
+	json.NewDecoder(w.Body).Decode(&info)
+	if info.Sub != claims.Subject || info.PhoneNumber != claims.PhoneNumber {
+		t.Fatalf("unexpected userinfo %+v", info)
+	}
+
+	// the access token of a client can't be used for the rest of the api and has no roles
+	for _, path := range []string{"/me", "/search"} {
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Header().Get("WWW-Authenticate"), "insufficient_scope") {
+			t.Fatalf("expected insufficient_scope at %s but got %d", path, w.Code)
+		}
+	}
+	if value, err := myJWT.Parse(tokens.AccessToken); err != nil || value["roles"] != "" {
+		t.Fatalf("expected no roles in access token but got %v %v", value, err)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/redis/go-redis/v9"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/mongodb"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
 	redisContainer "github.com/testcontainers/testcontainers-go/modules/redis"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
@@ -29,6 +30,9 @@ import (
 const (
 	DBUsername = "test_user"
 	DBPassword = "test_password"
+
+	introspectionClientID     = "gateway"
+	introspectionClientSecret = "gateway_secret"
 )
 
 var (
@@ -37,6 +41,9 @@ var (
 )
 
 var myApp *app.Application
+var myJWT *authentication.JWT
+var myDB db.Database
+var myCache cache.Cache
 var containers = []testcontainers.Container{}
 
 func clean() {
@@ -77,6 +84,28 @@ func setup_mongo() (testcontainers.Container, error) {
 	containers = append(containers, mc)
 	return mc, nil
 }
+func setup_postgres() (string, error) {
+	pc, err := postgres.Run(
+		context.Background(),
+		"postgres:17-alpine",
+		postgres.WithDatabase("dekamond_test"),
+		postgres.WithUsername(DBUsername),
+		postgres.WithPassword(DBPassword),
+		// a collation which doesn't sort in byte order, so phone ranges are checked against it
+		testcontainers.WithEnv(map[string]string{"POSTGRES_INITDB_ARGS": "--locale-provider=icu --icu-locale=en-US"}),
+		postgres.BasicWaitStrategies(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("err when running postgres container %w", err)
+	}
+	containers = append(containers, pc)
+	address, err := pc.ConnectionString(context.Background(), "sslmode=disable")
+	if err != nil {
+		return "", fmt.Errorf("err when getting postgres connection string %w", err)
+	}
+	return address, nil
+}
+
 func setup() error {
 	_, err := setup_redis()
 	if err != nil {
@@ -98,6 +127,7 @@ func setup() error {
 		logger.Error(fmt.Sprintf("err when creating JWT instance: %s", err.Error()))
 		os.Exit(1)
 	}
+	myJWT = jwt
 	dbOpt := options.Client().SetAuth(options.Credential{
 		Username: DBUsername, Password: DBPassword,
 	})
@@ -112,7 +142,10 @@ func setup() error {
 	if err != nil {
 		log.Fatalln("err when connecting to redis", err.Error())
 	}
-	myApp = app.NewApplication(logger, jwt, myRedis, db)
+	myDB, myCache = db, myRedis
+	myApp = app.NewApplication(logger, jwt, myRedis, db,
+		app.WithIntrospectionClients(map[string]string{introspectionClientID: introspectionClientSecret}),
+	)
 	return nil
 }
 
@@ -0,0 +1,342 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aph138/dekamond/internal/app"
+	"github.com/aph138/dekamond/internal/cache"
+	"github.com/aph138/dekamond/internal/db"
+	"github.com/aph138/dekamond/internal/entity"
+)
+
+// searchApp runs the application with an empty database, so search results only have the users of the test.
+// It returns the routes, the database and a token of an admin.
+func searchApp(t *testing.T) (http.Handler, db.Database, string) {
+	t.Helper()
+	lite, err := db.NewSQLite("sqlite://"+t.TempDir()+"/dekamond.db", time.Second*15)
+	if err != nil {
+		t.Fatalf("err when opening sqlite %s", err.Error())
+	}
+	memory := cache.NewMemory()
+	t.Cleanup(func() {
+		memory.Close(context.Background())
+		lite.Close(context.Background())
+	})
+	searchApp := app.NewApplication(slog.New(slog.NewTextHandler(io.Discard, nil)), myJWT, memory, lite)
+	return searchApp.Routes(), lite, roleToken(t, lite, "09000000400", entity.RoleAdmin)
+}
+
+// roleToken grants the role to the user with the phone number, who has never logged in,
+// and returns a token with a session for it
+func roleToken(t *testing.T, database db.Database, phone, role string) string {
+	t.Helper()
+	user, err := database.GrantRoleByPhone(phone, role)
+	if err != nil {
+		t.Fatalf("err when granting role %s", err.Error())
+	}
+	sessionID, err := database.CreateSession(&entity.Session{
+		UserID:    user.ID,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("err when creating session %s", err.Error())
+	}
+	token, err := myJWT.NewToken(map[string]string{
+		"id":    user.ID.Hex(),
+		"roles": strings.Join(user.GetRoles(), ","),
+		"scope": app.FirstPartyScope,
+		"sid":   sessionID,
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("err when generating token %s", err.Error())
+	}
+	return token
+}
+
+func search(t *testing.T, handler http.Handler, token string, query url.Values) (*httptest.ResponseRecorder, app.SearchResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/search?"+query.Encode(), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var res app.SearchResponse
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(strings.NewReader(w.Body.String())).Decode(&res); err != nil {
+			t.Fatalf("err when decoding search response %s", err.Error())
+		}
+	}
+	return w, res
+}
+
+func TestSearchCursor(t *testing.T) {
+	handler, database, token := searchApp(t)
+	phones := []string{"09000000400"}
+	for _, phone := range []string{"09000000401", "09000000402", "09000000403", "09000000404"} {
+		time.Sleep(time.Millisecond * 5)
+		if _, err := database.SaveUser(phone); err != nil {
+			t.Fatalf("err when saving user %s", err.Error())
+		}
+		phones = append(phones, phone)
+	}
+	slices.Reverse(phones)
+
+	result := []string{}
+	query := url.Values{"limit": {"2"}}
+	for range len(phones) {
+		w, res := search(t, handler, token, query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200 status code but got %d", w.Code)
+		}
+		for _, user := range res.Result {
+			result = append(result, user.Phone)
+		}
+		if res.NextCursor == "" {
+			break
+		}
+		query.Set("cursor", res.NextCursor)
+	}
+	if !slices.Equal(result, phones) {
+		t.Fatalf("expected %v but got %v", phones, result)
+	}
+
+	if w, _ := search(t, handler, token, url.Values{"cursor": {"invalid"}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 status code for invalid cursor but got %d", w.Code)
+	}
+	_, res := search(t, handler, token, url.Values{"limit": {"1"}})
+	if w, _ := search(t, handler, token, url.Values{"cursor": {res.NextCursor}, "page": {"2"}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 status code for page and cursor but got %d", w.Code)
+	}
+}
+
+func TestSearchPaginationMetadata(t *testing.T) {
+	handler, database, token := searchApp(t)
+	for _, phone := range []string{"09000000401", "09000000402", "09000000403", "09000000404"} {
+		if _, err := database.SaveUser(phone); err != nil {
+			t.Fatalf("err when saving user %s", err.Error())
+		}
+	}
+
+	w, res := search(t, handler, token, url.Values{"limit": {"2"}, "page": {"2"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 status code but got %d", w.Code)
+	}
+	if res.Total == nil || *res.Total != 5 || res.Page != 2 || res.Limit != 2 || !res.HasMore || len(res.Result) != 2 {
+		t.Fatalf("unexpected pagination metadata %+v", res)
+	}
+	links := w.Header().Values("Link")
+	expected := []string{
+		`</search?limit=2&page=3>; rel="next"`,
+		`</search?limit=2&page=1>; rel="prev"`,
+	}
+	if !slices.Equal(links, expected) {
+		t.Fatalf("expected %v but got %v", expected, links)
+	}
+
+	// the last page has no next link
+	w, res = search(t, handler, token, url.Values{"limit": {"2"}, "page": {"3"}})
+	if res.HasMore || len(res.Result) != 1 || len(w.Header().Values("Link")) != 1 {
+		t.Fatalf("expected only the prev link on the last page but got %+v %v", res, w.Header().Values("Link"))
+	}
+
+	// counting is skipped
+	w, res = search(t, handler, token, url.Values{"limit": {"2"}, "count": {"false"}})
+	if res.Total != nil || strings.Contains(w.Body.String(), `"total"`) {
+		t.Fatalf("expected no total but got %s", w.Body.String())
+	}
+	if links := w.Header().Values("Link"); len(links) != 1 || !strings.Contains(links[0], `rel="next"`) {
+		t.Fatalf("expected only the next link on the first page but got %v", links)
+	}
+
+	// cursors only have a next link
+	w, res = search(t, handler, token, url.Values{"limit": {"2"}, "cursor": {res.NextCursor}})
+	links = w.Header().Values("Link")
+	if res.Page != 0 || len(links) != 1 || !strings.Contains(links[0], "cursor="+url.QueryEscape(res.NextCursor)) {
+		t.Fatalf("expected a next link with the cursor but got %+v %v", res, links)
+	}
+
+	// pages can't be larger than the maximum
+	if _, res := search(t, handler, token, url.Values{"limit": {"1000"}}); res.Limit != db.MaxSearchLimit || len(res.Result) != 5 {
+		t.Fatalf("expected limit %d but got %+v", db.MaxSearchLimit, res)
+	}
+	if w, _ := search(t, handler, token, url.Values{"count": {"maybe"}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 status code but got %d", w.Code)
+	}
+}
+
+func TestSearchRangesAndSort(t *testing.T) {
+	handler, database, token := searchApp(t)
+	time.Sleep(time.Millisecond * 5)
+	if _, err := database.SaveUser("09000000401"); err != nil {
+		t.Fatalf("err when saving user %s", err.Error())
+	}
+	phones := func(query string) []string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/search?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200 status code for %s but got %d %s", query, w.Code, w.Body.String())
+		}
+		var res app.SearchResponse
+		json.NewDecoder(w.Body).Decode(&res)
+		result := []string{}
+		for _, user := range res.Result {
+			result = append(result, user.Phone)
+		}
+		return result
+	}
+	tehran, err := time.LoadLocation("Asia/Tehran")
+	if err != nil {
+		t.Fatalf("err when loading time zone %s", err.Error())
+	}
+	today := time.Now().In(tehran).Format("2006-01-02")
+	hourAgo := time.Now().Add(-time.Hour).In(tehran).Format(time.RFC3339)
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"register=2000-01-01,", []string{"09000000401", "09000000400"}},
+		{"register=,2000-01-01", []string{}},
+		// the whole day is included
+		{"register=," + today + "&tz=Asia/Tehran", []string{"09000000401", "09000000400"}},
+		// + isn't escaped
+		{"register=" + hourAgo + ",", []string{"09000000401", "09000000400"}},
+		{"register=" + url.QueryEscape(hourAgo) + ",&sort=register_at", []string{"09000000400", "09000000401"}},
+		// the admin has never logged in
+		{"last_login=2000-01-01,", []string{"09000000401"}},
+		{"sort=last_login", []string{"09000000400", "09000000401"}},
+		{"sort=-last_login", []string{"09000000401", "09000000400"}},
+	}
+	for _, test := range tests {
+		if result := phones(test.query); !slices.Equal(result, test.expected) {
+			t.Fatalf("%s: expected %v but got %v", test.query, test.expected, result)
+		}
+	}
+
+	for _, query := range []string{
+		"register=2024-01-01", "register=,", "register=2024-02-01,2024-01-01", "register=yesterday,",
+		"last_login=2024-01-01T00:00:00,", "tz=Mars/Olympus", "sort=phone", "sort=+last_login",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/search?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 status code for %s but got %d", query, w.Code)
+		}
+	}
+}
+
+func TestSearchPhonePrefix(t *testing.T) {
+	handler, database, token := searchApp(t)
+	for _, phone := range []string{"09120001234", "09120002234", "09120005678"} {
+		if _, err := database.SaveUser(phone); err != nil {
+			t.Fatalf("err when saving user %s", err.Error())
+		}
+	}
+
+	tests := []struct {
+		prefix string
+		total  int64
+	}{
+		{"0912000", 3},
+		{"09120001", 1},
+		{"0912000*234", 2},
+		{"0000000", 0},
+	}
+	for _, test := range tests {
+		w, res := search(t, handler, token, url.Values{"phone_prefix": {test.prefix}})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200 status code for %s but got %d", test.prefix, w.Code)
+		}
+		if res.Total == nil || *res.Total != test.total || int64(len(res.Result)) != test.total {
+			t.Fatalf("expected %d users for %s but got %+v", test.total, test.prefix, res)
+		}
+	}
+
+	for _, query := range []url.Values{
+		{"phone_prefix": {"091200"}},
+		{"phone_prefix": {"0912***1234"}},
+		{"phone_prefix": {"0912000"}, "phone": {"09120001234"}},
+	} {
+		if w, _ := search(t, handler, token, query); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 status code for %v but got %d", query, w.Code)
+		}
+	}
+
+	// support staff get masked numbers, partial numbers would reveal the masked digits
+	support := roleToken(t, database, "09000000410", entity.RoleSupport)
+	if w, _ := search(t, handler, support, url.Values{"phone_prefix": {"0912000"}}); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 status code for support but got %d", w.Code)
+	}
+	if w, res := search(t, handler, support, url.Values{"phone": {"09120001234"}}); w.Code != http.StatusOK ||
+		len(res.Result) != 1 || res.Result[0].Phone != "0912***1234" {
+		t.Fatalf("expected the masked user for the whole number but got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSearchFields(t *testing.T) {
+	handler, database, token := searchApp(t)
+	user, err := database.SaveUser("09121234567")
+	if err != nil {
+		t.Fatalf("err when saving user %s", err.Error())
+	}
+	email := "user@example.com"
+	if _, err := database.UpdateUser(user.ID.Hex(), db.UserUpdate{Email: &email}); err != nil {
+		t.Fatalf("err when updating user %s", err.Error())
+	}
+	supportToken := roleToken(t, database, "09000000420", entity.RoleSupport)
+	// raw returns the JSON fields of the searched user
+	raw := func(token string, query url.Values) (int, map[string]any) {
+		t.Helper()
+		query.Set("phone", user.Phone)
+		w, _ := search(t, handler, token, query)
+		var res struct {
+			Result []map[string]any `json:"result"`
+		}
+		if w.Code != http.StatusOK {
+			return w.Code, nil
+		}
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil || len(res.Result) != 1 {
+			t.Fatalf("expected one user but got %v %s", err, w.Body.String())
+		}
+		return w.Code, res.Result[0]
+	}
+
+	_, fields := raw(token, url.Values{"fields": {"id,phone"}})
+	if len(fields) != 2 || fields["id"] != user.ID.Hex() || fields["phone"] != user.Phone {
+		t.Fatalf("expected only id and phone but got %v", fields)
+	}
+	// admins read every field
+	if _, fields := raw(token, url.Values{}); fields["email"] != email || fields["phone"] != user.Phone {
+		t.Fatalf("expected the email and phone but got %v", fields)
+	}
+	// support staff get masked numbers without contact details
+	_, fields = raw(supportToken, url.Values{})
+	if _, ok := fields["email"]; ok || fields["phone"] != "0912***4567" || fields["status"] != entity.StatusActive {
+		t.Fatalf("expected a masked phone without email but got %v", fields)
+	}
+	if _, fields := raw(supportToken, url.Values{"fields": {"phone"}}); len(fields) != 1 || fields["phone"] != "0912***4567" {
+		t.Fatalf("expected only a masked phone but got %v", fields)
+	}
+
+	if code, _ := raw(supportToken, url.Values{"fields": {"id,email"}}); code != http.StatusForbidden {
+		t.Fatalf("expected 403 status code for email but got %d", code)
+	}
+	if code, _ := raw(token, url.Values{"fields": {"password"}}); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 status code for an unknown field but got %d", code)
+	}
+}
@@ -0,0 +1,55 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aph138/dekamond/internal/app"
+	"github.com/aph138/dekamond/internal/cache"
+	"github.com/aph138/dekamond/internal/db"
+)
+
+func TestStandalone(t *testing.T) {
+	lite, err := db.NewSQLite("sqlite://"+t.TempDir()+"/dekamond.db", time.Second*15)
+	if err != nil {
+		t.Fatalf("err when opening sqlite %s", err.Error())
+	}
+	memory := cache.NewMemory()
+	standalone := app.NewApplication(slog.New(slog.NewTextHandler(io.Discard, nil)), myJWT, memory, lite)
+	defer memory.Close(context.Background())
+	defer lite.Close(context.Background())
+	handler := standalone.Routes()
+
+	phone := "09000000203"
+	code, err := memory.NewOTPCode(phone)
+	if err != nil {
+		t.Fatalf("err when generating otp code %s", err.Error())
+	}
+	if _, err := memory.NewOTPCode(phone); !errors.Is(err, cache.ErrOTPStillValid) {
+		t.Fatalf("expected ErrOTPStillValid but got %v", err)
+	}
+	body, _ := json.Marshal(app.CheckRequest{Phone: phone, Code: code})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/check", strings.NewReader(string(body))))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 status code but got %d", w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+w.Body.String())
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var res app.UserResponse
+	json.NewDecoder(w.Body).Decode(&res)
+	if w.Code != http.StatusOK || res.Result.Phone != phone {
+		t.Fatalf("expected the user but got %d %+v", w.Code, res.Result)
+	}
+}
@@ -0,0 +1,90 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aph138/dekamond/internal/app"
+	"github.com/aph138/dekamond/internal/entity"
+)
+
+func TestUserStats(t *testing.T) {
+	handler, database, token := searchApp(t)
+	for _, phone := range []string{"09000000501", "09000000502"} {
+		if _, err := database.SaveUser(phone); err != nil {
+			t.Fatalf("err when saving user %s", err.Error())
+		}
+	}
+	stats := func(token string, query url.Values) (*httptest.ResponseRecorder, app.UserStatsResponse) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/stats/users?"+query.Encode(), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var res app.UserStatsResponse
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(strings.NewReader(w.Body.String())).Decode(&res); err != nil {
+				t.Fatalf("err when decoding stats response %s", err.Error())
+			}
+		}
+		return w, res
+	}
+	// the admin of searchApp has never logged in
+	totals := func(res app.UserStatsResponse) (registrations, activeUsers int64) {
+		for _, bucket := range res.Result {
+			registrations += bucket.Registrations
+			activeUsers += bucket.ActiveUsers
+		}
+		return registrations, activeUsers
+	}
+
+	w, res := stats(token, url.Values{})
+	if w.Code != http.StatusOK || res.Interval != "day" || res.TimeZone != "UTC" || len(res.Result) != 30 {
+		t.Fatalf("expected 30 days but got %d %+v", w.Code, res)
+	}
+	if registrations, activeUsers := totals(res); registrations != 3 || activeUsers != 2 {
+		t.Fatalf("expected 3 registrations and 2 active users but got %d and %d", registrations, activeUsers)
+	}
+
+	// the stats are cached
+	if _, err := database.SaveUser("09000000503"); err != nil {
+		t.Fatalf("err when saving user %s", err.Error())
+	}
+	if _, res := stats(token, url.Values{}); len(res.Result) != 30 {
+		t.Fatalf("expected 30 days but got %+v", res)
+	} else if registrations, _ := totals(res); registrations != 3 {
+		t.Fatalf("expected the cached stats but got %d registrations", registrations)
+	}
+	w, res = stats(token, url.Values{"interval": {"month"}, "tz": {"Asia/Tehran"}})
+	if w.Code != http.StatusOK || len(res.Result) != 12 || res.TimeZone != "Asia/Tehran" {
+		t.Fatalf("expected 12 months but got %d %+v", w.Code, res)
+	}
+	if registrations, activeUsers := totals(res); registrations != 4 || activeUsers != 3 {
+		t.Fatalf("expected 4 registrations and 3 active users but got %d and %d", registrations, activeUsers)
+	}
+	w, res = stats(token, url.Values{"interval": {"week"}, "from": {"2025-01-01"}, "to": {"2025-01-31"}})
+	// the weeks of January 2025 start on Dec 30, Jan 6, 13, 20 and 27
+	if w.Code != http.StatusOK || len(res.Result) != 5 || res.Result[0].Start.Format("2006-01-02") != "2024-12-30" {
+		t.Fatalf("expected 5 weeks but got %d %+v", w.Code, res)
+	}
+
+	for _, query := range []url.Values{
+		{"interval": {"year"}},
+		{"tz": {"Mars/Olympus"}},
+		{"from": {"yesterday"}},
+		{"from": {"2025-02-01"}, "to": {"2025-01-01"}},
+		{"from": {"2000-01-01"}, "to": {"2025-01-01"}},
+	} {
+		if w, _ := stats(token, query); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %v but got %d", query, w.Code)
+		}
+	}
+	supportToken := roleToken(t, database, "09000000504", entity.RoleSupport)
+	if w, _ := stats(supportToken, url.Values{}); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for support but got %d", w.Code)
+	}
+}
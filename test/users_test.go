@@ -0,0 +1,209 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aph138/dekamond/internal/app"
+	"github.com/aph138/dekamond/internal/cache"
+	"github.com/aph138/dekamond/internal/db"
+	"github.com/aph138/dekamond/internal/entity"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestUserByID(t *testing.T) {
+	handler, database, token := searchApp(t)
+	user, err := database.SaveUser("09000000601")
+	if err != nil {
+		t.Fatalf("err when saving user %s", err.Error())
+	}
+	other, err := database.SaveUser("09000000602")
+	if err != nil {
+		t.Fatalf("err when saving user %s", err.Error())
+	}
+	supportToken := roleToken(t, database, "09000000603", entity.RoleSupport)
+	userURL := "/users/" + user.ID.Hex()
+	send := func(token, method, target, body string) (*httptest.ResponseRecorder, app.UserResponse) {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var res app.UserResponse
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(strings.NewReader(w.Body.String())).Decode(&res); err != nil {
+				t.Fatalf("err when decoding user response %s", err.Error())
+			}
+		}
+		return w, res
+	}
+
+	if w, res := send(token, http.MethodGet, userURL+"?fields=phone", ""); w.Code != http.StatusOK ||
+		res.Result.Phone != "09000000601" || !res.Result.RegisteredAt.IsZero() {
+		t.Fatalf("expected the user phone but got %d %s", w.Code, w.Body.String())
+	}
+	if w, _ := send(token, http.MethodGet, "/users/abc", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid ID but got %d", w.Code)
+	}
+	if w, _ := send(token, http.MethodGet, "/users/"+bson.NewObjectID().Hex(), ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown user but got %d", w.Code)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPatch, http.MethodDelete} {
+		if w, _ := send(supportToken, method, userURL, "{}"); w.Code != http.StatusForbidden {
+			t.Fatalf("expected 403 for %s by support but got %d", method, w.Code)
+		}
+	}
+
+	if _, err := database.CreateSession(&entity.Session{
+		UserID:    user.ID,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Minute),
+	}); err != nil {
+		t.Fatalf("err when creating session %s", err.Error())
+	}
+	w, res := send(token, http.MethodPatch, userURL, `{"display_name":"Ali","phone":"09000000604"}`)
+	if w.Code != http.StatusOK || res.Result.DisplayName != "Ali" || res.Result.Phone != "09000000604" {
+		t.Fatalf("expected the updated user but got %d %s", w.Code, w.Body.String())
+	}
+	// tokens are issued for the old number
+	if sessions, err := database.ListSessions(user.ID.Hex()); err != nil || len(sessions) != 0 {
+		t.Fatalf("expected no sessions after the phone change but got %d %v", len(sessions), err)
+	}
+	// the old number is held for the user
+	if w, _ := send(token, http.MethodPatch, "/users/"+other.ID.Hex(), `{"phone":"09000000601"}`); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a held phone but got %d %s", w.Code, w.Body.String())
+	}
+	for body, code := range map[string]int{
+		`{"phone":"09000000602"}`: http.StatusConflict,
+		`{"phone":"123"}`:         http.StatusBadRequest,
+		`{"email":"ali"}`:         http.StatusBadRequest,
+		`{"roles":["admin"]}`:     http.StatusBadRequest,
+	} {
+		if w, _ := send(token, http.MethodPatch, userURL, body); w.Code != code {
+			t.Fatalf("expected %d for %s but got %d %s", code, body, w.Code, w.Body.String())
+		}
+	}
+	// nothing changes, so nothing is audited
+	if w, _ := send(token, http.MethodPatch, userURL, `{"display_name":"Ali"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 but got %d", w.Code)
+	}
+	if w, _ := send(token, http.MethodPut, userURL+"/status", `{"status":"suspended"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 when suspending but got %d %s", w.Code, w.Body.String())
+	}
+
+	if _, err := database.CreateSession(&entity.Session{
+		UserID:    user.ID,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Minute),
+	}); err != nil {
+		t.Fatalf("err when creating session %s", err.Error())
+	}
+	admin, err := database.GrantRoleByPhone("09000000400", entity.RoleAdmin)
+	if err != nil {
+		t.Fatalf("err when finding admin %s", err.Error())
+	}
+	if w, _ := send(token, http.MethodDelete, "/users/"+admin.ID.Hex(), ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 when deleting yourself but got %d", w.Code)
+	}
+	if w, _ := send(token, http.MethodDelete, userURL, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 but got %d %s", w.Code, w.Body.String())
+	}
+	if w, _ := send(token, http.MethodGet, userURL, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a deleted user but got %d", w.Code)
+	}
+	if sessions, err := database.ListSessions(user.ID.Hex()); err != nil || len(sessions) != 0 {
+		t.Fatalf("expected no sessions but got %d %v", len(sessions), err)
+	}
+	if w, _ := send(token, http.MethodDelete, userURL, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for deleting twice but got %d", w.Code)
+	}
+
+	// the newest record comes first
+	records, err := database.ListAuditRecords(10)
+	if err != nil {
+		t.Fatalf("err when listing audit records %s", err.Error())
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 audit records but got %+v", records)
+	}
+	for _, record := range records {
+		if record.ActorID != admin.ID || record.TargetID != user.ID.Hex() {
+			t.Fatalf("unexpected audit record %+v", record)
+		}
+	}
+	if records[0].Action != entity.AuditUserDelete || records[0].Details["phone"] != "09000000604" {
+		t.Fatalf("unexpected delete audit record %+v", records[0])
+	}
+	if records[1].Action != entity.AuditUserStatus || records[1].Details["status"] != entity.StatusSuspended {
+		t.Fatalf("unexpected status audit record %+v", records[1])
+	}
+	if update := records[2]; update.Action != entity.AuditUserUpdate || len(update.Details) != 4 ||
+		update.Details["old_phone"] != "09000000601" || update.Details["phone"] != "09000000604" ||
+		update.Details["old_display_name"] != "" || update.Details["display_name"] != "Ali" {
+		t.Fatalf("unexpected update audit record %+v", update)
+	}
+	// the old number of a deleted user isn't held anymore
+	if w, _ := send(token, http.MethodPatch, "/users/"+other.ID.Hex(), `{"phone":"09000000601"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected the phone of a deleted user to be free but got %d %s", w.Code, w.Body.String())
+	}
+}
+
+// failingAudit is a database which can't save audit records
+type failingAudit struct {
+	db.Database
+}
+
+func (failingAudit) SaveAuditRecord(*entity.AuditRecord) error {
+	return errors.New("audit log is unavailable")
+}
+
+func TestUserActionWithoutAudit(t *testing.T) {
+	lite, err := db.NewSQLite("sqlite://"+t.TempDir()+"/dekamond.db", time.Second*15)
+	if err != nil {
+		t.Fatalf("err when opening sqlite %s", err.Error())
+	}
+	memory := cache.NewMemory()
+	t.Cleanup(func() {
+		memory.Close(context.Background())
+		lite.Close(context.Background())
+	})
+	handler := app.NewApplication(slog.New(slog.NewTextHandler(io.Discard, nil)), myJWT, memory, failingAudit{lite}).Routes()
+	token := roleToken(t, lite, "09000000400", entity.RoleAdmin)
+	user, err := lite.SaveUser("09000000601")
+	if err != nil {
+		t.Fatalf("err when saving user %s", err.Error())
+	}
+	userURL := "/users/" + user.ID.Hex()
+
+	// nothing is changed if the action can't be audited
+	for _, test := range []struct{ method, target, body string }{
+		{http.MethodPut, userURL + "/roles/" + entity.RoleSupport, ""},
+		{http.MethodPut, userURL + "/status", `{"status":"banned"}`},
+		{http.MethodPatch, userURL, `{"display_name":"Ali"}`},
+		{http.MethodDelete, userURL, ""},
+	} {
+		req := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("expected 500 for %s %s but got %d", test.method, test.target, w.Code)
+		}
+	}
+	found, err := lite.FindUser(user.ID.Hex())
+	if err != nil {
+		t.Fatalf("err when finding user %s", err.Error())
+	}
+	if found.GetStatus() != entity.StatusActive || found.DisplayName != "" || slices.Contains(found.Roles, entity.RoleSupport) {
+		t.Fatalf("expected the user to be unchanged but got %+v", found)
+	}
+}